@@ -0,0 +1,27 @@
+// Command arplookup-helper opens packet sockets on behalf of terraform-provider-arplookup, so that only this binary
+// needs the NET_RAW capability. It is started by the provider when `helper` is set, and should not be run directly.
+package main
+
+import (
+	"log"
+
+	"kernel.org/pub/linux/libs/security/libcap/cap"
+	"terraform-provider-arplookup/internal/privhelper"
+)
+
+// helperFD is the file descriptor the provider passes its end of the socket pair as.
+const helperFD = 3
+
+func main() {
+	// Raise NET_RAW in case the binary was only given it as a permitted capability
+	caps := cap.GetProc()
+	if ok, _ := caps.GetFlag(cap.Permitted, cap.NET_RAW); ok {
+		if err := caps.SetFlag(cap.Effective, true, cap.NET_RAW); err == nil {
+			caps.SetProc()
+		}
+	}
+
+	if err := privhelper.Serve(helperFD); err != nil {
+		log.Fatal(err.Error())
+	}
+}
@@ -0,0 +1,116 @@
+package arplookup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+)
+
+// Kinds of lookup failure. Errors returned by lookups can be matched against these with errors.Is.
+var (
+	ErrNotFound           = errors.New("host not found")
+	ErrTimedOut           = errors.New("lookup timed out")
+	ErrPermissionDenied   = errors.New("permission denied")
+	ErrInterfaceDown      = errors.New("interface is down")
+	ErrNoSourceAddress    = errors.New("interface has no source address")
+	ErrSocket             = errors.New("socket failure")
+	ErrInterfaceSelection = errors.New("unable to select interface")
+)
+
+// LookupError is a lookup failure of the kind given by one of the Err variables above.
+type LookupError struct {
+	Kind   error  // kind of failure, such as ErrNotFound
+	Detail string // description of the failure shown to the user
+	Err    error  // underlying cause, if any
+}
+
+func (e *LookupError) Error() string {
+	if e.Err == nil {
+		return e.Detail
+	}
+
+	return fmt.Sprintf("%s: %s", e.Detail, e.Err.Error())
+}
+
+// Is allows LookupError to be matched against its kind. Lookups that timed out also match ErrNotFound.
+func (e *LookupError) Is(target error) bool {
+	return target == e.Kind || (e.Kind == ErrTimedOut && target == ErrNotFound)
+}
+
+func (e *LookupError) Unwrap() error {
+	return e.Err
+}
+
+// PermissionError is returned when the process lacks the capabilities needed to perform a network operation.
+type PermissionError struct {
+	Capabilities []string // names of the missing capabilities, such as "cap_net_raw"
+	Err          error
+}
+
+func (e *PermissionError) Error() string {
+	msg := fmt.Sprintf("insufficient privilege to perform network operation - missing %s",
+		strings.Join(e.Capabilities, ", "))
+	if e.Err == nil {
+		return msg
+	}
+
+	return fmt.Sprintf("%s: %s", msg, e.Err.Error())
+}
+
+// Is allows PermissionError to be matched against ErrPermissionDenied.
+func (e *PermissionError) Is(target error) bool {
+	return target == ErrPermissionDenied
+}
+
+func (e *PermissionError) Unwrap() error {
+	return e.Err
+}
+
+// setcapCommand returns the command that grants the running binary the capabilities it needs.
+func setcapCommand() string {
+	binary, err := os.Executable()
+	if err != nil {
+		binary = "<path to terraform-provider-arplookup>"
+	}
+
+	return fmt.Sprintf("sudo setcap cap_net_raw,cap_net_admin=eip %s", binary)
+}
+
+// lookupDiagnostic maps an error from a lookup to a diagnostic whose summary and remediation depend on the kind of
+// failure. Errors of an unknown kind are reported under summary.
+func lookupDiagnostic(summary string, err error) diag.Diagnostic {
+	var permission *PermissionError
+	switch {
+	case errors.As(err, &permission), errors.Is(err, ErrPermissionDenied):
+		return diag.NewErrorDiagnostic("insufficient privileges for network lookup", fmt.Sprintf(
+			"%s\n\nThe provider needs the NET_RAW capability to use raw sockets, and NET_ADMIN to manage static neighbours "+
+				"and VLAN sub-interfaces. Without NET_ADMIN, found hosts are also not added to the neighbour table. Grant "+
+				"them to the provider binary with:\n\n  %s\n\n"+
+				"Alternatively, set the provider's `helper` attribute to an `arplookup-helper` binary holding NET_RAW.",
+			err.Error(), setcapCommand()))
+	case errors.Is(err, ErrInterfaceDown):
+		return diag.NewErrorDiagnostic("network interface is down", fmt.Sprintf(
+			"%s\n\nBring the interface up, for example with `ip link set <interface> up`, before looking up hosts on it.",
+			err.Error()))
+	case errors.Is(err, ErrNoSourceAddress):
+		return diag.NewErrorDiagnostic("network interface has no address", fmt.Sprintf(
+			"%s\n\nAssign an address to the interface so that requests can be sent from it.", err.Error()))
+	case errors.Is(err, ErrInterfaceSelection):
+		return diag.NewErrorDiagnostic("unable to select network interface", fmt.Sprintf(
+			"%s\n\nAn interface is only chosen automatically when every prefix in `network` is directly connected to the "+
+				"same interface. Set `interface` explicitly, or split `network` between data sources.", err.Error()))
+	case errors.Is(err, ErrSocket):
+		return diag.NewErrorDiagnostic("unable to open network socket", err.Error())
+	case errors.Is(err, ErrTimedOut):
+		return diag.NewErrorDiagnostic("lookup timed out", fmt.Sprintf(
+			"%s\n\nCheck that the host is up and within `network`, or increase `timeout`.", err.Error()))
+	case errors.Is(err, ErrNotFound):
+		return diag.NewErrorDiagnostic("host not found", fmt.Sprintf(
+			"%s\n\nCheck that the host is up and within `network`, or increase `max_attempts`.", err.Error()))
+	}
+
+	return diag.NewErrorDiagnostic(summary, err.Error())
+}
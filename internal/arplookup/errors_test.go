@@ -0,0 +1,101 @@
+package arplookup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// TestExpired checks whether a search ended by its context is reported as timed out only when the deadline was
+// exceeded, and that timeouts still match ErrNotFound.
+func TestExpired(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	<-ctx.Done()
+
+	err := expired(ctx, errNoIP)
+	if !errors.Is(err, ErrTimedOut) || !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected timed out error, got: %v", err)
+	}
+	if !strings.HasPrefix(err.Error(), errNoIP.Error()) {
+		t.Fatalf("expected error to begin with \"%s\", got: \"%s\"", errNoIP.Error(), err.Error())
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+
+	if err := expired(ctx, errNoIP); err != errNoIP {
+		t.Fatalf("expected errNoIP for a cancelled search, got: %v", err)
+	}
+}
+
+// TestLookupDiagnostic checks whether each kind of lookup error is mapped to its own diagnostic summary, even
+// when wrapped.
+func TestLookupDiagnostic(t *testing.T) {
+	testcases := []struct {
+		err     error
+		summary string
+		detail  string
+	}{
+		{
+			err:     errNoIP,
+			summary: "host not found",
+			detail:  "max_attempts",
+		},
+		{
+			err:     &LookupError{Kind: ErrTimedOut, Detail: "timed out"},
+			summary: "lookup timed out",
+			detail:  "timeout",
+		},
+		{
+			err:     &PermissionError{Capabilities: []string{"cap_net_raw"}},
+			summary: "insufficient privileges for network lookup",
+			detail:  "setcap cap_net_raw,cap_net_admin=eip",
+		},
+		{
+			err:     socketError("unable to open ARP socket on br0", syscall.EPERM),
+			summary: "insufficient privileges for network lookup",
+			detail:  "cap_net_raw",
+		},
+		{
+			err:     socketError("unable to open ARP socket on br0", syscall.ENODEV),
+			summary: "unable to open network socket",
+			detail:  "br0",
+		},
+		{
+			err:     &LookupError{Kind: ErrInterfaceDown, Detail: "network interface br0 is down"},
+			summary: "network interface is down",
+			detail:  "ip link set",
+		},
+		{
+			err:     &LookupError{Kind: ErrNoSourceAddress, Detail: "selected network interface has no assigned addresses"},
+			summary: "network interface has no address",
+			detail:  "Assign an address",
+		},
+		{
+			err:     &LookupError{Kind: ErrInterfaceSelection, Detail: "network spans 2 interfaces"},
+			summary: "unable to select network interface",
+			detail:  "Set `interface`",
+		},
+		{
+			err:     fmt.Errorf("unknown"),
+			summary: "issue encountered while looking up IP",
+			detail:  "unknown",
+		},
+	}
+
+	for _, test := range testcases {
+		wrapped := fmt.Errorf("error running getIPFor: %w", test.err)
+
+		d := lookupDiagnostic("issue encountered while looking up IP", wrapped)
+		if d.Summary() != test.summary {
+			t.Fatalf("expected summary \"%s\" for %v, got: \"%s\"", test.summary, test.err, d.Summary())
+		}
+		if !strings.Contains(d.Detail(), wrapped.Error()) || !strings.Contains(d.Detail(), test.detail) {
+			t.Fatalf("expected detail containing \"%s\" for %v, got: \"%s\"", test.detail, test.err, d.Detail())
+		}
+	}
+}
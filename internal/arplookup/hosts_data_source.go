@@ -0,0 +1,287 @@
+package arplookup
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// Ensure provider defined types fully satisfy framework interfaces
+var _ tfsdk.DataSourceType = hostsDataSourceType{}
+var _ tfsdk.DataSource = hostsDataSource{}
+
+type hostsDataSourceType struct{}
+
+func (t hostsDataSourceType) GetSchema(ctx context.Context) (tfsdk.Schema, diag.Diagnostics) {
+	return tfsdk.Schema{
+		MarkdownDescription: "This data source will sweep `network` and list every host that responds or is in the system's ARP cache. ",
+		Attributes: map[string]tfsdk.Attribute{
+			"network": {
+				MarkdownDescription: "Network to sweep for hosts. IPv6 prefixes are searched using neighbor discovery, and must be `/112` or longer.",
+				Optional:            true,
+				Type: types.ListType{
+					ElemType: types.StringType,
+				},
+				PlanModifiers: tfsdk.AttributePlanModifiers{
+					tfsdk.RequiresReplace(),
+				},
+				Validators: []tfsdk.AttributeValidator{
+					networkValidator{},
+				},
+			},
+			"parallelism": {
+				MarkdownDescription: "How many hosts to send requests to concurrently while scanning `network`.",
+				Optional:            true,
+				Type:                types.Int64Type,
+				Validators: []tfsdk.AttributeValidator{
+					positiveIntValidator{},
+				},
+			},
+			"rate_limit": {
+				MarkdownDescription: "Maximum number of requests to send per second while scanning `network`.",
+				Optional:            true,
+				Type:                types.Int64Type,
+				Validators: []tfsdk.AttributeValidator{
+					positiveIntValidator{},
+				},
+			},
+			"warm_cache": {
+				MarkdownDescription: "Whether to add hosts found by sending requests to the system's neighbour table. Overrides the provider's `warm_cache`.",
+				Optional:            true,
+				Type:                types.BoolType,
+			},
+			"cache_state": {
+				MarkdownDescription: "State to add found hosts to the system's neighbour table in, one of `reachable`, `stale` or `permanent`. Overrides the provider's `cache_state`.",
+				Optional:            true,
+				Type:                types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					cacheStateValidator{},
+				},
+			},
+			"scan_mode": {
+				MarkdownDescription: "How to scan `network`, either `request` or `async`.",
+				Optional:            true,
+				Type:                types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					scanModeValidator{},
+				},
+			},
+			"transport": {
+				MarkdownDescription: "How ARP packets are passed to and from the kernel, either `socket` or `ring`. `ring` can only be used with IPv4 networks.",
+				Optional:            true,
+				Type:                types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					transportValidator{},
+				},
+			},
+			"timeout": {
+				MarkdownDescription: "How long to search for before giving up. Overrides the provider's `timeout`.",
+				Optional:            true,
+				Type:                types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					timeValidator{},
+				},
+			},
+			"request_timeout": {
+				MarkdownDescription: "How long to wait for a reply to each request sent while scanning `network`.",
+				Optional:            true,
+				Type:                types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					timeValidator{},
+				},
+			},
+			"source_ip": {
+				MarkdownDescription: "IPv4 address of `interface` to send ARP requests from. Defaults to the address whose prefix overlaps `network`, or the first IPv4 address of `interface`.",
+				Optional:            true,
+				Type:                types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					sourceIPValidator{},
+				},
+			},
+			"vlan_id": {
+				MarkdownDescription: "802.1Q VLAN to search on `interface`. An existing sub-interface for the VLAN is used if there is one, otherwise a temporary one is created with `source_ip` assigned to it, which requires the NET_ADMIN capability.",
+				Optional:            true,
+				Type:                types.Int64Type,
+				Validators: []tfsdk.AttributeValidator{
+					vlanIDValidator{},
+				},
+			},
+			"accept_states": {
+				MarkdownDescription: "Neighbour table states to accept when checking the system's cache, out of `reachable`, `stale`, `delay`, `probe`, `permanent`, `noarp`, `incomplete` and `failed`. Defaults to every state except `incomplete` and `failed`.",
+				Optional:            true,
+				Type: types.SetType{
+					ElemType: types.StringType,
+				},
+				Validators: []tfsdk.AttributeValidator{
+					neighStatesValidator{},
+				},
+			},
+			"interface": {
+				MarkdownDescription: "Interface to bind to when searching for machines.",
+				Required:            true,
+				Type:                types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					interfaceValidator{},
+				},
+			},
+			"hosts": {
+				MarkdownDescription: "Hosts found on `network`, ordered by IP address.",
+				Computed:            true,
+				Attributes: tfsdk.ListNestedAttributes(map[string]tfsdk.Attribute{
+					"ip": {
+						MarkdownDescription: "IP address of the host.",
+						Computed:            true,
+						Type:                types.StringType,
+					},
+					"mac": {
+						MarkdownDescription: "MAC address of the host.",
+						Computed:            true,
+						Type:                types.StringType,
+					},
+					"vendor_oui": {
+						MarkdownDescription: "Organizationally unique identifier of the host's MAC address.",
+						Computed:            true,
+						Type:                types.StringType,
+					},
+					"source": {
+						MarkdownDescription: "How the host was found, either `arp` for a reply to a request or `cache` for the system's ARP cache.",
+						Computed:            true,
+						Type:                types.StringType,
+					},
+				}),
+			},
+			"method": {
+				MarkdownDescription: "How hosts were looked up. `raw` sends ARP and neighbor discovery requests over raw sockets, `helper` opens them through the provider's `helper`, and `udp` sends unprivileged UDP probes and reads replies from the system's neighbour table, which is used when the provider lacks the NET_RAW capability.",
+				Computed:            true,
+				Type:                types.StringType,
+			},
+			"id": {
+				MarkdownDescription: "Unique identifier.",
+				Type:                types.StringType,
+				Computed:            true,
+			},
+		},
+	}, nil
+}
+
+func (t hostsDataSourceType) NewDataSource(ctx context.Context, in tfsdk.Provider) (tfsdk.DataSource, diag.Diagnostics) {
+	provider, diags := convertProviderType(in)
+	return hostsDataSource{
+		provider: provider,
+	}, diags
+}
+
+type hostData struct {
+	IP        types.String `tfsdk:"ip"`
+	MAC       types.String `tfsdk:"mac"`
+	VendorOUI types.String `tfsdk:"vendor_oui"`
+	Source    types.String `tfsdk:"source"`
+}
+
+type hostsDataSourceData struct {
+	SourceIP       types.String `tfsdk:"source_ip"`
+	VLANID         types.Int64  `tfsdk:"vlan_id"`
+	Timeout        types.String `tfsdk:"timeout"`
+	RequestTimeout types.String `tfsdk:"request_timeout"`
+	Network        types.List   `tfsdk:"network"`
+	Interface      types.String `tfsdk:"interface"`
+	AcceptStates   types.Set    `tfsdk:"accept_states"`
+	Parallelism    types.Int64  `tfsdk:"parallelism"`
+	RateLimit      types.Int64  `tfsdk:"rate_limit"`
+	ScanMode       types.String `tfsdk:"scan_mode"`
+	Transport      types.String `tfsdk:"transport"`
+	WarmCache      types.Bool   `tfsdk:"warm_cache"`
+	CacheState     types.String `tfsdk:"cache_state"`
+	Hosts          []hostData   `tfsdk:"hosts"`
+	Method         types.String `tfsdk:"method"`
+	Id             types.String `tfsdk:"id"`
+}
+
+type hostsDataSource struct {
+	provider *provider
+}
+
+// oui returns the organizationally unique identifier portion of a MAC address.
+func oui(mac net.HardwareAddr) string {
+	if len(mac) < 3 {
+		return ""
+	}
+
+	return mac[:3].String()
+}
+
+func (data *hostsDataSourceData) read(ctx context.Context, rt *providerRuntime) error {
+	search, err := rt.searchData(ctx, searchConfig{
+		SourceIP:       data.SourceIP,
+		VLANID:         data.VLANID,
+		Timeout:        data.Timeout,
+		RequestTimeout: data.RequestTimeout,
+		Network:        data.Network,
+		Interface:      data.Interface,
+		AcceptStates:   data.AcceptStates,
+		Parallelism:    data.Parallelism,
+		RateLimit:      data.RateLimit,
+		ScanMode:       data.ScanMode,
+		Transport:      data.Transport,
+		WarmCache:      data.WarmCache,
+		CacheState:     data.CacheState,
+	})
+	if err != nil {
+		return err
+	}
+
+	data.Method = types.String{Value: lookupMethod(search)}
+
+	ctx, cancel := context.WithTimeout(ctx, search.timeout)
+	defer cancel()
+
+	found, err := getHostsFor(ctx, rt.clients, search)
+	if err != nil {
+		return fmt.Errorf("error running getHostsFor: %w", err)
+	}
+
+	data.Hosts = make([]hostData, len(found))
+	for i, host := range found {
+		source := "arp"
+		if host.cached {
+			source = "cache"
+		}
+
+		data.Hosts[i] = hostData{
+			IP:        types.String{Value: host.IP.String()},
+			MAC:       types.String{Value: host.mac.String()},
+			VendorOUI: types.String{Value: oui(host.mac)},
+			Source:    types.String{Value: source},
+		}
+	}
+
+	data.Id = types.String{Value: fmt.Sprintf("%s/%s", search.iface.Name, search.network.Ranges())}
+
+	return nil
+}
+
+func (hostsDataSource hostsDataSource) Read(ctx context.Context, req tfsdk.ReadDataSourceRequest, resp *tfsdk.ReadDataSourceResponse) {
+	var data hostsDataSourceData
+	diags := req.Config.Get(ctx, &data)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	rt := hostsDataSource.provider.runtime(&resp.Diagnostics)
+	if rt == nil {
+		return
+	}
+
+	if err := data.read(ctx, rt); err != nil {
+		resp.Diagnostics.Append(lookupDiagnostic("issue encountered while discovering hosts", err))
+		return
+	}
+
+	diags = resp.State.Set(ctx, &data)
+	resp.Diagnostics.Append(diags...)
+}
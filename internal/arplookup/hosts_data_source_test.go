@@ -0,0 +1,43 @@
+package arplookup
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+)
+
+// Test whether every host on a network is discovered
+func TestAccHostsDataSource(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().UTC().Unix()))
+
+	testAccTest(t, []resource.TestStep{
+		{
+			PreConfig: func() {
+				testAccNeedle(t, r, mac, ip, network, index)
+			},
+			Config: testAccHostsDataSourceConfig,
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckTypeSetElemNestedAttrs("data.arplookup_hosts.test", "hosts.*", map[string]string{
+					"ip":         ip,
+					"mac":        mac,
+					"vendor_oui": mac[:8],
+				}),
+			),
+		},
+	})
+}
+
+var testAccHostsDataSourceConfig = `
+provider "arplookup" {
+  timeout = "30s"
+}
+
+data "arplookup_hosts" "test" {
+  interface = "br0"
+  network = [
+    "10.18.6.0/24"
+  ]
+}
+`
@@ -2,10 +2,9 @@ package arplookup
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
-	"reflect"
-	"time"
 
 	"github.com/hashicorp/terraform-plugin-framework/diag"
 	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
@@ -43,7 +42,7 @@ func (t ipDataSourceType) GetSchema(ctx context.Context) (tfsdk.Schema, diag.Dia
 				},
 			},
 			"network": {
-				MarkdownDescription: "Network to search for macaddr in.",
+				MarkdownDescription: "Network to search for macaddr in. IPv6 prefixes are searched using neighbor discovery, and must be `/112` or longer.",
 				Optional:            true,
 				Type: types.ListType{
 					ElemType: types.StringType,
@@ -55,16 +54,161 @@ func (t ipDataSourceType) GetSchema(ctx context.Context) (tfsdk.Schema, diag.Dia
 					networkValidator{},
 				},
 			},
+			"parallelism": {
+				MarkdownDescription: "How many hosts to send requests to concurrently while scanning `network`.",
+				Optional:            true,
+				Type:                types.Int64Type,
+				Validators: []tfsdk.AttributeValidator{
+					positiveIntValidator{},
+				},
+			},
+			"rate_limit": {
+				MarkdownDescription: "Maximum number of requests to send per second while scanning `network`.",
+				Optional:            true,
+				Type:                types.Int64Type,
+				Validators: []tfsdk.AttributeValidator{
+					positiveIntValidator{},
+				},
+			},
+			"warm_cache": {
+				MarkdownDescription: "Whether to add hosts found by sending requests to the system's neighbour table. Overrides the provider's `warm_cache`.",
+				Optional:            true,
+				Type:                types.BoolType,
+			},
+			"cache_state": {
+				MarkdownDescription: "State to add found hosts to the system's neighbour table in, one of `reachable`, `stale` or `permanent`. Overrides the provider's `cache_state`.",
+				Optional:            true,
+				Type:                types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					cacheStateValidator{},
+				},
+			},
+			"scan_mode": {
+				MarkdownDescription: "How to scan `network`, either `request` or `async`.",
+				Optional:            true,
+				Type:                types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					scanModeValidator{},
+				},
+			},
+			"transport": {
+				MarkdownDescription: "How ARP packets are passed to and from the kernel, either `socket` or `ring`. `ring` can only be used with IPv4 networks.",
+				Optional:            true,
+				Type:                types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					transportValidator{},
+				},
+			},
+			"timeout": {
+				MarkdownDescription: "How long to search for before giving up. Overrides the provider's `timeout`.",
+				Optional:            true,
+				Type:                types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					timeValidator{},
+				},
+			},
+			"request_timeout": {
+				MarkdownDescription: "How long to wait for a reply to each request sent while scanning `network`.",
+				Optional:            true,
+				Type:                types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					timeValidator{},
+				},
+			},
+			"max_attempts": {
+				MarkdownDescription: "How many scans of `network` to make before giving up.",
+				Optional:            true,
+				Type:                types.Int64Type,
+				Validators: []tfsdk.AttributeValidator{
+					positiveIntValidator{},
+				},
+			},
+			"source_ip": {
+				MarkdownDescription: "IPv4 address of `interface` to send ARP requests from. Defaults to the address whose prefix overlaps `network`, or the first IPv4 address of `interface`.",
+				Optional:            true,
+				Type:                types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					sourceIPValidator{},
+				},
+			},
+			"vlan_id": {
+				MarkdownDescription: "802.1Q VLAN to search on `interface`. An existing sub-interface for the VLAN is used if there is one, otherwise a temporary one is created with `source_ip` assigned to it, which requires the NET_ADMIN capability.",
+				Optional:            true,
+				Type:                types.Int64Type,
+				Validators: []tfsdk.AttributeValidator{
+					vlanIDValidator{},
+				},
+			},
+			"accept_states": {
+				MarkdownDescription: "Neighbour table states to accept when checking the system's cache, out of `reachable`, `stale`, `delay`, `probe`, `permanent`, `noarp`, `incomplete` and `failed`. Defaults to every state except `incomplete` and `failed`.",
+				Optional:            true,
+				Type: types.SetType{
+					ElemType: types.StringType,
+				},
+				Validators: []tfsdk.AttributeValidator{
+					neighStatesValidator{},
+				},
+			},
+			"passive": {
+				MarkdownDescription: "Wait for the host to announce itself with an ARP packet or neighbor solicitation instead of sweeping `network`. Defaults to false.",
+				Optional:            true,
+				Type:                types.BoolType,
+			},
+			"dhcp_snoop": {
+				MarkdownDescription: "Also listen on `interface` for a DHCPACK leasing an IP in `network` to `macaddr`. Defaults to false.",
+				Optional:            true,
+				Type:                types.BoolType,
+			},
 			"interface": {
-				MarkdownDescription: "Interface to bind to when searching for machines.",
-				Required:            true,
+				MarkdownDescription: "Interface to bind to when searching for machines. If neither this nor `interfaces` is set, the interface every prefix in `network` is directly connected to is chosen from the routing table. Set to the interface the host was found on.",
+				Optional:            true,
+				Computed:            true,
 				Type:                types.StringType,
 				Validators: []tfsdk.AttributeValidator{
 					interfaceValidator{},
 				},
 			},
+			"interfaces": {
+				MarkdownDescription: "Interfaces to search concurrently, each on its own `network` or the data source's. The interface the host was found on is reported in `interface`. Conflicts with `interface`.",
+				Optional:            true,
+				Attributes: tfsdk.ListNestedAttributes(map[string]tfsdk.Attribute{
+					"name": {
+						MarkdownDescription: "Interface to bind to.",
+						Required:            true,
+						Type:                types.StringType,
+						Validators: []tfsdk.AttributeValidator{
+							interfaceValidator{},
+						},
+					},
+					"network": {
+						MarkdownDescription: "Network to search on this interface. Defaults to the data source's `network`.",
+						Optional:            true,
+						Type: types.ListType{
+							ElemType: types.StringType,
+						},
+						Validators: []tfsdk.AttributeValidator{
+							networkValidator{},
+						},
+					},
+				}),
+			},
+			"fail_on_not_found": {
+				MarkdownDescription: "Whether to fail if no host matching `macaddr` is found. If false a warning is emitted instead, `ip` is null and `found` is false. Defaults to true.",
+				Optional:            true,
+				Type:                types.BoolType,
+			},
+			"found": {
+				MarkdownDescription: "Whether a host matching `macaddr` was found.",
+				Computed:            true,
+				Type:                types.BoolType,
+			},
 			"ip": {
-				MarkdownDescription: "Resultant IP address.",
+				MarkdownDescription: "Resultant IP address, or null if no host was found and `fail_on_not_found` is false.",
+				Computed:            true,
+				Type:                types.StringType,
+			},
+			"method": {
+				MarkdownDescription: "How hosts were looked up. `raw` sends ARP and neighbor discovery requests over raw sockets, `helper` opens them through the provider's `helper`, and `udp` sends unprivileged UDP probes and reads replies from the system's neighbour table, which is used when the provider lacks the NET_RAW capability.",
 				Computed:            true,
 				Type:                types.StringType,
 			},
@@ -85,67 +229,189 @@ func (t ipDataSourceType) NewDataSource(ctx context.Context, in tfsdk.Provider)
 }
 
 type ipDataSourceData struct {
-	Backoff   types.String `tfsdk:"backoff"`
-	Network   types.List   `tfsdk:"network"`
-	MACAddr   types.String `tfsdk:"macaddr"`
-	Interface types.String `tfsdk:"interface"`
-	IP        types.String `tfsdk:"ip"`
-	Id        types.String `tfsdk:"id"`
+	SourceIP       types.String      `tfsdk:"source_ip"`
+	VLANID         types.Int64       `tfsdk:"vlan_id"`
+	Timeout        types.String      `tfsdk:"timeout"`
+	RequestTimeout types.String      `tfsdk:"request_timeout"`
+	MaxAttempts    types.Int64       `tfsdk:"max_attempts"`
+	Backoff        types.String      `tfsdk:"backoff"`
+	Network        types.List        `tfsdk:"network"`
+	MACAddr        types.String      `tfsdk:"macaddr"`
+	Interface      types.String      `tfsdk:"interface"`
+	AcceptStates   types.Set         `tfsdk:"accept_states"`
+	Parallelism    types.Int64       `tfsdk:"parallelism"`
+	RateLimit      types.Int64       `tfsdk:"rate_limit"`
+	ScanMode       types.String      `tfsdk:"scan_mode"`
+	Transport      types.String      `tfsdk:"transport"`
+	WarmCache      types.Bool        `tfsdk:"warm_cache"`
+	CacheState     types.String      `tfsdk:"cache_state"`
+	Passive        types.Bool        `tfsdk:"passive"`
+	DHCPSnoop      types.Bool        `tfsdk:"dhcp_snoop"`
+	FailOnNotFound types.Bool        `tfsdk:"fail_on_not_found"`
+	Interfaces     []ipInterfaceData `tfsdk:"interfaces"`
+	Found          types.Bool        `tfsdk:"found"`
+	IP             types.String      `tfsdk:"ip"`
+	Method         types.String      `tfsdk:"method"`
+	Id             types.String      `tfsdk:"id"`
+}
+
+// ipInterfaceData is one of the interfaces searched by an ip data source, optionally with its own network.
+type ipInterfaceData struct {
+	Name    types.String `tfsdk:"name"`
+	Network types.List   `tfsdk:"network"`
 }
 
 type ipDataSource struct {
-	provider provider
+	provider *provider
 }
 
-func (data *ipDataSourceData) read(ctx context.Context, ipDataSource ipDataSource) error {
+// read looks up the IP of the host with the data source's MAC. If the host is not found and fail_on_not_found is
+// false, a warning is added to diags and the IP is left null rather than returning an error.
+func (data *ipDataSourceData) read(ctx context.Context, rt *providerRuntime, diags *diag.Diagnostics) error {
 	mac, err := net.ParseMAC(data.MACAddr.Value)
 	if err != nil {
 		return err
 	}
 
-	if reflect.DeepEqual(ipDataSource.provider.network, net.IPNet{}) && data.Network.Null {
-		return fmt.Errorf("neither network specified")
+	config := searchConfig{
+		SourceIP:       data.SourceIP,
+		VLANID:         data.VLANID,
+		Timeout:        data.Timeout,
+		RequestTimeout: data.RequestTimeout,
+		MaxAttempts:    data.MaxAttempts,
+		Network:        data.Network,
+		Backoff:        data.Backoff,
+		Interface:      data.Interface,
+		AcceptStates:   data.AcceptStates,
+		Parallelism:    data.Parallelism,
+		RateLimit:      data.RateLimit,
+		ScanMode:       data.ScanMode,
+		Transport:      data.Transport,
+		WarmCache:      data.WarmCache,
+		CacheState:     data.CacheState,
 	}
 
-	var network *netaddr.IPSet
-	if !reflect.DeepEqual(ipDataSource.provider.network, net.IPNet{}) {
-		network = &ipDataSource.provider.network
+	if len(data.Interfaces) > 0 && !data.Interface.Null && !data.Interface.Unknown {
+		return fmt.Errorf("only one of `interface` and `interfaces` may be set")
 	}
 
-	if !data.Network.Null {
-		networks := []string{}
-		data.Network.ElementsAs(ctx, &networks, false)
+	// Each of interfaces is searched with its own network, or the data source's if it has none
+	searches := []ctxData{}
+	for _, iface := range data.Interfaces {
+		config.Interface = iface.Name
+		config.Network = data.Network
+		if !iface.Network.Null {
+			config.Network = iface.Network
+		}
 
-		network, err = mkIPSet(networks)
+		search, err := rt.searchData(ctx, config)
 		if err != nil {
-			return err
+			return fmt.Errorf("interface %s: %w", iface.Name.Value, err)
 		}
+		searches = append(searches, search)
 	}
 
-	backoff := ipDataSource.provider.backoff
-	if !data.Backoff.Null {
-		backoff, err = time.ParseDuration(data.Backoff.Value)
+	if len(searches) == 0 {
+		search, err := rt.searchData(ctx, config)
 		if err != nil {
 			return err
 		}
+
+		if search.iface == nil {
+			search.iface, err = routeInterface(search.network)
+			if err != nil {
+				return fmt.Errorf("error selecting interface: %w", err)
+			}
+		}
+		searches = append(searches, search)
 	}
 
-	iface, err := net.InterfaceByName(data.Interface.Value)
-	if err != nil {
-		return err
+	data.Method = types.String{Value: lookupMethod(searches[0])}
+
+	cache := rt.cache
+	if cache != nil {
+		if ip, iface, ok := readCache(ctx, cache, rt.clients, mac, searches, diags); ok {
+			data.IP = types.String{Value: ip.String()}
+			data.Interface = types.String{Value: iface.Name}
+			data.Found = types.Bool{Value: true}
+			data.Id = types.String{Value: mac.String()}
+
+			return nil
+		}
 	}
 
-	ip, err := getIPFor(ctx, mac, ctxData{iface: iface, network: network, backoff: backoff})
+	ctx, cancel := context.WithTimeout(ctx, searches[0].timeout)
+	defer cancel()
+
+	for i := range searches {
+		searches[i].passive = !data.Passive.Null && data.Passive.Value
+		searches[i].dhcpSnoop = !data.DHCPSnoop.Null && data.DHCPSnoop.Value
+	}
+
+	ip, iface, err := getIPForAny(ctx, rt.clients, rt.scans, mac, searches)
+	failOnNotFound := data.FailOnNotFound.Null || data.FailOnNotFound.Value
+	if errors.Is(err, ErrNotFound) && !failOnNotFound {
+		diags.AddWarning("host not found", fmt.Sprintf("%s\n\n`ip` is null as `fail_on_not_found` is false.", err.Error()))
+
+		data.IP = types.String{Null: true}
+		data.Found = types.Bool{Value: false}
+		data.Id = types.String{Value: mac.String()}
+
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("error running getIPFor: %w", err)
 	}
 
+	if cache != nil {
+		if err := cache.put(mac, ip, iface.Name); err != nil {
+			diags.AddWarning("unable to update lookup cache", err.Error())
+		}
+	}
+
 	data.IP = types.String{Value: ip.String()}
+	data.Interface = types.String{Value: iface.Name}
+	data.Found = types.Bool{Value: true}
 	data.Id = types.String{Value: mac.String()}
 
 	return nil
 }
 
+// readCache returns the IP that mac was last found at, along with its interface, if the host is still there, as
+// checked with a client from clients, and the interface is one of those being searched. Out of date entries are
+// removed, and problems with the cache are reported as warnings so that the lookup falls back to sweeping the network.
+func readCache(ctx context.Context, cache *lookupCache, clients *clientPool, mac net.HardwareAddr, searches []ctxData, diags *diag.Diagnostics) (netaddr.IP, *net.Interface, bool) {
+	entry, ok, err := cache.get(mac)
+	if err != nil {
+		diags.AddWarning("unable to read lookup cache", err.Error())
+		return netaddr.IP{}, nil, false
+	}
+	if !ok {
+		return netaddr.IP{}, nil, false
+	}
+
+	for _, search := range searches {
+		if search.iface == nil || search.iface.Name != entry.Interface || !search.network.Contains(entry.IP) {
+			continue
+		}
+
+		verified, err := verifyIPFor(ctx, clients, mac, entry.IP, search)
+		if err != nil {
+			// Any problem with the network will be reported by the sweep
+			return netaddr.IP{}, nil, false
+		}
+		if verified {
+			return entry.IP, search.iface, true
+		}
+	}
+
+	if err := cache.remove(mac); err != nil {
+		diags.AddWarning("unable to update lookup cache", err.Error())
+	}
+
+	return netaddr.IP{}, nil, false
+}
+
 func (ipDataSource ipDataSource) Read(ctx context.Context, req tfsdk.ReadDataSourceRequest, resp *tfsdk.ReadDataSourceResponse) {
 	var data ipDataSourceData
 	diags := req.Config.Get(ctx, &data)
@@ -154,12 +420,13 @@ func (ipDataSource ipDataSource) Read(ctx context.Context, req tfsdk.ReadDataSou
 		return
 	}
 
-	var cancel context.CancelFunc
-	ctx, cancel = context.WithTimeout(ctx, ipDataSource.provider.timeout)
-	defer cancel()
+	rt := ipDataSource.provider.runtime(&resp.Diagnostics)
+	if rt == nil {
+		return
+	}
 
-	if err := data.read(ctx, ipDataSource); err != nil {
-		resp.Diagnostics.AddError("issue encountered while looking up IP", err.Error())
+	if err := data.read(ctx, rt, &resp.Diagnostics); err != nil {
+		resp.Diagnostics.Append(lookupDiagnostic("issue encountered while looking up IP", err))
 		return
 	}
 
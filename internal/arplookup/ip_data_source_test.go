@@ -3,6 +3,9 @@ package arplookup
 import (
 	"fmt"
 	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
 	"regexp"
 	"testing"
 	"time"
@@ -10,12 +13,16 @@ import (
 	"terraform-provider-arplookup/internal/arplookup/testdriver"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"inet.af/netaddr"
 )
 
 var (
 	index    = 5
 	ip       = fmt.Sprintf("10.18.%d.18", index+1)
 	network  = fmt.Sprintf("%s/17", ip)
+	ip6      = fmt.Sprintf("fd18::%d:18", index+1)
+	network6 = fmt.Sprintf("%s/64", ip6)
 	mac      = "3e:50:6e:54:28:3d"
 	wrongmac = "0b:de:ad:be:ef:0b"
 )
@@ -34,22 +41,14 @@ func TestAccIPDataSource(t *testing.T) {
 		Steps: []resource.TestStep{
 			{
 				PreConfig: func() {
-					if err := driver.Init(r); err != nil {
-						t.Fatalf("unable to init test driver: %s", err.Error())
-					}
-
-					if err := driver.EnsureNo(mac); err != nil {
-						t.Fatalf("unable to ensure mac doesn't exist: %s", err.Error())
-					}
-
-					if err := driver.Needle(mac, ip, network, index); err != nil {
-						t.Fatalf("unable to insert needle into test haystack: %s", err.Error())
-					}
+					testAccNeedle(t, r, mac, ip, network, index)
 				},
 				Config: testAccIPDataSourceConfig,
 				Check: resource.ComposeAggregateTestCheckFunc(
 					resource.TestCheckResourceAttr("data.arplookup_ip.test", "id", mac),
 					resource.TestCheckResourceAttr("data.arplookup_ip.test", "ip", ip),
+					resource.TestCheckResourceAttr("data.arplookup_ip.test", "found", "true"),
+					resource.TestCheckResourceAttr("data.arplookup_ip.test", "method", "raw"),
 				),
 			}, {
 				PreConfig: func() {
@@ -95,6 +94,367 @@ data "arplookup_ip" "test" {
 }
 `
 
+// Test whether an IP is found with UDP probes when the provider lacks the NET_RAW capability
+func TestAccIPDataSourceUDP(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().UTC().Unix()))
+
+	testAccTest(t, []resource.TestStep{
+		{
+			PreConfig: func() {
+				testAccNeedle(t, r, mac, ip, network, index)
+
+				// The provider runs in the test's process, so drop its capabilities for the lookup
+				unprivileged, _ := mkTestPrivileges(t, nil, nil)
+				privileged := privileges
+				privileges = unprivileged
+				t.Cleanup(func() { privileges = privileged })
+			},
+			Config: testAccIPDataSourceUDPConfig,
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("data.arplookup_ip.test", "ip", ip),
+				resource.TestCheckResourceAttr("data.arplookup_ip.test", "method", "udp"),
+			),
+		},
+	})
+}
+
+// A small network is searched, as every host probed takes an entry in the kernel's neighbour table
+var testAccIPDataSourceUDPConfig = `
+provider "arplookup" {
+  timeout = "10s"
+}
+
+data "arplookup_ip" "test" {
+  interface = "br0"
+  backoff = "2s"
+  request_timeout = "50ms"
+  macaddr = "` + mac + `"
+  network = ["` + ip + `/28"]
+}
+`
+
+// Test whether found hosts are written to the neighbour table in the configured state, or not at all when
+// warm_cache is false
+func TestAccIPDataSourceWarmCache(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().UTC().Unix()))
+
+	forget := func() {
+		iface, err := net.InterfaceByName("br0")
+		if err != nil {
+			t.Fatalf("unable to find br0: %s", err.Error())
+		}
+
+		if err := deleteNeighbour(iface, netaddr.MustParseIP(ip)); err != nil {
+			t.Fatalf("unable to remove neighbour entry: %s", err.Error())
+		}
+	}
+
+	testAccTest(t, []resource.TestStep{
+		{
+			PreConfig: func() {
+				testAccNeedle(t, r, mac, ip, network, index)
+
+				forget()
+			},
+			Config: testAccIPDataSourceWarmCacheConfig(true, "reachable"),
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("data.arplookup_ip.test", "ip", ip),
+				testAccCheckNeighbour("br0", ip, neighReachable),
+			),
+		}, {
+			PreConfig: forget,
+			Config:    testAccIPDataSourceWarmCacheConfig(true, "permanent"),
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("data.arplookup_ip.test", "ip", ip),
+				testAccCheckNeighbour("br0", ip, neighPermanent),
+			),
+		}, {
+			PreConfig: forget,
+			Config:    testAccIPDataSourceWarmCacheConfig(false, "reachable"),
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("data.arplookup_ip.test", "ip", ip),
+				testAccCheckNeighbour("br0", ip, 0),
+			),
+		},
+	})
+}
+
+// testAccCheckNeighbour checks whether the neighbour table entry for ip on iface is in state, or that there is no
+// entry if state is zero.
+func testAccCheckNeighbour(name string, ip string, state neighState) resource.TestCheckFunc {
+	return func(*terraform.State) error {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return err
+		}
+
+		entry, ok, err := getNeighbour(iface, netaddr.MustParseIP(ip))
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case state == 0 && ok:
+			return fmt.Errorf("expected no neighbour entry for %s, found one in state %#x", ip, entry.state)
+		case state != 0 && !ok:
+			return fmt.Errorf("expected a neighbour entry for %s, found none", ip)
+		case state != 0 && entry.state != state:
+			return fmt.Errorf("expected neighbour entry for %s in state %#x, got: %#x", ip, state, entry.state)
+		}
+
+		return nil
+	}
+}
+
+func testAccIPDataSourceWarmCacheConfig(warm bool, state string) string {
+	return fmt.Sprintf(`
+provider "arplookup" {
+  timeout = "10s"
+}
+
+data "arplookup_ip" "test" {
+  interface = "br0"
+  backoff = "4s"
+  warm_cache = %t
+  cache_state = "%s"
+  macaddr = "`+mac+`"
+  network = ["`+network+`"]
+}
+`, warm, state)
+}
+
+// Test whether a host remembered in the cache file is verified without a sweep, and that the network is swept and
+// the cache updated once the host has moved
+func TestAccIPDataSourceCacheFile(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().UTC().Unix()))
+	cacheFile := filepath.Join(t.TempDir(), "cache.json")
+	moved := fmt.Sprintf("10.18.%d.19", index+1)
+
+	testAccTest(t, []resource.TestStep{
+		{
+			PreConfig: func() {
+				testAccNeedle(t, r, mac, ip, network, index)
+			},
+			Config: testAccIPDataSourceCacheFileConfig(cacheFile),
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("data.arplookup_ip.test", "ip", ip),
+				testAccCheckCacheFile(cacheFile, ip),
+			),
+		}, {
+			Config: testAccIPDataSourceCacheFileConfig(cacheFile),
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("data.arplookup_ip.test", "ip", ip),
+				testAccCheckCacheFile(cacheFile, ip),
+			),
+		}, {
+			PreConfig: func() {
+				if err := driver.Needle(mac, moved, moved+"/17", index); err != nil {
+					t.Fatalf("unable to move needle: %s", err.Error())
+				}
+			},
+			Config: testAccIPDataSourceCacheFileConfig(cacheFile),
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("data.arplookup_ip.test", "ip", moved),
+				testAccCheckCacheFile(cacheFile, moved),
+			),
+		},
+	})
+}
+
+// testAccCheckCacheFile checks whether the cache file remembers mac at ip.
+func testAccCheckCacheFile(path string, ip string) resource.TestCheckFunc {
+	return func(*terraform.State) error {
+		hw, _ := net.ParseMAC(mac)
+		entry, ok, err := mkLookupCache(path, 0).get(hw)
+		if err != nil {
+			return err
+		}
+		if !ok || entry.IP.String() != ip {
+			return fmt.Errorf("expected cache file to hold %s for %s, got: %+v", ip, mac, entry)
+		}
+
+		return nil
+	}
+}
+
+// The neighbour table isn't warmed, so that the host's old IP isn't found there once it has moved
+func testAccIPDataSourceCacheFileConfig(path string) string {
+	return fmt.Sprintf(`
+provider "arplookup" {
+  timeout = "10s"
+  cache_file = "%s"
+  warm_cache = false
+}
+
+data "arplookup_ip" "test" {
+  interface = "br0"
+  backoff = "4s"
+  macaddr = "`+mac+`"
+  network = ["`+network+`"]
+}
+`, path)
+}
+
+// Test whether several data sources searching the same network at once, which share a sweep, each find their host
+func TestAccIPDataSourceShared(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().UTC().Unix()))
+	ip2 := fmt.Sprintf("10.18.%d.18", index+2)
+	mac2 := "3e:50:6e:54:28:3e"
+
+	testAccTest(t, []resource.TestStep{
+		{
+			PreConfig: func() {
+				testAccNeedle(t, r, mac, ip, network, index)
+				testAccNeedle(t, r, mac2, ip2, ip2+"/17", index+1)
+			},
+			Config: testAccIPDataSourceSharedConfig(mac2),
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("data.arplookup_ip.first", "ip", ip),
+				resource.TestCheckResourceAttr("data.arplookup_ip.second", "ip", ip2),
+			),
+		},
+	})
+}
+
+func testAccIPDataSourceSharedConfig(mac2 string) string {
+	return `
+provider "arplookup" {
+  timeout = "10s"
+  network = ["10.18.6.0/24", "10.18.7.0/24"]
+}
+
+data "arplookup_ip" "first" {
+  interface = "br0"
+  backoff = "4s"
+  macaddr = "` + mac + `"
+}
+
+data "arplookup_ip" "second" {
+  interface = "br0"
+  backoff = "4s"
+  macaddr = "` + mac2 + `"
+}
+`
+}
+
+// Test whether an IPv6 address is successfully derived from a MAC address using neighbor discovery
+func TestAccIPDataSourceIPv6(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().UTC().Unix()))
+
+	testAccTest(t, []resource.TestStep{
+		{
+			PreConfig: func() {
+				testAccNeedle(t, r, mac, ip6, network6, index)
+			},
+			Config: testAccIPDataSourceIPv6Config,
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("data.arplookup_ip.test", "id", mac),
+				resource.TestCheckResourceAttr("data.arplookup_ip.test", "ip", ip6),
+			),
+		},
+	})
+}
+
+var testAccIPDataSourceIPv6Config = `
+provider "arplookup" {
+  timeout = "10s"
+}
+
+data "arplookup_ip" "test" {
+  interface = "br0"
+  backoff = "4s"
+  macaddr = "` + mac + `"
+  network = [
+    "fd18::6:0/120"
+  ]
+}
+`
+
+// Test that a host is found in passive mode once it announces itself.
+func TestAccIPDataSourcePassive(t *testing.T) {
+	testAccTest(t, []resource.TestStep{
+		{
+			PreConfig: func() {
+				if err := driver.EnsureNo(mac2); err != nil {
+					t.Fatalf("unable to ensure mac doesn't exist: %s", err.Error())
+				}
+
+				if err := driver.AnnounceAfter(mac2, ip2, network2, index2, 2*time.Second); err != nil {
+					t.Fatalf("unable to queue announcement: %s", err.Error())
+				}
+			},
+			Config: testAccIPDataSourcePassiveConfig,
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("data.arplookup_ip.test", "id", mac2),
+				resource.TestCheckResourceAttr("data.arplookup_ip.test", "ip", ip2),
+			),
+		},
+	})
+}
+
+var testAccIPDataSourcePassiveConfig = `
+provider "arplookup" {
+  timeout = "10s"
+}
+
+data "arplookup_ip" "test" {
+  interface = "br0"
+  passive = true
+  macaddr = "` + mac2 + `"
+  network = [
+    "10.18.8.0/24"
+  ]
+}
+`
+
+// Test that a host that doesn't respond is found through a DHCP lease file.
+func TestAccIPDataSourceLeaseFile(t *testing.T) {
+	testAccTest(t, []resource.TestStep{
+		{
+			PreConfig: func() {
+				if err := driver.EnsureNo(wrongmac); err != nil {
+					t.Fatalf("unable to ensure mac doesn't exist: %s", err.Error())
+				}
+
+				lease := fmt.Sprintf("0 %s %s leased *\n", wrongmac, leasedIP)
+				if err := os.WriteFile(dnsmasqLeasePath, []byte(lease), 0o644); err != nil {
+					t.Fatalf("unable to write lease file: %s", err.Error())
+				}
+			},
+			Config: testAccIPDataSourceLeaseFileConfig,
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("data.arplookup_ip.test", "id", wrongmac),
+				resource.TestCheckResourceAttr("data.arplookup_ip.test", "ip", leasedIP),
+			),
+		},
+	})
+}
+
+var (
+	leasedIP         = "10.18.6.77"
+	dnsmasqLeasePath = filepath.Join(os.TempDir(), "arplookup-test-dnsmasq.leases")
+)
+
+var testAccIPDataSourceLeaseFileConfig = `
+provider "arplookup" {
+  timeout = "10s"
+  lease_files = [
+    {
+      format = "dnsmasq"
+      path = "` + dnsmasqLeasePath + `"
+    }
+  ]
+}
+
+data "arplookup_ip" "test" {
+  interface = "br0"
+  macaddr = "` + wrongmac + `"
+  network = [
+    "10.18.6.0/24"
+  ]
+}
+`
+
 // Test that being created with an incorrect mac (or host that is down) results in failure after the timeout expires.
 func TestAccIPDataSourceFails(t *testing.T) {
 	resource.Test(t, resource.TestCase{
@@ -131,6 +491,163 @@ data "arplookup_ip" "test" {
 }
 `
 
+// Test that a data source's timeout and max_attempts override the provider's timeout.
+func TestAccIPDataSourceOverrideTimeout(t *testing.T) {
+	testAccTest(t, []resource.TestStep{
+		{
+			PreConfig: func() {
+				if err := driver.EnsureNo(wrongmac); err != nil {
+					t.Fatalf("unable to ensure mac doesn't exist: %s", err.Error())
+				}
+			},
+			Config:      testAccIPOverrideTimeout,
+			ExpectError: regexp.MustCompile("error running getIPFor: error: IP address corresponding to given MAC"),
+			Check:       resource.ComposeAggregateTestCheckFunc(),
+		},
+	})
+}
+
+var testAccIPOverrideTimeout = `
+provider "arplookup" {
+  timeout = "10m"
+}
+
+data "arplookup_ip" "test" {
+  interface = "br0"
+  macaddr = "` + wrongmac + `"
+  timeout = "5s"
+  backoff = "1s"
+  request_timeout = "5ms"
+  max_attempts = 2
+  network = [
+    "10.18.6.0/24"
+  ]
+}
+`
+
+// Test that a missing host gives a null IP rather than an error when fail_on_not_found is false.
+func TestAccIPDataSourceSoftFail(t *testing.T) {
+	testAccTest(t, []resource.TestStep{
+		{
+			PreConfig: func() {
+				if err := driver.EnsureNo(wrongmac); err != nil {
+					t.Fatalf("unable to ensure mac doesn't exist: %s", err.Error())
+				}
+			},
+			Config: testAccIPSoftFail,
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("data.arplookup_ip.test", "id", wrongmac),
+				resource.TestCheckResourceAttr("data.arplookup_ip.test", "found", "false"),
+				resource.TestCheckNoResourceAttr("data.arplookup_ip.test", "ip"),
+			),
+		},
+	})
+}
+
+var testAccIPSoftFail = `
+provider "arplookup" {
+  timeout = "2s"
+}
+
+data "arplookup_ip" "test" {
+  interface = "br0"
+  macaddr = "` + wrongmac + `"
+  fail_on_not_found = false
+  network = [
+    "10.18.6.0/24"
+  ]
+}
+`
+
+// Test that the interface is chosen from the routing table when it isn't set.
+func TestAccIPDataSourceRouteInterface(t *testing.T) {
+	testAccTest(t, []resource.TestStep{
+		{
+			PreConfig: func() {
+				if err := driver.Needle(mac, ip, network, index); err != nil {
+					t.Fatalf("unable to insert needle into test haystack: %s", err.Error())
+				}
+			},
+			Config: testAccIPRouteInterface,
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("data.arplookup_ip.test", "interface", "br0"),
+				resource.TestCheckResourceAttr("data.arplookup_ip.test", "ip", ip),
+			),
+		},
+		{
+			Config:      testAccIPRouteInterfaceOffLink,
+			ExpectError: regexp.MustCompile("unable to select network interface"),
+		},
+	})
+}
+
+var testAccIPRouteInterface = `
+provider "arplookup" {
+  timeout = "10s"
+}
+
+data "arplookup_ip" "test" {
+  macaddr = "` + mac + `"
+  network = [
+    "10.18.6.0/24"
+  ]
+}
+`
+
+var testAccIPRouteInterfaceOffLink = `
+provider "arplookup" {
+  timeout = "10s"
+}
+
+data "arplookup_ip" "test" {
+  macaddr = "` + mac + `"
+  network = [
+    "10.18.6.0/24",
+    "192.0.2.0/24"
+  ]
+}
+`
+
+// Test that several interfaces are searched, reporting the one the host was found on.
+func TestAccIPDataSourceInterfaces(t *testing.T) {
+	testAccTest(t, []resource.TestStep{
+		{
+			PreConfig: func() {
+				if err := driver.Needle(mac, ip, network, index); err != nil {
+					t.Fatalf("unable to insert needle into test haystack: %s", err.Error())
+				}
+			},
+			Config: testAccIPInterfaces,
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("data.arplookup_ip.test", "interface", "br0"),
+				resource.TestCheckResourceAttr("data.arplookup_ip.test", "ip", ip),
+			),
+		},
+	})
+}
+
+var testAccIPInterfaces = `
+provider "arplookup" {
+  timeout = "10s"
+}
+
+data "arplookup_ip" "test" {
+  macaddr = "` + mac + `"
+  network = [
+    "10.18.6.0/24"
+  ]
+  interfaces = [
+    {
+      name = "lo"
+      network = ["127.0.0.0/30"]
+    },
+    {
+      name = "br0"
+    }
+  ]
+}
+`
+
 func TestAccIPDataSourceWrongInterface(t *testing.T) {
 	resource.Test(t, resource.TestCase{
 		PreCheck: func() {
@@ -0,0 +1,319 @@
+package arplookup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// Ensure provider defined types fully satisfy framework interfaces
+var _ tfsdk.DataSourceType = ipsDataSourceType{}
+var _ tfsdk.DataSource = ipsDataSource{}
+
+type ipsDataSourceType struct{}
+
+func (t ipsDataSourceType) GetSchema(ctx context.Context) (tfsdk.Schema, diag.Diagnostics) {
+	return tfsdk.Schema{
+		MarkdownDescription: "This data source will search `network` for hosts matching each of `macaddrs` with a single sweep. ",
+		Attributes: map[string]tfsdk.Attribute{
+			"macaddrs": {
+				MarkdownDescription: "MAC addresses to search for.",
+				Required:            true,
+				Type: types.SetType{
+					ElemType: types.StringType,
+				},
+				PlanModifiers: tfsdk.AttributePlanModifiers{
+					tfsdk.RequiresReplace(),
+				},
+				Validators: []tfsdk.AttributeValidator{
+					macSetValidator{},
+				},
+			},
+			"backoff": {
+				MarkdownDescription: "How long to wait between scans of the IP ranges specified by `network`.",
+				Optional:            true,
+				Type:                types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					timeValidator{},
+				},
+			},
+			"network": {
+				MarkdownDescription: "Network to search for macaddrs in. IPv6 prefixes are searched using neighbor discovery, and must be `/112` or longer.",
+				Optional:            true,
+				Type: types.ListType{
+					ElemType: types.StringType,
+				},
+				PlanModifiers: tfsdk.AttributePlanModifiers{
+					tfsdk.RequiresReplace(),
+				},
+				Validators: []tfsdk.AttributeValidator{
+					networkValidator{},
+				},
+			},
+			"parallelism": {
+				MarkdownDescription: "How many hosts to send requests to concurrently while scanning `network`.",
+				Optional:            true,
+				Type:                types.Int64Type,
+				Validators: []tfsdk.AttributeValidator{
+					positiveIntValidator{},
+				},
+			},
+			"rate_limit": {
+				MarkdownDescription: "Maximum number of requests to send per second while scanning `network`.",
+				Optional:            true,
+				Type:                types.Int64Type,
+				Validators: []tfsdk.AttributeValidator{
+					positiveIntValidator{},
+				},
+			},
+			"warm_cache": {
+				MarkdownDescription: "Whether to add hosts found by sending requests to the system's neighbour table. Overrides the provider's `warm_cache`.",
+				Optional:            true,
+				Type:                types.BoolType,
+			},
+			"cache_state": {
+				MarkdownDescription: "State to add found hosts to the system's neighbour table in, one of `reachable`, `stale` or `permanent`. Overrides the provider's `cache_state`.",
+				Optional:            true,
+				Type:                types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					cacheStateValidator{},
+				},
+			},
+			"scan_mode": {
+				MarkdownDescription: "How to scan `network`, either `request` or `async`.",
+				Optional:            true,
+				Type:                types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					scanModeValidator{},
+				},
+			},
+			"transport": {
+				MarkdownDescription: "How ARP packets are passed to and from the kernel, either `socket` or `ring`. `ring` can only be used with IPv4 networks.",
+				Optional:            true,
+				Type:                types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					transportValidator{},
+				},
+			},
+			"timeout": {
+				MarkdownDescription: "How long to search for before giving up. Overrides the provider's `timeout`.",
+				Optional:            true,
+				Type:                types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					timeValidator{},
+				},
+			},
+			"request_timeout": {
+				MarkdownDescription: "How long to wait for a reply to each request sent while scanning `network`.",
+				Optional:            true,
+				Type:                types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					timeValidator{},
+				},
+			},
+			"max_attempts": {
+				MarkdownDescription: "How many scans of `network` to make before giving up.",
+				Optional:            true,
+				Type:                types.Int64Type,
+				Validators: []tfsdk.AttributeValidator{
+					positiveIntValidator{},
+				},
+			},
+			"source_ip": {
+				MarkdownDescription: "IPv4 address of `interface` to send ARP requests from. Defaults to the address whose prefix overlaps `network`, or the first IPv4 address of `interface`.",
+				Optional:            true,
+				Type:                types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					sourceIPValidator{},
+				},
+			},
+			"vlan_id": {
+				MarkdownDescription: "802.1Q VLAN to search on `interface`. An existing sub-interface for the VLAN is used if there is one, otherwise a temporary one is created with `source_ip` assigned to it, which requires the NET_ADMIN capability.",
+				Optional:            true,
+				Type:                types.Int64Type,
+				Validators: []tfsdk.AttributeValidator{
+					vlanIDValidator{},
+				},
+			},
+			"accept_states": {
+				MarkdownDescription: "Neighbour table states to accept when checking the system's cache, out of `reachable`, `stale`, `delay`, `probe`, `permanent`, `noarp`, `incomplete` and `failed`. Defaults to every state except `incomplete` and `failed`.",
+				Optional:            true,
+				Type: types.SetType{
+					ElemType: types.StringType,
+				},
+				Validators: []tfsdk.AttributeValidator{
+					neighStatesValidator{},
+				},
+			},
+			"interface": {
+				MarkdownDescription: "Interface to bind to when searching for machines.",
+				Required:            true,
+				Type:                types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					interfaceValidator{},
+				},
+			},
+			"ips": {
+				MarkdownDescription: "Map of each MAC address in `macaddrs`, as written, to its resultant IP address.",
+				Computed:            true,
+				Type: types.MapType{
+					ElemType: types.StringType,
+				},
+			},
+			"method": {
+				MarkdownDescription: "How hosts were looked up. `raw` sends ARP and neighbor discovery requests over raw sockets, `helper` opens them through the provider's `helper`, and `udp` sends unprivileged UDP probes and reads replies from the system's neighbour table, which is used when the provider lacks the NET_RAW capability.",
+				Computed:            true,
+				Type:                types.StringType,
+			},
+			"id": {
+				MarkdownDescription: "Unique identifier.",
+				Type:                types.StringType,
+				Computed:            true,
+			},
+		},
+	}, nil
+}
+
+func (t ipsDataSourceType) NewDataSource(ctx context.Context, in tfsdk.Provider) (tfsdk.DataSource, diag.Diagnostics) {
+	provider, diags := convertProviderType(in)
+	return ipsDataSource{
+		provider: provider,
+	}, diags
+}
+
+type ipsDataSourceData struct {
+	SourceIP       types.String `tfsdk:"source_ip"`
+	VLANID         types.Int64  `tfsdk:"vlan_id"`
+	Timeout        types.String `tfsdk:"timeout"`
+	RequestTimeout types.String `tfsdk:"request_timeout"`
+	MaxAttempts    types.Int64  `tfsdk:"max_attempts"`
+	Backoff        types.String `tfsdk:"backoff"`
+	Network        types.List   `tfsdk:"network"`
+	MACAddrs       types.Set    `tfsdk:"macaddrs"`
+	Interface      types.String `tfsdk:"interface"`
+	AcceptStates   types.Set    `tfsdk:"accept_states"`
+	Parallelism    types.Int64  `tfsdk:"parallelism"`
+	RateLimit      types.Int64  `tfsdk:"rate_limit"`
+	ScanMode       types.String `tfsdk:"scan_mode"`
+	Transport      types.String `tfsdk:"transport"`
+	WarmCache      types.Bool   `tfsdk:"warm_cache"`
+	CacheState     types.String `tfsdk:"cache_state"`
+	IPs            types.Map    `tfsdk:"ips"`
+	Method         types.String `tfsdk:"method"`
+	Id             types.String `tfsdk:"id"`
+}
+
+type ipsDataSource struct {
+	provider *provider
+}
+
+func (data *ipsDataSourceData) read(ctx context.Context, rt *providerRuntime) error {
+	configured := []string{}
+	if diags := data.MACAddrs.ElementsAs(ctx, &configured, false); diags.HasError() {
+		return fmt.Errorf("unable to read macaddrs")
+	}
+
+	// Results are keyed by MAC as it was written in the configuration so they can be indexed by the caller. The same
+	// MAC may be written several ways, each of which is given its IP, but is only searched for once.
+	names := make(map[string][]string, len(configured))
+	macs := make([]net.HardwareAddr, 0, len(configured))
+	for _, name := range configured {
+		mac, err := net.ParseMAC(name)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := names[mac.String()]; !ok {
+			macs = append(macs, mac)
+		}
+		names[mac.String()] = append(names[mac.String()], name)
+	}
+
+	search, err := rt.searchData(ctx, searchConfig{
+		SourceIP:       data.SourceIP,
+		VLANID:         data.VLANID,
+		Timeout:        data.Timeout,
+		RequestTimeout: data.RequestTimeout,
+		MaxAttempts:    data.MaxAttempts,
+		Network:        data.Network,
+		Backoff:        data.Backoff,
+		Interface:      data.Interface,
+		AcceptStates:   data.AcceptStates,
+		Parallelism:    data.Parallelism,
+		RateLimit:      data.RateLimit,
+		ScanMode:       data.ScanMode,
+		Transport:      data.Transport,
+		WarmCache:      data.WarmCache,
+		CacheState:     data.CacheState,
+	})
+	if err != nil {
+		return err
+	}
+
+	data.Method = types.String{Value: lookupMethod(search)}
+
+	ctx, cancel := context.WithTimeout(ctx, search.timeout)
+	defer cancel()
+
+	found, err := getIPsFor(ctx, rt.clients, macs, search)
+	var missing missingError
+	if errors.As(err, &missing) {
+		spellings := []string{}
+		for _, mac := range missing.macs {
+			spellings = append(spellings, names[mac]...)
+		}
+		missing.macs = spellings
+		return fmt.Errorf("error running getIPsFor: %w", missing)
+	}
+	if err != nil {
+		return fmt.Errorf("error running getIPsFor: %w", err)
+	}
+
+	ips := types.Map{ElemType: types.StringType, Elems: map[string]attr.Value{}}
+	for mac, ip := range found {
+		for _, name := range names[mac] {
+			ips.Elems[name] = types.String{Value: ip.String()}
+		}
+	}
+
+	ids := make([]string, 0, len(found))
+	for mac := range found {
+		ids = append(ids, mac)
+	}
+	sort.Strings(ids)
+
+	data.IPs = ips
+	data.Id = types.String{Value: strings.Join(ids, ",")}
+
+	return nil
+}
+
+func (ipsDataSource ipsDataSource) Read(ctx context.Context, req tfsdk.ReadDataSourceRequest, resp *tfsdk.ReadDataSourceResponse) {
+	var data ipsDataSourceData
+	diags := req.Config.Get(ctx, &data)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	rt := ipsDataSource.provider.runtime(&resp.Diagnostics)
+	if rt == nil {
+		return
+	}
+
+	if err := data.read(ctx, rt); err != nil {
+		resp.Diagnostics.Append(lookupDiagnostic("issue encountered while looking up IPs", err))
+		return
+	}
+
+	diags = resp.State.Set(ctx, &data)
+	resp.Diagnostics.Append(diags...)
+}
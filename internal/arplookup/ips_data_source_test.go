@@ -0,0 +1,89 @@
+package arplookup
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+)
+
+var (
+	index2   = 7
+	ip2      = fmt.Sprintf("10.18.%d.18", index2+1)
+	network2 = fmt.Sprintf("%s/17", ip2)
+	mac2     = "3e:50:6e:54:28:3e"
+	// mac2 as it may also be written, which is given the same IP under its own key
+	mac2Upper = strings.ToUpper(mac2)
+)
+
+// Test whether IPs are successfully derived from several MAC addresses at once
+func TestAccIPsDataSource(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().UTC().Unix()))
+
+	testAccTest(t, []resource.TestStep{
+		{
+			PreConfig: func() {
+				testAccNeedle(t, r, mac, ip, network, index)
+				testAccNeedle(t, r, mac2, ip2, network2, index2)
+			},
+			Config: testAccIPsDataSourceConfig,
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("data.arplookup_ips.test", "ips.%", "3"),
+				resource.TestCheckResourceAttr("data.arplookup_ips.test", "ips."+mac, ip),
+				resource.TestCheckResourceAttr("data.arplookup_ips.test", "ips."+mac2, ip2),
+				resource.TestCheckResourceAttr("data.arplookup_ips.test", "ips."+mac2Upper, ip2),
+			),
+		},
+	})
+}
+
+var testAccIPsDataSourceConfig = `
+provider "arplookup" {
+  timeout = "10s"
+}
+
+data "arplookup_ips" "test" {
+  interface = "br0"
+  backoff = "4s"
+  macaddrs = ["` + mac + `", "` + mac2 + `", "` + mac2Upper + `"]
+  network = [
+    "10.18.0.0/21",
+    "10.18.8.0/23",
+    "10.18.10.0/24"
+  ]
+}
+`
+
+// Test that missing MAC addresses are reported once the timeout expires.
+func TestAccIPsDataSourceMissing(t *testing.T) {
+	testAccTest(t, []resource.TestStep{
+		{
+			PreConfig: func() {
+				if err := driver.EnsureNo(wrongmac); err != nil {
+					t.Fatalf("unable to ensure mac doesn't exist: %s", err.Error())
+				}
+			},
+			Config:      testAccIPsMissingMAC,
+			ExpectError: regexp.MustCompile("no IP address found for MAC addresses: " + wrongmac),
+			Check:       resource.ComposeAggregateTestCheckFunc(),
+		},
+	})
+}
+
+var testAccIPsMissingMAC = `
+provider "arplookup" {
+  timeout = "2s"
+}
+
+data "arplookup_ips" "test" {
+  interface = "br0"
+  macaddrs = ["` + wrongmac + `"]
+  network = [
+    "10.18.6.0/24"
+  ]
+}
+`
@@ -0,0 +1,174 @@
+package arplookup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"inet.af/netaddr"
+)
+
+// defaultCacheTTL is how long lookups stay in the cache file when `cache_ttl` isn't set.
+const defaultCacheTTL = time.Hour
+
+// lookupCache is a file shared between Terraform runs, and between concurrent Terraform processes, holding the IP
+// each MAC was last found at. A separate lock file is held while the cache is read or written, and the cache
+// itself is replaced atomically, so that readers never see a partially written file.
+type lookupCache struct {
+	path string
+	ttl  time.Duration
+	now  func() time.Time // current time, overridden when testing expiry
+}
+
+// cacheEntry is where a MAC was found, and when.
+type cacheEntry struct {
+	IP        netaddr.IP `json:"ip"`
+	Interface string     `json:"interface"`
+	Updated   time.Time  `json:"updated"`
+}
+
+// cacheContents is the format of the cache file.
+type cacheContents struct {
+	Entries map[string]cacheEntry `json:"entries"`
+}
+
+func mkLookupCache(path string, ttl time.Duration) *lookupCache {
+	return &lookupCache{
+		path: path,
+		ttl:  orDefault(ttl, defaultCacheTTL),
+		now:  time.Now,
+	}
+}
+
+// get returns the unexpired entry for mac, reporting whether there is one.
+func (c *lookupCache) get(mac net.HardwareAddr) (cacheEntry, bool, error) {
+	var entry cacheEntry
+	var ok bool
+	err := c.locked(syscall.LOCK_SH, func() error {
+		contents, err := c.read()
+		if err != nil {
+			return err
+		}
+
+		entry, ok = contents.Entries[mac.String()]
+		if ok && c.expired(entry) {
+			ok = false
+		}
+		return nil
+	})
+
+	return entry, ok, err
+}
+
+// put records that mac was found at ip on iface, dropping any expired entries.
+func (c *lookupCache) put(mac net.HardwareAddr, ip netaddr.IP, iface string) error {
+	return c.update(func(entries map[string]cacheEntry) {
+		entries[mac.String()] = cacheEntry{IP: ip, Interface: iface, Updated: c.now().UTC()}
+	})
+}
+
+// remove drops the entry for mac, which is done once it's found to be out of date.
+func (c *lookupCache) remove(mac net.HardwareAddr) error {
+	return c.update(func(entries map[string]cacheEntry) {
+		delete(entries, mac.String())
+	})
+}
+
+// update applies fn to the cache's unexpired entries while holding the lock exclusively, and writes the result.
+func (c *lookupCache) update(fn func(map[string]cacheEntry)) error {
+	return c.locked(syscall.LOCK_EX, func() error {
+		contents, err := c.read()
+		if err != nil {
+			return err
+		}
+
+		for mac, entry := range contents.Entries {
+			if c.expired(entry) {
+				delete(contents.Entries, mac)
+			}
+		}
+		fn(contents.Entries)
+
+		return c.write(contents)
+	})
+}
+
+func (c *lookupCache) expired(entry cacheEntry) bool {
+	return c.now().Sub(entry.Updated) > c.ttl
+}
+
+// locked runs fn while holding the cache's lock file in the given flock mode.
+func (c *lookupCache) locked(how int, fn func() error) error {
+	lock, err := os.OpenFile(c.path+".lock", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("unable to open cache lock file: %w", err)
+	}
+	defer lock.Close()
+
+	for {
+		err = syscall.Flock(int(lock.Fd()), how)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("unable to lock cache file: %w", err)
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	return fn()
+}
+
+// read loads the cache file. A missing or corrupt file is treated as empty, so that it is replaced on the next
+// write rather than failing every lookup.
+func (c *lookupCache) read() (cacheContents, error) {
+	contents := cacheContents{Entries: map[string]cacheEntry{}}
+
+	b, err := os.ReadFile(c.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return contents, nil
+	}
+	if err != nil {
+		return contents, fmt.Errorf("unable to read cache file: %w", err)
+	}
+
+	if err := json.Unmarshal(b, &contents); err != nil || contents.Entries == nil {
+		return cacheContents{Entries: map[string]cacheEntry{}}, nil
+	}
+
+	return contents, nil
+}
+
+// write replaces the cache file with contents.
+func (c *lookupCache) write(contents cacheContents) error {
+	b, err := json.MarshalIndent(contents, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return fmt.Errorf("unable to write cache file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write cache file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write cache file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return fmt.Errorf("unable to write cache file: %w", err)
+	}
+
+	return nil
+}
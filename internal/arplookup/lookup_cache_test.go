@@ -0,0 +1,83 @@
+package arplookup
+
+import (
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"inet.af/netaddr"
+)
+
+// TestLookupCache checks whether entries are read back until their TTL expires, and that out of date entries
+// can be removed.
+func TestLookupCache(t *testing.T) {
+	now := time.Now()
+	cache := mkLookupCache(filepath.Join(t.TempDir(), "cache.json"), time.Minute)
+	cache.now = func() time.Time { return now }
+
+	mac, _ := net.ParseMAC("3e:50:6e:54:28:3d")
+	if _, ok, err := cache.get(mac); ok || err != nil {
+		t.Fatalf("expected no entry in a missing cache file, got: %v, %v", ok, err)
+	}
+
+	ip := netaddr.MustParseIP("10.18.6.18")
+	if err := cache.put(mac, ip, "br0"); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, ok, err := cache.get(mac)
+	if err != nil || !ok {
+		t.Fatalf("expected cached entry, got: %v, %v", ok, err)
+	}
+	if entry.IP != ip || entry.Interface != "br0" {
+		t.Fatalf("expected %s on br0, got: %s on %s", ip, entry.IP, entry.Interface)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok, _ := cache.get(mac); ok {
+		t.Fatal("expected entry to have expired")
+	}
+
+	now = time.Now()
+	cache.put(mac, ip, "br0")
+	if err := cache.remove(mac); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := cache.get(mac); ok {
+		t.Fatal("expected entry to have been removed")
+	}
+}
+
+// TestLookupCacheConcurrent checks whether concurrent writers, each with their own cache as separate Terraform
+// processes would have, don't lose each other's entries.
+func TestLookupCacheConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			mac := net.HardwareAddr{0x02, 0, 0, 0, 0, byte(i)}
+			if err := mkLookupCache(path, time.Hour).put(mac, netaddr.IPv4(10, 0, 0, byte(i)), "br0"); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	cache := mkLookupCache(path, time.Hour)
+	for i := 0; i < 32; i++ {
+		mac := net.HardwareAddr{0x02, 0, 0, 0, 0, byte(i)}
+		entry, ok, err := cache.get(mac)
+		if err != nil || !ok {
+			t.Fatalf("expected entry for %s, got: %v, %v", mac, ok, err)
+		}
+		if entry.IP != netaddr.IPv4(10, 0, 0, byte(i)) {
+			t.Fatalf("expected %s for %s, got: %s", netaddr.IPv4(10, 0, 0, byte(i)), mac, entry.IP)
+		}
+	}
+}
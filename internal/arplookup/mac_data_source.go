@@ -0,0 +1,171 @@
+package arplookup
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"inet.af/netaddr"
+)
+
+// Ensure provider defined types fully satisfy framework interfaces
+var _ tfsdk.DataSourceType = macDataSourceType{}
+var _ tfsdk.DataSource = macDataSource{}
+
+type macDataSourceType struct{}
+
+func (t macDataSourceType) GetSchema(ctx context.Context) (tfsdk.Schema, diag.Diagnostics) {
+	return tfsdk.Schema{
+		MarkdownDescription: "This data source will find the MAC address of the host at `ip` on `interface`. ",
+		Attributes: map[string]tfsdk.Attribute{
+			"ip": {
+				MarkdownDescription: "IPv4 address to search for.",
+				Required:            true,
+				Type:                types.StringType,
+				PlanModifiers: tfsdk.AttributePlanModifiers{
+					tfsdk.RequiresReplace(),
+				},
+				Validators: []tfsdk.AttributeValidator{
+					ipValidator{},
+				},
+			},
+			"backoff": {
+				MarkdownDescription: "How long to wait between ARP requests for `ip`.",
+				Optional:            true,
+				Type:                types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					timeValidator{},
+				},
+			},
+			"timeout": {
+				MarkdownDescription: "How long to search for before giving up. Overrides the provider's `timeout`.",
+				Optional:            true,
+				Type:                types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					timeValidator{},
+				},
+			},
+			"max_attempts": {
+				MarkdownDescription: "How many ARP requests for `ip` to send before giving up.",
+				Optional:            true,
+				Type:                types.Int64Type,
+				Validators: []tfsdk.AttributeValidator{
+					positiveIntValidator{},
+				},
+			},
+			"accept_states": {
+				MarkdownDescription: "Neighbour table states to accept when checking the system's cache, out of `reachable`, `stale`, `delay`, `probe`, `permanent`, `noarp`, `incomplete` and `failed`. Defaults to every state except `incomplete` and `failed`.",
+				Optional:            true,
+				Type: types.SetType{
+					ElemType: types.StringType,
+				},
+				Validators: []tfsdk.AttributeValidator{
+					neighStatesValidator{},
+				},
+			},
+			"interface": {
+				MarkdownDescription: "Interface to bind to when searching for machines.",
+				Required:            true,
+				Type:                types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					interfaceValidator{},
+				},
+			},
+			"macaddr": {
+				MarkdownDescription: "Resultant MAC address.",
+				Computed:            true,
+				Type:                types.StringType,
+			},
+			"method": {
+				MarkdownDescription: "How hosts were looked up. `raw` sends ARP and neighbor discovery requests over raw sockets, `helper` opens them through the provider's `helper`, and `udp` sends unprivileged UDP probes and reads replies from the system's neighbour table, which is used when the provider lacks the NET_RAW capability.",
+				Computed:            true,
+				Type:                types.StringType,
+			},
+			"id": {
+				MarkdownDescription: "Unique identifier.",
+				Type:                types.StringType,
+				Computed:            true,
+			},
+		},
+	}, nil
+}
+
+func (t macDataSourceType) NewDataSource(ctx context.Context, in tfsdk.Provider) (tfsdk.DataSource, diag.Diagnostics) {
+	provider, diags := convertProviderType(in)
+	return macDataSource{
+		provider: provider,
+	}, diags
+}
+
+type macDataSourceData struct {
+	Timeout      types.String `tfsdk:"timeout"`
+	MaxAttempts  types.Int64  `tfsdk:"max_attempts"`
+	Backoff      types.String `tfsdk:"backoff"`
+	IP           types.String `tfsdk:"ip"`
+	Interface    types.String `tfsdk:"interface"`
+	AcceptStates types.Set    `tfsdk:"accept_states"`
+	MACAddr      types.String `tfsdk:"macaddr"`
+	Method       types.String `tfsdk:"method"`
+	Id           types.String `tfsdk:"id"`
+}
+
+type macDataSource struct {
+	provider *provider
+}
+
+func (data *macDataSourceData) read(ctx context.Context, rt *providerRuntime) error {
+	ip, err := netaddr.ParseIP(data.IP.Value)
+	if err != nil {
+		return err
+	}
+
+	search, err := rt.defaults.merge(ctx, searchConfig{
+		Timeout:      data.Timeout,
+		Backoff:      data.Backoff,
+		MaxAttempts:  data.MaxAttempts,
+		Interface:    data.Interface,
+		AcceptStates: data.AcceptStates,
+	})
+	if err != nil {
+		return err
+	}
+
+	data.Method = types.String{Value: lookupMethod(search)}
+
+	ctx, cancel := context.WithTimeout(ctx, search.timeout)
+	defer cancel()
+
+	mac, err := getMACFor(ctx, rt.clients, ip, search)
+	if err != nil {
+		return fmt.Errorf("error running getMACFor: %w", err)
+	}
+
+	data.MACAddr = types.String{Value: mac.String()}
+	data.Id = types.String{Value: ip.String()}
+
+	return nil
+}
+
+func (macDataSource macDataSource) Read(ctx context.Context, req tfsdk.ReadDataSourceRequest, resp *tfsdk.ReadDataSourceResponse) {
+	var data macDataSourceData
+	diags := req.Config.Get(ctx, &data)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	rt := macDataSource.provider.runtime(&resp.Diagnostics)
+	if rt == nil {
+		return
+	}
+
+	if err := data.read(ctx, rt); err != nil {
+		resp.Diagnostics.Append(lookupDiagnostic("issue encountered while looking up MAC", err))
+		return
+	}
+
+	diags = resp.State.Set(ctx, &data)
+	resp.Diagnostics.Append(diags...)
+}
@@ -0,0 +1,57 @@
+package arplookup
+
+import (
+	"math/rand"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+)
+
+// Test whether a MAC address is successfully derived from an IP
+func TestAccMACDataSource(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().UTC().Unix()))
+
+	testAccTest(t, []resource.TestStep{
+		{
+			PreConfig: func() {
+				testAccNeedle(t, r, mac, ip, network, index)
+			},
+			Config: testAccMACDataSourceConfig,
+			Check: resource.ComposeAggregateTestCheckFunc(
+				resource.TestCheckResourceAttr("data.arplookup_mac.test", "id", ip),
+				resource.TestCheckResourceAttr("data.arplookup_mac.test", "macaddr", mac),
+			),
+		},
+	})
+}
+
+var testAccMACDataSourceConfig = `
+provider "arplookup" {
+  timeout = "10s"
+}
+
+data "arplookup_mac" "test" {
+  interface = "br0"
+  backoff = "1s"
+  ip = "` + ip + `"
+}
+`
+
+func TestAccMACDataSourceInvalidIP(t *testing.T) {
+	testAccTest(t, []resource.TestStep{
+		{
+			Config:      testAccMACInvalidIP,
+			ExpectError: regexp.MustCompile("malformed or invalid IP"),
+			Check:       resource.ComposeAggregateTestCheckFunc(),
+		},
+	})
+}
+
+var testAccMACInvalidIP = `
+data "arplookup_mac" "test" {
+  interface = "br0"
+  ip = "10.18.0"
+}
+`
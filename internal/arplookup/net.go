@@ -1,74 +1,861 @@
 package arplookup
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"net"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"inet.af/netaddr"
+	"terraform-provider-arplookup/internal/privhelper"
 )
 
 // Default timeout is 360 seconds.
 const arpFuncBackoff = 5 * time.Second
-const arpRequestDeadline = 1000 * time.Microsecond
+
+// defaultRequestTimeout is how long to wait for a reply to a single request when `request_timeout` is not set.
+const defaultRequestTimeout = 1000 * time.Microsecond
+
+// arpScanLinger is how long an asynchronous scan keeps listening for late replies once every request has been sent.
+const arpScanLinger = 1 * time.Second
+
+// asyncReplyBuffer is how many replies an asynchronous scan can have waiting to be matched before new ones are
+// dropped.
+const asyncReplyBuffer = 256
+
+// Strategies used to sweep a network for hosts, as selected by the `scan_mode` attribute.
+const (
+	scanModeRequest = "request" // send a request to each IP in turn, waiting for its reply before moving on
+	scanModeAsync   = "async"   // send requests to every IP while a single reader collects all replies
+)
+
+// Ways ARP packets are passed to and from the kernel, as selected by the `transport` attribute.
+const (
+	transportSocket = "socket" // a system call to send or receive each packet
+	transportRing   = "ring"   // packets are exchanged through rings of memory shared with the kernel
+)
 
 // errNoIP is an error used when an IP cannot be found from an associated MAC address.
-var errNoIP error = fmt.Errorf("error: IP address corresponding to given MAC address not found in system ARP table")
+var errNoIP error = &LookupError{
+	Kind:   ErrNotFound,
+	Detail: "error: IP address corresponding to given MAC address not found in system ARP table",
+}
+
+// errNoMAC is an error used when a MAC address cannot be found for an associated IP.
+var errNoMAC error = &LookupError{
+	Kind:   ErrNotFound,
+	Detail: "error: MAC address corresponding to given IP address not found",
+}
+
+// errReconfigured is returned by lookups still running when the provider is configured again.
+var errReconfigured = errors.New("error: provider was configured again while the lookup was running")
+
+// expired returns the error reported when ctx ends a search for the given thing before it was found. Searches that
+// were cancelled rather than timed out report notFound as is.
+func expired(ctx context.Context, notFound error) error {
+	if ctx.Err() != context.DeadlineExceeded {
+		return notFound
+	}
+
+	return &LookupError{
+		Kind:   ErrTimedOut,
+		Detail: notFound.Error() + " before the timeout expired",
+	}
+}
+
+// getMACFor resolves the MAC address of the host at ip with a client from clients, abstracting out OS specific
+// components.
+func getMACFor(ctx context.Context, clients *clientPool, ip netaddr.IP, data ctxData) (net.HardwareAddr, error) {
+	// The client, and the address it sends requests from, are chosen as if sweeping a network holding ip alone
+	var builder netaddr.IPSetBuilder
+	builder.Add(ip)
+	network, err := builder.IPSet()
+	if err != nil {
+		return nil, err
+	}
+	data.network = network
+
+	ac, release, err := clients.acquire(data)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	resolver, ok := ac.(macResolver)
+	if !ok {
+		return nil, fmt.Errorf("unable to resolve the MAC of %s with %s lookups", ip, lookupMethod(data))
+	}
+
+	return resolver.resolve(ctx, ip, data.backoff, data.maxAttempts)
+}
+
+// cacheVerifyTimeout is the least time given to the host at a cached IP to reply when verifying it, as unlike a
+// sweep there is no later attempt to catch a slow reply.
+const cacheVerifyTimeout = 250 * time.Millisecond
+
+// verifyIPFor checks whether the host at ip still has MAC with a single request from a client from clients, rather
+// than sweeping the network. Passive and DHCP snooping lookups send nothing, so only the system's neighbour table is
+// checked for them.
+func verifyIPFor(ctx context.Context, clients *clientPool, MAC net.HardwareAddr, ip netaddr.IP, data ctxData) (bool, error) {
+	detach, err := attachVLAN(&data)
+	if err != nil {
+		return false, err
+	}
+	defer detach()
+
+	if data.passive || data.dhcpSnoop {
+		entry, ok, err := getNeighbour(data.iface, ip)
+		if err != nil || !ok {
+			return false, err
+		}
+
+		return bytes.Equal(entry.mac, MAC) && data.acceptStates.accepts(entry.state), nil
+	}
+
+	if data.requestTimeout < cacheVerifyTimeout {
+		data.requestTimeout = cacheVerifyTimeout
+	}
+
+	data.macs = mkMACSet(MAC)
+	ac, release, err := clients.acquire(data)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	reply, err := ac.request(ctx, ip)
+	if err != nil || reply.IP != ip || !bytes.Equal(reply.mac, MAC) {
+		return false, err
+	}
+
+	return true, ac.cache(reply)
+}
+
+// getIPFor is a wrapper for checkARPRun, with a client from clients, to abstract out OS specific components.
+func getIPFor(ctx context.Context, clients *clientPool, MAC net.HardwareAddr, data ctxData) (netaddr.IP, error) {
+	detach, err := attachVLAN(&data)
+	if err != nil {
+		return netaddr.IP{}, err
+	}
+	defer detach()
+
+	data.macs = mkMACSet(MAC)
+	ac, release, err := clients.acquire(data)
+	if err != nil {
+		return netaddr.IP{}, err
+	}
+	defer release()
+
+	return checkARPRun(ctx, ac, data)
+}
+
+// getIPForAny runs getIPFor with each of searches concurrently, returning the first IP found along with the
+// interface it was found on. Searches that sweep the network share their sweep with other lookups through scans,
+// if set, while passive and DHCP snooping searches only share their client.
+func getIPForAny(ctx context.Context, clients *clientPool, scans *scanCoordinator, MAC net.HardwareAddr, searches []ctxData) (netaddr.IP, *net.Interface, error) {
+	ip, index, err := checkARPRunAny(ctx, searches, func(ctx context.Context, data ctxData) (netaddr.IP, error) {
+		if scans != nil && !data.passive && !data.dhcpSnoop {
+			return scans.lookup(ctx, MAC, data)
+		}
+		return getIPFor(ctx, clients, MAC, data)
+	})
+	if err != nil {
+		return netaddr.IP{}, nil, err
+	}
+
+	return ip, searches[index].iface, nil
+}
+
+// getHostsFor is a wrapper for checkARPRunCollect, with a client from clients, to abstract out OS specific
+// components.
+func getHostsFor(ctx context.Context, clients *clientPool, data ctxData) ([]IP, error) {
+	detach, err := attachVLAN(&data)
+	if err != nil {
+		return nil, err
+	}
+	defer detach()
+
+	ac, release, err := clients.acquire(data)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return checkARPRunCollect(ctx, ac, data)
+}
+
+// getIPsFor is a wrapper for checkARPRunAll, with a client from clients, to abstract out OS specific components.
+func getIPsFor(ctx context.Context, clients *clientPool, MACs []net.HardwareAddr, data ctxData) (map[string]netaddr.IP, error) {
+	detach, err := attachVLAN(&data)
+	if err != nil {
+		return nil, err
+	}
+	defer detach()
+
+	data.macs = mkMACSet(MACs...)
+	ac, release, err := clients.acquire(data)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return checkARPRunAll(ctx, ac, data.macs, data)
+}
+
+// mkClientFor selects an arpClient able to search every address family present in data.network. IPv4 hosts are
+// resolved with ARP and IPv6 hosts with neighbor discovery, unless the provider lacks the privileges to do so, in
+// which case both are resolved by the kernel in response to UDP probes.
+func mkClientFor(data ctxData, MACs ...net.HardwareAddr) arpClient {
+	v4, v6 := families(data.network)
+
+	if lookupMethod(data) == methodUDP {
+		probe := mkLinuxProbe(MACs...)
+		probe.accept = data.acceptStates
+		probe.requestTimeout = data.requestTimeout
+		probe.v4, probe.v6 = v4 || !v6, v6
+		return probe
+	}
+
+	arp := mkLinuxARP(MACs...)
+	arp.accept = data.acceptStates
+	arp.requestTimeout = data.requestTimeout
+	arp.sourceIP = data.sourceIP
+	arp.network = data.network
+	arp.skipCache = data.skipCache
+	arp.cacheState = data.cacheState
+	arp.helper = data.helper
+	arp.passive = data.passive
+	arp.transport = data.transport
+	ndp := mkLinuxNDP(MACs...)
+	ndp.accept = data.acceptStates
+	ndp.requestTimeout = data.requestTimeout
+	ndp.skipCache = data.skipCache
+	ndp.cacheState = data.cacheState
+
+	switch {
+	case v4 && v6:
+		return &dualStackARP{v4: arp, v6: ndp}
+	case v6:
+		return ndp
+	default:
+		return arp
+	}
+}
+
+// macSet is a set of MAC addresses being searched for, keyed by their canonical string form.
+type macSet map[string]net.HardwareAddr
+
+func mkMACSet(macs ...net.HardwareAddr) macSet {
+	set := make(macSet, len(macs))
+	for _, mac := range macs {
+		set[mac.String()] = mac
+	}
+
+	return set
+}
+
+// has checks whether mac is a member of the set.
+func (s macSet) has(mac net.HardwareAddr) bool {
+	_, ok := s[mac.String()]
+	return ok
+}
+
+// matches checks whether mac is being searched for. An empty set is used for discovery and matches every MAC.
+func (s macSet) matches(mac net.HardwareAddr) bool {
+	return len(s) == 0 || s.has(mac)
+}
+
+// list returns the MACs in the set, in the order of their keys.
+func (s macSet) list() []net.HardwareAddr {
+	keys := make([]string, 0, len(s))
+	for key := range s {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	macs := make([]net.HardwareAddr, len(keys))
+	for i, key := range keys {
+		macs[i] = s[key]
+	}
 
-// getIPFor is a wrapper for checkARPRun to abstract out OS specific components.
-func getIPFor(ctx context.Context, MAC net.HardwareAddr, data ctxData) (netaddr.IP, error) {
-	return checkARPRun(ctx, mkLinuxARP(MAC), data)
+	return macs
+}
+
+// key identifies the set, so that clients searching for the same MACs can be shared.
+func (s macSet) key() string {
+	keys := make([]string, 0, len(s))
+	for key := range s {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return strings.Join(keys, ",")
+}
+
+// target returns the hardware address requests should be sent to. Requests searching for a single MAC are
+// unicast to it, otherwise they are broadcast.
+func (s macSet) target() net.HardwareAddr {
+	if len(s) == 1 {
+		for _, mac := range s {
+			return mac
+		}
+	}
+
+	return net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
 }
 
 // arpClient is an interface that describes a platform agnostic way of performing an ARP lookup for a MAC address.
 type arpClient interface {
 	init(*net.Interface) error // init any resources needed to perform ARP requests
 	destroy() error            // destroy any resources needed to perform ARP requests
-	// send a request to an IP to determine whether its MAC matches one specified in the implementation structure
-	request(netaddr.IP) (IP, error)
+	// send a request to an IP to determine whether its MAC matches one specified in the implementation structure,
+	// returning a zero IP if there is no reply before the request times out or ctx expires
+	request(context.Context, netaddr.IP) (IP, error)
 	try(channels)   // read the system's ARP cache to avoid an expensive `request` call
 	cache(IP) error // add an IP to the system's ARP cache
 }
 
+// dualStackARP is an arpClient that dispatches to an IPv4 or IPv6 client depending on the family of the
+// address being looked up.
+type dualStackARP struct {
+	v4 arpClient
+	v6 arpClient
+}
+
+func (ac *dualStackARP) init(iface *net.Interface) error {
+	if err := ac.v4.init(iface); err != nil {
+		return err
+	}
+
+	return ac.v6.init(iface)
+}
+
+// destroy tears the clients down in the reverse order of init so capabilities are restored correctly.
+func (ac *dualStackARP) destroy() error {
+	err := ac.v6.destroy()
+	if err4 := ac.v4.destroy(); err == nil {
+		err = err4
+	}
+
+	return err
+}
+
+func (ac *dualStackARP) request(ctx context.Context, current netaddr.IP) (IP, error) {
+	if current.Is4() {
+		return ac.v4.request(ctx, current)
+	}
+
+	return ac.v6.request(ctx, current)
+}
+
+func (ac *dualStackARP) try(chans channels) {
+	ac.v4.try(chans)
+	ac.v6.try(chans)
+}
+
+func (ac *dualStackARP) cache(current IP) error {
+	if current.Is4() {
+		return ac.v4.cache(current)
+	}
+
+	return ac.v6.cache(current)
+}
+
+func (ac *dualStackARP) send(current netaddr.IP) error {
+	client := ac.v6
+	if current.Is4() {
+		client = ac.v4
+	}
+
+	async, ok := client.(asyncClient)
+	if !ok {
+		return fmt.Errorf("client for %s does not support asynchronous scans", current.String())
+	}
+
+	return async.send(current)
+}
+
+func (ac *dualStackARP) watch(out chan<- IP) (func(), error) {
+	v4, ok4 := ac.v4.(passiveClient)
+	v6, ok6 := ac.v6.(passiveClient)
+	if !ok4 || !ok6 {
+		return nil, fmt.Errorf("client does not support passive listening")
+	}
+
+	stop4, err := v4.watch(out)
+	if err != nil {
+		return nil, err
+	}
+
+	stop6, err := v6.watch(out)
+	if err != nil {
+		stop4()
+		return nil, err
+	}
+
+	return func() {
+		stop6()
+		stop4()
+	}, nil
+}
+
+func (ac *dualStackARP) listen(out chan<- IP) (func(), error) {
+	v4, ok4 := ac.v4.(asyncClient)
+	v6, ok6 := ac.v6.(asyncClient)
+	if !ok4 || !ok6 {
+		return nil, fmt.Errorf("client does not support asynchronous scans")
+	}
+
+	stop4, err := v4.listen(out)
+	if err != nil {
+		return nil, err
+	}
+
+	stop6, err := v6.listen(out)
+	if err != nil {
+		stop4()
+		return nil, err
+	}
+
+	return func() {
+		stop6()
+		stop4()
+	}, nil
+}
+
+// macResolver is an arpClient that can find the MAC of a single host.
+type macResolver interface {
+	arpClient
+	// resolve finds the MAC of the host at ip, retrying every backoff period until ctx expires or maxAttempts
+	// attempts have been made, if set
+	resolve(ctx context.Context, ip netaddr.IP, backoff time.Duration, maxAttempts int) (net.HardwareAddr, error)
+}
+
+// passiveClient is an arpClient that can report hosts announcing themselves without sending any requests.
+type passiveClient interface {
+	arpClient
+	// watch passes the sender of every packet from a MAC specified in the implementation structure to out, whether
+	// it is a request, reply or gratuitous announcement, until the returned function is called
+	watch(out chan<- IP) (func(), error)
+}
+
+// asyncClient is an arpClient that can send requests without waiting for their replies, which are instead all
+// read by a single listener.
+type asyncClient interface {
+	arpClient
+	send(netaddr.IP) error // send a request to an IP without waiting for a reply
+	// listen passes every reply from a MAC specified in the implementation structure to out until the returned
+	// function is called
+	listen(out chan<- IP) (func(), error)
+}
+
 type IP struct {
 	cached bool
+	mac    net.HardwareAddr
 	netaddr.IP
 }
 
-// lookupIPRange sends a request to all IPs in ipRange to determine whether their MAC matches the MAC in ac.
-func lookupIPRange(ctx context.Context, ac arpClient, ranges []netaddr.IPRange, chans channels) {
-	for _, ipRange := range ranges {
+// missingError is returned when some of the MAC addresses searched for by checkARPRunAll could not be found.
+type missingError struct {
+	macs []string
+}
+
+func (e missingError) Error() string {
+	return fmt.Sprintf("no IP address found for MAC addresses: %s", strings.Join(e.macs, ", "))
+}
+
+// Unwrap allows missingError to be matched against errNoIP.
+func (e missingError) Unwrap() error {
+	return errNoIP
+}
+
+// lookupIPRange sends a request to all IPs in the network to determine whether their MAC matches the MAC in ac.
+// Requests are spread across data.parallelism workers and, if data.rateLimit is set, sent no faster than that many
+// per second. If all is set the sweep continues after a match so that replies from every MAC in ac are reported.
+// It returns once every worker has exited, which is at most one request timeout after ctx expires or chans.stop is
+// closed.
+func lookupIPRange(ctx context.Context, ac arpClient, data ctxData, chans channels, all bool) {
+	workers := data.parallelism
+	if workers < 1 {
+		workers = 1
+	}
+
+	var limit <-chan time.Time
+	if data.rateLimit > 0 && time.Second/time.Duration(data.rateLimit) > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(data.rateLimit))
+		defer ticker.Stop()
+		limit = ticker.C
+	}
+
+	// done is closed once a worker has ended the sweep early, either on error or on the first match
+	done := make(chan struct{})
+	var once sync.Once
+	finish := func() { once.Do(func() { close(done) }) }
+
+	ips := make(chan netaddr.IP)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for current := range ips {
+				result, err := ac.request(ctx, current)
+				if err != nil {
+					select {
+					case chans.errors <- err:
+					case <-chans.stop:
+					case <-ctx.Done():
+					case <-done:
+					}
+					finish()
+					return
+				}
+				if result.IsZero() {
+					continue
+				}
+
+				select {
+				case chans.results <- result:
+				case <-chans.stop:
+					finish()
+					return
+				case <-ctx.Done():
+					finish()
+					return
+				case <-done:
+					return
+				}
+
+				if !all {
+					finish()
+					return
+				}
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(ips)
+
+	for _, ipRange := range data.network.Ranges() {
 		for current := ipRange.From(); current.Compare(ipRange.To()) <= 0; current = current.Next() {
+			if !isValidHost(current) {
+				continue
+			}
+
+			if limit != nil {
+				select {
+				case <-limit:
+				case <-chans.stop:
+					return
+				case <-ctx.Done():
+					return
+				case <-done:
+					return
+				}
+			}
+
 			select {
+			case ips <- current:
 			case <-chans.stop:
 				return
-			default:
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
 			}
+		}
+	}
+}
 
-			if !isValidHost(current) {
+// scanIPRange sends a request to all IPs in the network without waiting for replies, while every reply from a MAC
+// in ac is read off a single listener. Requests are sent no faster than data.rateLimit per second, if set, and the
+// listener is kept open for arpScanLinger after the last request so late replies are not lost. If all is set every
+// reply is reported, otherwise the scan ends at the first match.
+func scanIPRange(ctx context.Context, ac asyncClient, data ctxData, chans channels, all bool) {
+	replies := make(chan IP, asyncReplyBuffer)
+	unsubscribe, err := ac.listen(replies)
+	if err != nil {
+		select {
+		case chans.errors <- err:
+		case <-chans.stop:
+		}
+		return
+	}
+	defer unsubscribe()
+
+	var limit <-chan time.Time
+	if data.rateLimit > 0 && time.Second/time.Duration(data.rateLimit) > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(data.rateLimit))
+		defer ticker.Stop()
+		limit = ticker.C
+	}
+
+	// done is closed once the scan has ended, stopping the sender if it is still running
+	done := make(chan struct{})
+	sent := make(chan struct{})
+	failed := make(chan error, 1)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(sent)
+
+		for _, ipRange := range data.network.Ranges() {
+			for current := ipRange.From(); current.Compare(ipRange.To()) <= 0; current = current.Next() {
+				if !isValidHost(current) {
+					continue
+				}
+
+				if limit != nil {
+					select {
+					case <-limit:
+					case <-done:
+						return
+					}
+				}
+
+				if err := ac.send(current); err != nil {
+					failed <- err
+					return
+				}
+
+				select {
+				case <-done:
+					return
+				default:
+				}
+			}
+		}
+	}()
+	defer wg.Wait()
+	defer close(done)
+
+	var linger <-chan time.Time
+	for {
+		select {
+		case reply := <-replies:
+			if !data.network.Contains(reply.IP) {
 				continue
 			}
 
-			result, err := ac.request(current)
-			if err != nil {
-				chans.errors <- err
+			select {
+			case chans.results <- reply:
+			case <-chans.stop:
+				return
+			case <-ctx.Done():
 				return
 			}
-			if !result.IsZero() {
-				chans.results <- result
+
+			if !all {
 				return
 			}
+		case err := <-failed:
+			select {
+			case chans.errors <- err:
+			case <-chans.stop:
+			}
+			return
+		case <-sent:
+			sent = nil
+			t := time.NewTimer(arpScanLinger)
+			defer t.Stop()
+			linger = t.C
+		case <-linger:
+			return
+		case <-chans.stop:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// listenPassive waits for a host in the network to announce itself to ac, without sending any requests. The first
+// host heard is reported, unless chans.stop is signalled or ctx expires first.
+func listenPassive(ctx context.Context, ac arpClient, data ctxData, chans channels) {
+	passive, ok := ac.(passiveClient)
+	if !ok {
+		select {
+		case chans.errors <- fmt.Errorf("client does not support passive listening"):
+		case <-chans.stop:
+		}
+		return
+	}
+
+	announcements := make(chan IP, asyncReplyBuffer)
+	unwatch, err := passive.watch(announcements)
+	if err != nil {
+		select {
+		case chans.errors <- err:
+		case <-chans.stop:
+		}
+		return
+	}
+	defer unwatch()
+
+	for {
+		select {
+		case ip := <-announcements:
+			if !data.network.Contains(ip.IP) {
+				continue
+			}
+
+			select {
+			case chans.results <- ip:
+			case <-chans.stop:
+			case <-ctx.Done():
+			}
+			return
+		case <-chans.stop:
+			return
+		case <-ctx.Done():
+			return
 		}
+	}
+}
 
+// sweep searches the network for hosts using the strategy selected by data.scanMode, once data.limiter has a slot
+// free. Clients that cannot send requests asynchronously always wait for each reply in turn.
+func sweep(ctx context.Context, ac arpClient, data ctxData, chans channels, all bool) {
+	release, ok := data.limiter.acquire(ctx, chans.stop)
+	if !ok {
+		return
+	}
+	defer release()
+
+	if async, ok := ac.(asyncClient); ok && data.scanMode == scanModeAsync {
+		scanIPRange(ctx, async, data, chans, all)
+		return
+	}
+
+	lookupIPRange(ctx, ac, data, chans, all)
+}
+
+// replyDemux hands replies read off a shared socket by a single reader to the concurrent requests waiting on them,
+// matching them by the IP they were sent to.
+type replyDemux struct {
+	mu        sync.Mutex
+	pending   map[netaddr.IP][]chan IP // requests waiting on each IP, of which there are several on a shared client
+	listeners map[chan<- IP]struct{}
+}
+
+// await registers interest in replies from current, sends a request with send, and waits up to timeout for a
+// reply to be delivered. A zero IP is returned on timeout, or if ctx expires first.
+func (d *replyDemux) await(ctx context.Context, current netaddr.IP, timeout time.Duration, send func() error) (IP, error) {
+	replies := make(chan IP, 1)
+
+	d.mu.Lock()
+	if d.pending == nil {
+		d.pending = map[netaddr.IP][]chan IP{}
+	}
+	d.pending[current] = append(d.pending[current], replies)
+	d.mu.Unlock()
+
+	defer func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		waiting := d.pending[current]
+		for i, ch := range waiting {
+			if ch == replies {
+				waiting = append(waiting[:i], waiting[i+1:]...)
+				break
+			}
+		}
+		if len(waiting) == 0 {
+			delete(d.pending, current)
+		} else {
+			d.pending[current] = waiting
+		}
+	}()
+
+	if err := send(); err != nil {
+		return IP{}, err
+	}
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case reply := <-replies:
+		return reply, nil
+	case <-t.C:
+		return IP{}, nil
+	case <-ctx.Done():
+		return IP{}, nil
+	}
+}
+
+// subscribe passes every reply to out until the returned function is called. Replies are dropped if out is full,
+// so it should be buffered.
+func (d *replyDemux) subscribe(out chan<- IP) func() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.listeners == nil {
+		d.listeners = map[chan<- IP]struct{}{}
+	}
+	d.listeners[out] = struct{}{}
+
+	return func() {
+		d.mu.Lock()
+		delete(d.listeners, out)
+		d.mu.Unlock()
+	}
+}
+
+// deliver passes a reply to the requests waiting on its IP, if any, and to every subscribed listener. Replies nobody
+// is waiting on are dropped.
+func (d *replyDemux) deliver(reply IP) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for out := range d.listeners {
+		select {
+		case out <- reply:
+		default:
+		}
+	}
+
+	for _, replies := range d.pending[reply.IP] {
+		select {
+		case replies <- reply:
+		default:
+		}
 	}
 }
 
 // if timeout is greater or equal to arpfuncbackoff our runtime is greatly increased
 type ctxData struct {
-	iface   *net.Interface
-	network *netaddr.IPSet
-	backoff time.Duration
+	iface          *net.Interface
+	network        *netaddr.IPSet
+	timeout        time.Duration // overall deadline for a lookup
+	backoff        time.Duration
+	requestTimeout time.Duration // how long to wait for a reply to each request
+	maxAttempts    int           // number of sweeps before giving up, or unlimited if zero
+	parallelism    int
+	rateLimit      int
+	scanMode       string
+	transport      string
+	// neighbour table states accepted when checking the system's cache, or the default states if empty
+	acceptStates neighState
+	passive      bool // listen for hosts announcing themselves instead of sweeping the network
+	dhcpSnoop    bool // listen for DHCPACKs leasing an IP to one of macs
+	macs         macSet
+	sources      []lookupSource     // searched for macs alongside the system's neighbour table
+	sourceIP     netaddr.IP         // address to send ARP requests from, or chosen from iface if zero
+	vlanID       int                // 802.1Q VLAN of iface to search, or untagged if zero
+	skipCache    bool               // don't add found hosts to the neighbour table
+	cacheState   neighState         // state found hosts are added to the neighbour table in
+	helper       *privhelper.Client // opens raw sockets in place of the provider, if set
+	limiter      *scanLimiter       // shared by every lookup to bound the sweeps running at once, if set
+	vlans        *vlanLinks         // VLAN sub-interfaces shared by every lookup, if set
 }
 
 type stopType struct{}
@@ -83,43 +870,294 @@ func makeChannels() channels {
 	return channels{
 		results: make(chan IP, 1),
 		errors:  make(chan error, 1),
-		stop:    make(chan stopType, 1),
+		stop:    make(chan stopType),
 	}
 }
 
-// checkARPRun searches an ARP table for a given MAC address in a platform agnostic way. It is important
-// to check if the returned error is macNotFoundError to determine the difference between a failure in
-// operation and a failure to find the mac in the system's table.
-func checkARPRun(ctx context.Context, ac arpClient, data ctxData) (ip netaddr.IP, err error) {
-	ac.init(data.iface)
-	defer ac.destroy()
+// lookupRun tracks the goroutines searching for hosts on behalf of a lookup, so that every one of them has exited
+// before the lookup returns and destroys its client. The system's cache and lease sources are checked on every
+// attempt, while a new sweep is only started once the previous one has finished, so that sweeps slower than the
+// backoff period don't pile up.
+type lookupRun struct {
+	ctx      context.Context // cancelled by stop
+	cancel   context.CancelFunc
+	chans    channels
+	wg       sync.WaitGroup
+	sweeping chan struct{} // closed once the latest sweep has finished, or nil before the first
+}
 
-	chans := makeChannels()
-	defer close(chans.stop)
+func startLookupRun(ctx context.Context) *lookupRun {
+	ctx, cancel := context.WithCancel(ctx)
 
-outer:
-	for {
-		go ac.try(chans)
-		go lookupIPRange(ctx, ac, data.network.Ranges(), chans)
+	return &lookupRun{
+		ctx:    ctx,
+		cancel: cancel,
+		chans:  makeChannels(),
+	}
+}
+
+// spawn runs fn in a goroutine that stop waits on. fn must return once the run's context is cancelled or its stop
+// channel is closed.
+func (r *lookupRun) spawn(fn func()) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		fn()
+	}()
+}
+
+// check searches ac's view of the system's cache, and data's lease sources, for data.macs.
+func (r *lookupRun) check(ac arpClient, data ctxData) {
+	r.spawn(func() { ac.try(r.chans) })
+	r.spawn(func() { trySources(r.chans, data) })
+}
+
+// attempt checks the system's cache and lease sources, and sweeps the network unless the previous sweep is still
+// running.
+func (r *lookupRun) attempt(ac arpClient, data ctxData, all bool) {
+	r.check(ac, data)
+
+	if r.sweeping != nil {
+		select {
+		case <-r.sweeping:
+		default:
+			return
+		}
+	}
+
+	done := make(chan struct{})
+	r.sweeping = done
+	r.spawn(func() {
+		defer close(done)
+		sweep(r.ctx, ac, data, r.chans, all)
+	})
+}
+
+// stop cancels the run and waits for every goroutine it started to exit.
+func (r *lookupRun) stop() {
+	r.cancel()
+	close(r.chans.stop)
+	r.wg.Wait()
+}
+
+// checkARPRun searches an ARP table for a given MAC address in a platform agnostic way. It is important to check if the
+// returned error is ErrNotFound to determine the difference between a failure in operation and a failure to find the
+// mac in the system's table, and ErrTimedOut to tell whether ctx expired first. If data.passive is set the network is
+// not swept, and instead the host is waited on to announce itself. Any lease sources in data are searched alongside the
+// system's table, and if data.dhcpSnoop is set the host's DHCPACK is listened for. Every goroutine started for the
+// search has exited by the time it returns. ac must have been initialised, and may be shared with other lookups, in
+// which case hosts other than those in data.macs are ignored.
+func checkARPRun(ctx context.Context, ac arpClient, data ctxData) (netaddr.IP, error) {
+	run := startLookupRun(ctx)
+	defer run.stop()
+
+	if data.passive {
+		run.spawn(func() { listenPassive(run.ctx, ac, data, run.chans) })
+	}
+	if data.dhcpSnoop {
+		run.spawn(func() { snoopDHCP(run.ctx, data, run.chans) })
+	}
+
+	// A shared client reports every host it hears from, so the sweep can't end at the first
+	all := len(data.macs) > 0
+
+	for attempt := 1; ; attempt++ {
+		if data.passive {
+			run.check(ac, data)
+		} else {
+			run.attempt(ac, data, all)
+		}
 
 		t := time.NewTimer(data.backoff)
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return netaddr.IP{}, expired(ctx, errNoIP)
+			case err := <-run.chans.errors:
+				t.Stop()
+				return netaddr.IP{}, err
+			case ip := <-run.chans.results:
+				if len(data.macs) > 0 && !data.macs.has(ip.mac) {
+					continue
+				}
+
+				t.Stop()
+				if err := ac.cache(ip); err != nil {
+					return netaddr.IP{}, err
+				}
+				return ip.IP, nil
+			case <-t.C:
+				if attempt == data.maxAttempts {
+					return netaddr.IP{}, errNoIP
+				}
+				break wait
+			}
+		}
+	}
+}
+
+// checkARPRunAny runs a search with each of searches concurrently, returning the first IP found and the index of the
+// search that found it. The other searches are cancelled on the first success. If every search fails, an error
+// other than ErrNotFound is preferred, as it is more likely to be what needs fixing.
+func checkARPRunAny(ctx context.Context, searches []ctxData,
+	run func(context.Context, ctxData) (netaddr.IP, error)) (netaddr.IP, int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		ip    netaddr.IP
+		index int
+		err   error
+	}
+
+	results := make(chan result, len(searches))
+	for i, data := range searches {
+		go func(i int, data ctxData) {
+			ip, err := run(ctx, data)
+			results <- result{ip: ip, index: i, err: err}
+		}(i, data)
+	}
+
+	errs := make([]error, len(searches))
+	found := -1
+	var ip netaddr.IP
+	for range searches {
+		r := <-results
+		errs[r.index] = r.err
+		if r.err == nil && found < 0 {
+			found, ip = r.index, r.ip
+			cancel()
+		}
+	}
+
+	if found >= 0 {
+		return ip, found, nil
+	}
+
+	for _, err := range errs {
+		if !errors.Is(err, ErrNotFound) {
+			return netaddr.IP{}, 0, err
+		}
+	}
+
+	return netaddr.IP{}, 0, errs[0]
+}
+
+// checkARPRunAll searches for every MAC address in macs with a single sweep of the network per backoff period,
+// returning a map of MAC address to IP. It stops once all MACs have been found, and otherwise returns the IPs found
+// so far along with a missingError listing the MACs that were not once ctx expires or data.maxAttempts sweeps
+// have been made. ac must have been initialised.
+func checkARPRunAll(ctx context.Context, ac arpClient, macs macSet, data ctxData) (map[string]netaddr.IP, error) {
+	found := make(map[string]netaddr.IP, len(macs))
+
+	run := startLookupRun(ctx)
+	defer run.stop()
+
+	missing := func() error {
+		keys := []string{}
+		for key := range macs {
+			if _, ok := found[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		return missingError{macs: keys}
+	}
+
+	for attempt := 1; ; attempt++ {
+		run.attempt(ac, data, true)
+
+		t := time.NewTimer(data.backoff)
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return found, missing()
+			case err := <-run.chans.errors:
+				t.Stop()
+				return found, err
+			case ip := <-run.chans.results:
+				key := ip.mac.String()
+				if _, ok := found[key]; ok || !macs.has(ip.mac) {
+					continue
+				}
+
+				if err := ac.cache(ip); err != nil {
+					t.Stop()
+					return found, err
+				}
+
+				found[key] = ip.IP
+				if len(found) == len(macs) {
+					t.Stop()
+					return found, nil
+				}
+			case <-t.C:
+				if attempt == data.maxAttempts {
+					return found, missing()
+				}
+				break wait
+			}
+		}
+	}
+}
+
+// checkARPRunCollect sweeps the network once, collecting every host that is found in the system's ARP cache or
+// replies to a request. An ARP client searching for no MACs in particular should be used. Hosts are returned
+// ordered by IP, with replies taking precedence over cache entries for the same IP. ac must have been initialised.
+func checkARPRunCollect(ctx context.Context, ac arpClient, data ctxData) ([]IP, error) {
+	run := startLookupRun(ctx)
+	defer run.stop()
+
+	done := make(chan struct{})
+	run.spawn(func() {
+		defer close(done)
+		ac.try(run.chans)
+		sweep(run.ctx, ac, data, run.chans, true)
+	})
+
+	found := map[netaddr.IP]IP{}
+	add := func(ip IP) {
+		// The neighbour table holds hosts on every network of the interface, not only the one swept
+		if !data.network.Contains(ip.IP) {
+			return
+		}
+		if existing, ok := found[ip.IP]; ok && !existing.cached {
+			return
+		}
+		found[ip.IP] = ip
+	}
+
+	for {
 		select {
 		case <-ctx.Done():
-			t.Stop()
+			return nil, &LookupError{
+				Kind:   ErrTimedOut,
+				Detail: fmt.Sprintf("sweep did not complete before timing out, %d hosts found", len(found)),
+			}
+		case err := <-run.chans.errors:
+			return nil, err
+		case ip := <-run.chans.results:
+			add(ip)
+		case <-done:
+			// The last result may still be buffered once the sweep completes
+			select {
+			case ip := <-run.chans.results:
+				add(ip)
+			default:
+			}
 
-			chans.stop <- struct{}{}
-			return netaddr.IP{}, errNoIP
-		case err = <-chans.errors:
-			break outer
-		case ip := <-chans.results:
-			if err = ac.cache(ip); err != nil {
-				break outer
+			hosts := make([]IP, 0, len(found))
+			for _, ip := range found {
+				hosts = append(hosts, ip)
 			}
-			return ip.IP, nil
-		case <-t.C:
+			sort.Slice(hosts, func(i, j int) bool { return hosts[i].Less(hosts[j].IP) })
+
+			return hosts, nil
 		}
 	}
-
-	chans.stop <- struct{}{}
-	return netaddr.IP{}, err
 }
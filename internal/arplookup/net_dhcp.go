@@ -0,0 +1,410 @@
+package arplookup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"inet.af/netaddr"
+)
+
+// Formats of DHCP lease files, as selected by the `format` of a provider's `lease_files`.
+const (
+	leaseFormatDnsmasq = "dnsmasq" // dnsmasq.leases
+	leaseFormatISC     = "isc"     // ISC dhcpd.leases
+	leaseFormatKea     = "kea"     // Kea lease4 memfile CSV
+)
+
+// lookupSource is a source other than the system's neighbour table that can be searched for hosts.
+type lookupSource interface {
+	leases() ([]lease, error)
+}
+
+// lease is a single DHCP lease of an IP to a MAC address.
+type lease struct {
+	ip     netaddr.IP
+	mac    net.HardwareAddr
+	expiry time.Time // zero for leases that never expire
+}
+
+// active checks whether the lease has not expired at now.
+func (l lease) active(now time.Time) bool {
+	return l.expiry.IsZero() || l.expiry.After(now)
+}
+
+// outlasts checks whether the lease expires after other.
+func (l lease) outlasts(other lease) bool {
+	if other.expiry.IsZero() {
+		return false
+	}
+
+	return l.expiry.IsZero() || l.expiry.After(other.expiry)
+}
+
+// leaseFile is a lookupSource reading leases from a DHCP server's lease file.
+type leaseFile struct {
+	format string
+	path   string
+}
+
+func mkLeaseFile(format string, path string) (*leaseFile, error) {
+	switch format {
+	case leaseFormatDnsmasq, leaseFormatISC, leaseFormatKea:
+	default:
+		return nil, fmt.Errorf("unknown lease file format \"%s\"", format)
+	}
+
+	return &leaseFile{format: format, path: path}, nil
+}
+
+// leases implements lookupSource for leaseFile.
+func (f *leaseFile) leases() ([]lease, error) {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open lease file: %w", err)
+	}
+	defer file.Close()
+
+	var leases []lease
+	switch f.format {
+	case leaseFormatDnsmasq:
+		leases, err = parseDnsmasqLeases(file)
+	case leaseFormatISC:
+		leases, err = parseISCLeases(file)
+	case leaseFormatKea:
+		leases, err = parseKeaLeases(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse lease file %s: %w", f.path, err)
+	}
+
+	return leases, nil
+}
+
+// parseDnsmasqLeases parses leases in the format of dnsmasq.leases. Each line holds the expiry as a unix time, the
+// MAC, the IP, the hostname and the client ID. DHCPv6 leases, which have an IAID in place of the MAC, are skipped.
+func parseDnsmasqLeases(r io.Reader) ([]lease, error) {
+	leases := []lease{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		text := scanner.Text()
+		fields := strings.Fields(text)
+		if len(fields) < 3 {
+			continue
+		}
+
+		mac, err := net.ParseMAC(fields[1])
+		if err != nil {
+			continue
+		}
+
+		ip, err := netaddr.ParseIP(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("line: \"%s\" error %w", text, err)
+		}
+
+		expires, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line: \"%s\" error %w", text, err)
+		}
+
+		var expiry time.Time
+		if expires != 0 {
+			expiry = time.Unix(expires, 0)
+		}
+
+		leases = append(leases, lease{ip: ip, mac: mac, expiry: expiry})
+	}
+
+	return leases, scanner.Err()
+}
+
+// parseISCLeases parses leases in the format of ISC dhcpd.leases. The file is a journal, so a later declaration
+// of a lease replaces earlier ones for the same IP. Leases without a MAC or that are not active are skipped.
+func parseISCLeases(r io.Reader) ([]lease, error) {
+	byIP := map[netaddr.IP]int{}
+	leases := []lease{}
+
+	var current *lease
+	active := true
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		text := scanner.Text()
+		if comment := strings.Index(text, "#"); comment >= 0 {
+			text = text[:comment]
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+
+		fields := strings.Fields(strings.TrimSuffix(text, ";"))
+		// A stray ";" is an empty statement
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch {
+		case current == nil && fields[0] == "lease" && len(fields) >= 3 && fields[2] == "{":
+			ip, err := netaddr.ParseIP(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line: \"%s\" error %w", text, err)
+			}
+			current, active = &lease{ip: ip}, true
+		case current == nil:
+			continue
+		case fields[0] == "}":
+			if index, ok := byIP[current.ip]; ok {
+				leases[index] = lease{}
+				delete(byIP, current.ip)
+			}
+			if active && current.mac != nil {
+				byIP[current.ip] = len(leases)
+				leases = append(leases, *current)
+			}
+			current = nil
+		case fields[0] == "hardware" && len(fields) == 3:
+			mac, err := net.ParseMAC(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("line: \"%s\" error %w", text, err)
+			}
+			current.mac = mac
+		case fields[0] == "binding" && len(fields) == 3 && fields[1] == "state":
+			active = fields[2] == "active"
+		case fields[0] == "ends":
+			expiry, err := parseISCTime(fields[1:])
+			if err != nil {
+				return nil, fmt.Errorf("line: \"%s\" error %w", text, err)
+			}
+			current.expiry = expiry
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Leases that were replaced by a later declaration are left zeroed
+	compacted := leases[:0]
+	for _, l := range leases {
+		if l.mac != nil {
+			compacted = append(compacted, l)
+		}
+	}
+
+	return compacted, nil
+}
+
+// parseISCTime parses a time from dhcpd.leases, which is either "never", "epoch <unix time>" or
+// "<weekday> <yyyy/mm/dd> <hh:mm:ss>" in UTC.
+func parseISCTime(fields []string) (time.Time, error) {
+	switch {
+	case len(fields) == 1 && fields[0] == "never":
+		return time.Time{}, nil
+	case len(fields) >= 2 && fields[0] == "epoch":
+		unix, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(unix, 0), nil
+	case len(fields) == 3:
+		return time.Parse("2006/01/02 15:04:05", fields[1]+" "+fields[2])
+	}
+
+	return time.Time{}, fmt.Errorf("unknown time format")
+}
+
+// keaStateDefault is the state of a Kea lease that is in use.
+const keaStateDefault = "0"
+
+// parseKeaLeases parses leases in the format of Kea's lease4 memfile CSV. The file is a journal, so a later row
+// for an IP replaces earlier ones. Columns are found by the header, and leases that are not in use are skipped.
+func parseKeaLeases(r io.Reader) ([]lease, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return []lease{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, name := range []string{"address", "hwaddr", "expire", "state"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing column \"%s\"", name)
+		}
+	}
+
+	byIP := map[netaddr.IP]lease{}
+	order := []netaddr.IP{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) != len(header) {
+			continue
+		}
+
+		ip, err := netaddr.ParseIP(record[columns["address"]])
+		if err != nil {
+			return nil, fmt.Errorf("record: \"%s\" error %w", strings.Join(record, ","), err)
+		}
+
+		if _, ok := byIP[ip]; !ok {
+			order = append(order, ip)
+		}
+
+		mac, err := net.ParseMAC(record[columns["hwaddr"]])
+		if err != nil || record[columns["state"]] != keaStateDefault {
+			byIP[ip] = lease{}
+			continue
+		}
+
+		expires, err := strconv.ParseInt(record[columns["expire"]], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("record: \"%s\" error %w", strings.Join(record, ","), err)
+		}
+
+		byIP[ip] = lease{ip: ip, mac: mac, expiry: time.Unix(expires, 0)}
+	}
+
+	leases := []lease{}
+	for _, ip := range order {
+		if l := byIP[ip]; l.mac != nil {
+			leases = append(leases, l)
+		}
+	}
+
+	return leases, nil
+}
+
+// trySources reports the latest active lease in data.network for each MAC in data.macs found in data.sources.
+func trySources(chans channels, data ctxData) {
+	if len(data.macs) == 0 {
+		return
+	}
+
+	now := time.Now()
+	latest := map[string]lease{}
+	for _, source := range data.sources {
+		leases, err := source.leases()
+		if err != nil {
+			select {
+			case chans.errors <- err:
+			case <-chans.stop:
+			}
+			return
+		}
+
+		for _, l := range leases {
+			if !l.active(now) || !data.macs.has(l.mac) || !data.network.Contains(l.ip) {
+				continue
+			}
+
+			key := l.mac.String()
+			if existing, ok := latest[key]; !ok || l.outlasts(existing) {
+				latest[key] = l
+			}
+		}
+	}
+
+	for _, l := range latest {
+		select {
+		case chans.results <- IP{cached: true, mac: l.mac, IP: l.ip}:
+		case <-chans.stop:
+			return
+		}
+	}
+}
+
+// DHCP constants needed to recognise a DHCPACK.
+const (
+	dhcpServerPort   = 67
+	dhcpClientPort   = 68
+	bootpReply       = 2
+	dhcpMagicOffset  = 236
+	dhcpOptionsStart = 240
+	dhcpOptionPad    = 0
+	dhcpOptionType   = 53
+	dhcpOptionEnd    = 255
+	dhcpTypeACK      = 5
+)
+
+var dhcpMagicCookie = []byte{0x63, 0x82, 0x53, 0x63}
+
+// parseDHCPAck parses an IPv4 packet, returning the leased IP and client MAC if it is a DHCPACK sent by a server.
+func parseDHCPAck(b []byte) (IP, bool) {
+	// IPv4 header, with the protocol in byte 9
+	if len(b) < 20 || b[0]>>4 != 4 || b[9] != 17 {
+		return IP{}, false
+	}
+	ihl := int(b[0]&0x0f) * 4
+
+	// UDP header
+	if len(b) < ihl+8 {
+		return IP{}, false
+	}
+	udp := b[ihl:]
+	if int(udp[0])<<8|int(udp[1]) != dhcpServerPort || int(udp[2])<<8|int(udp[3]) != dhcpClientPort {
+		return IP{}, false
+	}
+
+	// BOOTP message, with the leased IP in yiaddr and the client's MAC in chaddr
+	msg := udp[8:]
+	if len(msg) < dhcpOptionsStart || msg[0] != bootpReply || msg[2] != 6 {
+		return IP{}, false
+	}
+	if !bytes.Equal(msg[dhcpMagicOffset:dhcpOptionsStart], dhcpMagicCookie) {
+		return IP{}, false
+	}
+
+	ack := false
+	for options := msg[dhcpOptionsStart:]; len(options) > 0; {
+		code := options[0]
+		if code == dhcpOptionEnd {
+			break
+		}
+		if code == dhcpOptionPad {
+			options = options[1:]
+			continue
+		}
+		if len(options) < 2 || len(options) < 2+int(options[1]) {
+			return IP{}, false
+		}
+
+		if code == dhcpOptionType && options[1] == 1 {
+			ack = options[2] == dhcpTypeACK
+		}
+		options = options[2+int(options[1]):]
+	}
+	if !ack {
+		return IP{}, false
+	}
+
+	ip := netaddr.IPv4(msg[16], msg[17], msg[18], msg[19])
+	if ip.IsUnspecified() {
+		return IP{}, false
+	}
+
+	mac := make(net.HardwareAddr, 6)
+	copy(mac, msg[28:34])
+
+	return IP{cached: false, mac: mac, IP: ip}, true
+}
@@ -0,0 +1,79 @@
+package arplookup
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/mdlayher/packet"
+	"terraform-provider-arplookup/internal/privhelper"
+)
+
+// etherTypeIPv4 is the EtherType of IPv4 packets.
+const etherTypeIPv4 = 0x0800
+
+// snoopDHCP listens on data.iface for DHCPACKs leasing an IP in the network to one of data.macs, reporting the
+// first found. Both ACKs received by and sent from the interface are seen, so this works on hosts running a DHCP
+// server as well as hosts receiving broadcast ACKs. It returns once chans.stop is signalled or ctx expires.
+func snoopDHCP(ctx context.Context, data ctxData, chans channels) {
+	conn, err := listenDHCP(data)
+	if err != nil {
+		select {
+		case chans.errors <- fmt.Errorf("unable to listen for DHCP packets on %s: %w", data.iface.Name, err):
+		case <-chans.stop:
+		}
+		return
+	}
+
+	// Closing the connection unblocks the read loop
+	done := make(chan struct{})
+	closed := make(chan struct{})
+	defer func() {
+		close(done)
+		<-closed
+	}()
+	go func() {
+		defer close(closed)
+		select {
+		case <-chans.stop:
+		case <-ctx.Done():
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	buf := make([]byte, data.iface.MTU)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		ack, ok := parseDHCPAck(buf[:n])
+		if !ok || !data.macs.has(ack.mac) || !data.network.Contains(ack.IP) {
+			continue
+		}
+
+		select {
+		case chans.results <- ack:
+		case <-chans.stop:
+		case <-ctx.Done():
+		}
+		return
+	}
+}
+
+// listenDHCP opens a socket receiving DHCP replies on data.iface, through the privileged helper if one is running.
+// The kernel drops every other IPv4 packet, which the helper's sockets already do.
+func listenDHCP(data ctxData) (net.PacketConn, error) {
+	if data.helper != nil {
+		return data.helper.ListenPacket(data.iface, privhelper.TypeDatagram, etherTypeIPv4)
+	}
+
+	filter, err := privhelper.DHCPFilter()
+	if err != nil {
+		return nil, err
+	}
+
+	return packet.Listen(data.iface, packet.Datagram, etherTypeIPv4, &packet.Config{Filter: filter})
+}
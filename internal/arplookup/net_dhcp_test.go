@@ -0,0 +1,173 @@
+package arplookup
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"inet.af/netaddr"
+)
+
+func TestParseDnsmasqLeases(t *testing.T) {
+	file := `1657800000 3e:50:6e:54:28:3d 192.168.1.10 host-a 01:3e:50:6e:54:28:3d
+0 3e:50:6e:54:28:3e 192.168.1.11 * *
+duid 00:01:00:01:2a:6b:2d:4f:3e:50:6e:54:28:3d
+1657800000 1045891 fd18::10 host-a 00:01:00:01:2a:6b:2d:4f:3e:50:6e:54:28:3d
+`
+
+	leases, err := parseDnsmasqLeases(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("error encountered while running test: %s", err.Error())
+	}
+
+	macA, _ := net.ParseMAC("3e:50:6e:54:28:3d")
+	macB, _ := net.ParseMAC("3e:50:6e:54:28:3e")
+
+	expect := []lease{
+		{ip: netaddr.MustParseIP("192.168.1.10"), mac: macA, expiry: time.Unix(1657800000, 0)},
+		{ip: netaddr.MustParseIP("192.168.1.11"), mac: macB},
+	}
+	if !reflect.DeepEqual(leases, expect) {
+		t.Fatalf("expected leases: %v, got: %v", expect, leases)
+	}
+}
+
+func TestParseISCLeases(t *testing.T) {
+	file := `# The format of this file is documented in the dhcpd.leases(5) manual page.
+lease 192.168.1.10 {
+  starts 4 2022/07/14 10:00:00;
+  ends 4 2022/07/14 22:00:00;
+  binding state active;
+  hardware ethernet 3e:50:6e:54:28:3d;
+}
+lease 192.168.1.11 {
+  starts 4 2022/07/14 10:00:00;
+  ends epoch 1657800000; # Thu Jul 14 12:00:00 2022
+   ;
+  binding state active;
+  hardware ethernet 3e:50:6e:54:28:3e;
+}
+lease 192.168.1.10 {
+  starts 4 2022/07/14 10:00:00;
+  ends 4 2022/07/14 11:00:00;
+  binding state free;
+  hardware ethernet 3e:50:6e:54:28:3d;
+}
+;
+lease 192.168.1.12 {
+  ends never;
+  hardware ethernet 3e:50:6e:54:28:3d;
+}
+`
+
+	leases, err := parseISCLeases(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("error encountered while running test: %s", err.Error())
+	}
+
+	macA, _ := net.ParseMAC("3e:50:6e:54:28:3d")
+	macB, _ := net.ParseMAC("3e:50:6e:54:28:3e")
+
+	expect := []lease{
+		{ip: netaddr.MustParseIP("192.168.1.11"), mac: macB, expiry: time.Unix(1657800000, 0)},
+		{ip: netaddr.MustParseIP("192.168.1.12"), mac: macA},
+	}
+	if !reflect.DeepEqual(leases, expect) {
+		t.Fatalf("expected leases: %v, got: %v", expect, leases)
+	}
+}
+
+func TestParseKeaLeases(t *testing.T) {
+	file := `address,hwaddr,client_id,valid_lifetime,expire,subnet_id,fqdn_fwd,fqdn_rev,hostname,state,user_context
+192.168.1.10,3e:50:6e:54:28:3d,,3600,1657800000,1,0,0,host-a,0,
+192.168.1.11,3e:50:6e:54:28:3e,,3600,1657800000,1,0,0,host-b,0,
+192.168.1.11,3e:50:6e:54:28:3e,,0,1657796400,1,0,0,host-b,2,
+`
+
+	leases, err := parseKeaLeases(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("error encountered while running test: %s", err.Error())
+	}
+
+	macA, _ := net.ParseMAC("3e:50:6e:54:28:3d")
+
+	expect := []lease{
+		{ip: netaddr.MustParseIP("192.168.1.10"), mac: macA, expiry: time.Unix(1657800000, 0)},
+	}
+	if !reflect.DeepEqual(leases, expect) {
+		t.Fatalf("expected leases: %v, got: %v", expect, leases)
+	}
+}
+
+// mkDHCPPacket builds an IPv4 packet holding a DHCP message of the given type from a server to a client.
+func mkDHCPPacket(msgType byte, yiaddr netaddr.IP, chaddr net.HardwareAddr) []byte {
+	msg := make([]byte, dhcpOptionsStart)
+	msg[0], msg[1], msg[2] = bootpReply, 1, 6
+	ip := yiaddr.As4()
+	copy(msg[16:20], ip[:])
+	copy(msg[28:34], chaddr)
+	copy(msg[dhcpMagicOffset:], dhcpMagicCookie)
+	msg = append(msg, dhcpOptionPad, dhcpOptionType, 1, msgType, dhcpOptionEnd)
+
+	udp := []byte{0, dhcpServerPort, 0, dhcpClientPort, 0, 0, 0, 0}
+	header := make([]byte, 20)
+	header[0], header[9] = 0x45, 17
+
+	return append(append(header, udp...), msg...)
+}
+
+func TestParseDHCPAck(t *testing.T) {
+	mac, _ := net.ParseMAC("3e:50:6e:54:28:3d")
+	ip := netaddr.MustParseIP("192.168.1.10")
+
+	ack, ok := parseDHCPAck(mkDHCPPacket(dhcpTypeACK, ip, mac))
+	if !ok {
+		t.Fatalf("DHCPACK not recognised")
+	}
+	if ack.IP != ip || ack.mac.String() != mac.String() {
+		t.Fatalf("expected lease of %s to %s, got: %s to %s", ip.String(), mac.String(), ack.IP.String(), ack.mac.String())
+	}
+
+	// DHCPOFFER
+	if _, ok := parseDHCPAck(mkDHCPPacket(2, ip, mac)); ok {
+		t.Fatalf("DHCPOFFER recognised as DHCPACK")
+	}
+
+	if _, ok := parseDHCPAck(mkDHCPPacket(dhcpTypeACK, ip, mac)[:100]); ok {
+		t.Fatalf("truncated packet recognised as DHCPACK")
+	}
+}
+
+// TestCheckARPRunLeases checks whether a MAC can be found in a lease source when the host doesn't reply, and that
+// expired leases and leases outside of the network are ignored.
+func TestCheckARPRunLeases(t *testing.T) {
+	var builder netaddr.IPSetBuilder
+	builder.AddPrefix(netaddr.MustParseIPPrefix("192.168.33.0/24"))
+	ipSet, _ := builder.IPSet()
+
+	mac, _ := net.ParseMAC("3e:50:6e:54:28:3d")
+	source := dummySource{
+		{ip: netaddr.MustParseIP("192.168.33.20"), mac: mac, expiry: time.Now().Add(-time.Hour)},
+		{ip: netaddr.MustParseIP("10.0.33.20"), mac: mac},
+		{ip: netaddr.MustParseIP("192.168.33.30"), mac: mac, expiry: time.Now().Add(time.Hour)},
+	}
+
+	ac := mkDummyARP(netaddr.MustParseIP("10.0.0.1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	data := ctxData{network: ipSet, backoff: arpFuncBackoff, macs: mkMACSet(mac), sources: []lookupSource{source}}
+	ip, err := checkARPRun(ctx, ac, data)
+	if err != nil {
+		t.Fatalf("error encountered while running test: %s", err.Error())
+	}
+
+	expect := netaddr.MustParseIP("192.168.33.30")
+	if ip != expect {
+		t.Fatalf("expected IP: %s, got: %s", expect.String(), ip.String())
+	}
+}
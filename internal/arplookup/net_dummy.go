@@ -1,14 +1,22 @@
 package arplookup
 
 import (
+	"context"
 	"net"
+	"sync/atomic"
+	"time"
 
 	"inet.af/netaddr"
 )
 
 // dummyARP is a stub arpClient for unit testing.
 type dummyARP struct {
-	needle netaddr.IP
+	needle   netaddr.IP
+	hosts    map[netaddr.IP]net.HardwareAddr
+	latency  time.Duration // simulated wait for each request's reply
+	replies  replyDemux
+	requests int64 // number of requests sent, updated atomically
+	cached   []IP  // entries of the simulated neighbour table, reported by try
 }
 
 // mkDummyARP constructs a dummyARP struct.
@@ -18,16 +26,79 @@ func mkDummyARP(needle netaddr.IP) *dummyARP {
 	}
 }
 
+// mkDummyARPHosts constructs a dummyARP struct that answers for every host in hosts.
+func mkDummyARPHosts(hosts map[netaddr.IP]net.HardwareAddr) *dummyARP {
+	return &dummyARP{
+		hosts: hosts,
+	}
+}
+
 // request implements arpClient for dummyARP. This is a dummy implementation intended for testing and will
-// return a "needle" IP once it has been requested.
-func (ac *dummyARP) request(current netaddr.IP) (ip IP, err error) {
+// return a "needle" IP, or any of its hosts, once it has been requested.
+func (ac *dummyARP) request(ctx context.Context, current netaddr.IP) (ip IP, err error) {
+	atomic.AddInt64(&ac.requests, 1)
+
+	if ac.latency > 0 {
+		t := time.NewTimer(ac.latency)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return IP{}, nil
+		}
+	}
+
 	if current == ac.needle {
 		return IP{cached: false, IP: ac.needle}, nil
 	}
 
+	if mac, ok := ac.hosts[current]; ok {
+		return IP{cached: false, mac: mac, IP: current}, nil
+	}
+
 	return IP{}, nil
 }
 
+// send implements asyncClient for dummyARP. Replies for the needle IP, or any of its hosts, are passed to listeners
+// once latency has elapsed.
+func (ac *dummyARP) send(current netaddr.IP) error {
+	atomic.AddInt64(&ac.requests, 1)
+
+	var reply IP
+	switch mac, ok := ac.hosts[current]; {
+	case current == ac.needle:
+		reply = IP{cached: false, IP: ac.needle}
+	case ok:
+		reply = IP{cached: false, mac: mac, IP: current}
+	default:
+		return nil
+	}
+
+	go func() {
+		time.Sleep(ac.latency)
+		ac.replies.deliver(reply)
+	}()
+
+	return nil
+}
+
+// watch implements passiveClient for dummyARP. The needle IP announces itself once latency has elapsed.
+func (ac *dummyARP) watch(out chan<- IP) (func(), error) {
+	unwatch := ac.replies.subscribe(out)
+
+	go func() {
+		time.Sleep(ac.latency)
+		ac.replies.deliver(IP{cached: false, IP: ac.needle})
+	}()
+
+	return unwatch, nil
+}
+
+// listen implements asyncClient for dummyARP.
+func (ac *dummyARP) listen(out chan<- IP) (func(), error) {
+	return ac.replies.subscribe(out), nil
+}
+
 // init implements arpClient for dummyARP. This is a stub.
 func (ac *dummyARP) init(*net.Interface) error { return nil }
 
@@ -36,4 +107,21 @@ func (ac *dummyARP) destroy() error { return nil }
 
 func (ac *dummyARP) cache(IP) error { return nil }
 
-func (ac *dummyARP) try(chans channels) {}
+// try implements arpClient for dummyARP, reporting every entry of the simulated neighbour table.
+func (ac *dummyARP) try(chans channels) {
+	for _, ip := range ac.cached {
+		select {
+		case chans.results <- ip:
+		case <-chans.stop:
+			return
+		}
+	}
+}
+
+// dummySource is a stub lookupSource for unit testing.
+type dummySource []lease
+
+// leases implements lookupSource for dummySource.
+func (s dummySource) leases() ([]lease, error) {
+	return s, nil
+}
@@ -0,0 +1,100 @@
+package arplookup
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+
+	"golang.org/x/net/bpf"
+)
+
+// Offsets into the ethernet frames read off an ARP socket.
+const (
+	frameEtherType = 12 // EtherType of the frame
+	frameARPOp     = 20 // operation of the ARP packet following the 14 byte ethernet header
+	frameARPSender = 22 // sender hardware address of the ARP packet
+)
+
+const (
+	etherTypeARP = 0x0806
+	arpOpReply   = 2
+	filterAccept = 0xffff // bytes of an accepted frame passed to the socket, which covers all of an ARP frame
+)
+
+// maxFilterMACs is the most target MACs matched by an ARP socket's filter. Each adds five instructions to the
+// program, which the kernel runs for every frame on the wire, so larger sets are matched by the reader alone.
+const maxFilterMACs = 256
+
+// arpFilter assembles a classic BPF program for an ARP socket that only passes replies, or every ARP packet if
+// announcements is set, from a sender in targets. Every sender is passed if targets is empty, larger than
+// maxFilterMACs or holds addresses other than 48-bit MACs.
+func arpFilter(targets macSet, announcements bool) ([]bpf.RawInstruction, error) {
+	prog := []bpf.Instruction{
+		bpf.LoadAbsolute{Off: frameEtherType, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: etherTypeARP, SkipTrue: 1},
+		bpf.RetConstant{Val: 0},
+	}
+
+	if !announcements {
+		prog = append(prog,
+			bpf.LoadAbsolute{Off: frameARPOp, Size: 2},
+			bpf.JumpIf{Cond: bpf.JumpEqual, Val: arpOpReply, SkipTrue: 1},
+			bpf.RetConstant{Val: 0},
+		)
+	}
+
+	if len(targets) == 0 || len(targets) > maxFilterMACs {
+		return bpf.Assemble(append(prog, bpf.RetConstant{Val: filterAccept}))
+	}
+	for _, mac := range targets {
+		if len(mac) != 6 {
+			return bpf.Assemble(append(prog, bpf.RetConstant{Val: filterAccept}))
+		}
+	}
+
+	// Targets are sorted so that the same set always gives the same program
+	keys := make([]string, 0, len(targets))
+	for key := range targets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		mac := targets[key]
+
+		// Each block passes the frame if its sender matches mac, and otherwise skips to the next block
+		prog = append(prog,
+			bpf.LoadAbsolute{Off: frameARPSender, Size: 4},
+			bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: binary.BigEndian.Uint32(mac[:4]), SkipTrue: 3},
+			bpf.LoadAbsolute{Off: frameARPSender + 4, Size: 2},
+			bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: uint32(binary.BigEndian.Uint16(mac[4:])), SkipTrue: 1},
+			bpf.RetConstant{Val: filterAccept},
+		)
+	}
+
+	return bpf.Assemble(append(prog, bpf.RetConstant{Val: 0}))
+}
+
+// filterable is a socket a BPF program can be attached to, which includes both sockets opened by the provider and
+// those passed from the privileged helper.
+type filterable interface {
+	SetBPF([]bpf.RawInstruction) error
+}
+
+// attachARPFilter attaches arpFilter to conn so that the kernel drops frames the client would ignore, reporting
+// whether it was attached. Nothing relies on the filter, so when it can't be attached, such as on kernels without
+// socket filters or to sockets from the privileged helper, whose filter is locked, the socket is read with any filter
+// it already has and frames are matched by the reader alone.
+func attachARPFilter(conn net.PacketConn, targets macSet, announcements bool) bool {
+	socket, ok := conn.(filterable)
+	if !ok {
+		return false
+	}
+
+	prog, err := arpFilter(targets, announcements)
+	if err != nil {
+		return false
+	}
+
+	return socket.SetBPF(prog) == nil
+}
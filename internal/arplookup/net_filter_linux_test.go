@@ -0,0 +1,99 @@
+package arplookup
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"golang.org/x/net/bpf"
+)
+
+// arpFrame builds an ethernet frame holding an ARP packet with the given operation, sent by sender.
+func arpFrame(etherType uint16, op uint16, sender net.HardwareAddr) []byte {
+	frame := make([]byte, 42)
+	copy(frame[0:6], net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	copy(frame[6:12], sender)
+	binary.BigEndian.PutUint16(frame[12:14], etherType)
+	binary.BigEndian.PutUint16(frame[14:16], 1)      // ethernet hardware
+	binary.BigEndian.PutUint16(frame[16:18], 0x0800) // IPv4 protocol
+	frame[18], frame[19] = 6, 4
+	binary.BigEndian.PutUint16(frame[20:22], op)
+	copy(frame[22:28], sender)
+	copy(frame[28:32], net.IP{192, 168, 33, 20}.To4())
+	copy(frame[38:42], net.IP{192, 168, 33, 1}.To4())
+
+	return frame
+}
+
+// TestARPFilter checks whether the filter attached to ARP sockets passes replies from targets, and requests too
+// when listening passively, while dropping everything else.
+func TestARPFilter(t *testing.T) {
+	target := net.HardwareAddr{0x3e, 0x50, 0x6e, 0x54, 0x28, 0x01}
+	other := net.HardwareAddr{0x3e, 0x50, 0x6e, 0x54, 0x28, 0x02}
+	// Shares its first four bytes with target, so only the last two tell them apart
+	near := net.HardwareAddr{0x3e, 0x50, 0x6e, 0x54, 0x29, 0x01}
+
+	tooMany := macSet{}
+	for i := 0; i <= maxFilterMACs; i++ {
+		mac := net.HardwareAddr{0x3e, 0x50, 0x6e, 0x55, byte(i >> 8), byte(i)}
+		tooMany[mac.String()] = mac
+	}
+
+	testcases := []struct {
+		name          string
+		targets       macSet
+		announcements bool
+		frame         []byte
+		pass          bool
+	}{
+		{name: "reply from target", targets: mkMACSet(target, other), frame: arpFrame(0x0806, 2, target), pass: true},
+		{name: "reply from other target", targets: mkMACSet(target, other), frame: arpFrame(0x0806, 2, other), pass: true},
+		{name: "reply from non-target", targets: mkMACSet(target), frame: arpFrame(0x0806, 2, other)},
+		{name: "reply from near target", targets: mkMACSet(target), frame: arpFrame(0x0806, 2, near)},
+		{name: "request from target", targets: mkMACSet(target), frame: arpFrame(0x0806, 1, target)},
+		{name: "announcement from target", targets: mkMACSet(target), announcements: true, frame: arpFrame(0x0806, 1, target), pass: true},
+		{name: "announcement from non-target", targets: mkMACSet(target), announcements: true, frame: arpFrame(0x0806, 1, other)},
+		{name: "reply when discovering", frame: arpFrame(0x0806, 2, other), pass: true},
+		{name: "request when discovering", frame: arpFrame(0x0806, 1, other)},
+		{name: "reply with too many targets", targets: tooMany, frame: arpFrame(0x0806, 2, other), pass: true},
+		{name: "not ARP", frame: arpFrame(0x0800, 2, target)},
+	}
+
+	for _, test := range testcases {
+		t.Run(test.name, func(t *testing.T) {
+			raw, err := arpFilter(test.targets, test.announcements)
+			if err != nil {
+				t.Fatalf("error encountered while assembling filter: %s", err.Error())
+			}
+
+			prog, ok := bpf.Disassemble(raw)
+			if !ok {
+				t.Fatalf("unable to disassemble filter")
+			}
+			vm, err := bpf.NewVM(prog)
+			if err != nil {
+				t.Fatalf("error encountered while loading filter: %s", err.Error())
+			}
+
+			n, err := vm.Run(test.frame)
+			if err != nil {
+				t.Fatalf("error encountered while running filter: %s", err.Error())
+			}
+			if pass := n > 0; pass != test.pass {
+				t.Fatalf("expected frame to pass: %t, got: %t", test.pass, pass)
+			}
+		})
+	}
+}
+
+// unfilterableConn is a socket that a filter can't be attached to.
+type unfilterableConn struct {
+	net.PacketConn
+}
+
+// TestAttachARPFilterFallback checks whether sockets that a filter can't be attached to are used unfiltered.
+func TestAttachARPFilterFallback(t *testing.T) {
+	if attachARPFilter(unfilterableConn{}, macSet{}, false) {
+		t.Fatalf("expected filter not to be attached")
+	}
+}
@@ -1,7 +1,11 @@
 package arplookup
 
 import (
+	"fmt"
+	"net"
 	"net/netip"
+	"strings"
+	"time"
 
 	"inet.af/netaddr"
 )
@@ -24,16 +28,34 @@ func fromNetaddr(ip netaddr.IP) netip.Addr {
 	return netip.AddrFrom16(ip.As16())
 }
 
-// isValidHost checks whether the host is not an IPv4 broadcast or unspecified address.
+// isValidHost checks whether the host is not an IPv4 broadcast or unspecified address, or an IPv6 address that
+// cannot be resolved with neighbor discovery.
 func isValidHost(ip netaddr.IP) bool {
 	if ip.Is4() {
 		bytes := ip.As4()
 		return !ip.IsLoopback() && !ip.IsMulticast() && !ip.IsUnspecified() && ip.IsPrivate() && bytes[3] != 0xff && bytes[3] != 0x00
 	}
+	if ip.Is6() {
+		return !ip.IsLoopback() && !ip.IsMulticast() && !ip.IsUnspecified() && (ip.IsGlobalUnicast() || ip.IsLinkLocalUnicast())
+	}
 	return false
 }
 
-// mkIPSet builds an IP set from a set of subnets in CIDR prefix notation.
+// families reports whether an IP set contains IPv4 and IPv6 addresses.
+func families(set *netaddr.IPSet) (v4 bool, v6 bool) {
+	for _, r := range set.Ranges() {
+		if r.From().Is4() {
+			v4 = true
+		} else {
+			v6 = true
+		}
+	}
+
+	return
+}
+
+// mkIPSet builds an IP set from a set of subnets in CIDR prefix notation. IPv6 prefixes shorter than
+// minIPv6PrefixBits are rejected, for networks only known once the data source is read.
 func mkIPSet(networks []string) (*netaddr.IPSet, error) {
 	var ips netaddr.IPSetBuilder
 
@@ -42,9 +64,142 @@ func mkIPSet(networks []string) (*netaddr.IPSet, error) {
 		if err != nil {
 			return nil, err
 		}
+		if ip.IP().Is6() && ip.Bits() < minIPv6PrefixBits {
+			return nil, fmt.Errorf("IPv6 prefix %s is too large: must be /%d or longer", prefix, minIPv6PrefixBits)
+		}
 
 		ips.AddPrefix(ip)
 	}
 
 	return ips.IPSet()
 }
+
+// neighState is a set of kernel neighbour table states, using the kernel's NUD_* bit values.
+type neighState uint16
+
+const (
+	neighIncomplete neighState = 0x01
+	neighReachable  neighState = 0x02
+	neighStale      neighState = 0x04
+	neighDelay      neighState = 0x08
+	neighProbe      neighState = 0x10
+	neighFailed     neighState = 0x20
+	neighNoARP      neighState = 0x40
+	neighPermanent  neighState = 0x80
+)
+
+// defaultNeighStates are the neighbour states accepted when none are specified: any entry with a usable MAC.
+const defaultNeighStates = neighReachable | neighStale | neighDelay | neighProbe | neighNoARP | neighPermanent
+
+// neighStateNames maps the names accepted by the `accept_states` attribute to their states.
+var neighStateNames = map[string]neighState{
+	"incomplete": neighIncomplete,
+	"reachable":  neighReachable,
+	"stale":      neighStale,
+	"delay":      neighDelay,
+	"probe":      neighProbe,
+	"failed":     neighFailed,
+	"noarp":      neighNoARP,
+	"permanent":  neighPermanent,
+}
+
+// cacheStateNames maps the names accepted by the `cache_state` attribute to their states, which are the states
+// that an entry can be added to the neighbour table in with a known MAC.
+var cacheStateNames = map[string]neighState{
+	"reachable": neighReachable,
+	"stale":     neighStale,
+	"permanent": neighPermanent,
+}
+
+// parseNeighStates builds a set of neighbour states from their names.
+func parseNeighStates(names []string) (neighState, error) {
+	var states neighState
+	for _, name := range names {
+		state, ok := neighStateNames[strings.ToLower(name)]
+		if !ok {
+			return 0, fmt.Errorf("unknown neighbour state \"%s\"", name)
+		}
+		states |= state
+	}
+
+	return states, nil
+}
+
+// accepts checks whether state is in the set. An empty set accepts the default states.
+func (s neighState) accepts(state neighState) bool {
+	if s == 0 {
+		s = defaultNeighStates
+	}
+
+	return s&state != 0
+}
+
+// orDefault returns d, or fallback if d is unset.
+func orDefault(d time.Duration, fallback time.Duration) time.Duration {
+	if d <= 0 {
+		return fallback
+	}
+
+	return d
+}
+
+// interfacePrefixes returns the addresses assigned to iface along with their prefixes.
+func interfacePrefixes(iface *net.Interface) ([]netaddr.IPPrefix, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	prefixes := make([]netaddr.IPPrefix, 0, len(addrs))
+	for _, addr := range addrs {
+		prefix, err := netaddr.ParseIPPrefix(addr.String())
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	return prefixes, nil
+}
+
+// selectSourceIP chooses which of an interface's IPv4 addresses to send ARP requests from. want is used if set,
+// provided that it is assigned to the interface. Otherwise the address whose prefix overlaps network is preferred,
+// falling back to the first IPv4 address of the interface.
+func selectSourceIP(prefixes []netaddr.IPPrefix, want netaddr.IP, network *netaddr.IPSet) (netaddr.IP, error) {
+	v4 := []netaddr.IPPrefix{}
+	for _, prefix := range prefixes {
+		if prefix.IP().Is4() {
+			v4 = append(v4, prefix)
+		}
+	}
+
+	if !want.IsZero() {
+		for _, prefix := range v4 {
+			if prefix.IP() == want {
+				return want, nil
+			}
+		}
+
+		return netaddr.IP{}, &LookupError{
+			Kind:   ErrNoSourceAddress,
+			Detail: fmt.Sprintf("source IP %s is not assigned to the selected network interface", want.String()),
+		}
+	}
+
+	if len(v4) == 0 {
+		return netaddr.IP{}, &LookupError{
+			Kind:   ErrNoSourceAddress,
+			Detail: "selected network interface has no assigned IPv4 addresses",
+		}
+	}
+
+	if network != nil {
+		for _, prefix := range v4 {
+			if network.OverlapsPrefix(prefix.Masked()) {
+				return prefix.IP(), nil
+			}
+		}
+	}
+
+	return v4[0].IP(), nil
+}
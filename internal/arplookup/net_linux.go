@@ -1,31 +1,51 @@
 package arplookup
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"net"
-	"os"
-	"reflect"
-	"strings"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/go-ping/ping"
 	"github.com/mdlayher/arp"
+	"github.com/mdlayher/packet"
+	"github.com/vishvananda/netlink"
 	"inet.af/netaddr"
 	"kernel.org/pub/linux/libs/security/libcap/cap"
+	"terraform-provider-arplookup/internal/privhelper"
 )
 
 type linuxARP struct {
-	dstMAC   net.HardwareAddr
-	srcIP    netaddr.IP
-	client   *arp.Client
-	dropCaps (func() error)
+	targets macSet
+	accept  neighState // neighbour table states to accept
+	// how long to wait for a reply to each request, or defaultRequestTimeout if zero
+	requestTimeout time.Duration
+	sourceIP       netaddr.IP     // address to send requests from, or chosen from the interface if zero
+	network        *netaddr.IPSet // range being searched, used to choose the address to send requests from
+	skipCache      bool           // don't add found hosts to the neighbour table
+	cacheState     neighState     // state found hosts are added to the neighbour table in
+	iface          *net.Interface
+	srcIP          netaddr.IP
+	transport      string             // how packets are exchanged with the kernel, transportSocket if empty
+	conn           arpConn            // sends requests and reads replies
+	release        func()             // releases the capabilities acquired by init
+	helper         *privhelper.Client // opens the ARP socket if set, so no capabilities are needed
+	// read requests as well as replies, so that hosts announcing themselves are seen when listening passively
+	passive  bool
+	filtered bool // whether the kernel drops frames the reader would ignore
+
+	replies       replyDemux
+	announcements replyDemux // every packet from a target, for passive listening
+	readOnce      sync.Once
+	readDone      chan struct{}
+	readErr       error
 }
 
-func mkLinuxARP(dstMAC net.HardwareAddr) *linuxARP {
+func mkLinuxARP(dstMACs ...net.HardwareAddr) *linuxARP {
 	return &linuxARP{
-		dstMAC: dstMAC,
+		targets: mkMACSet(dstMACs...),
 	}
 }
 
@@ -44,162 +64,296 @@ func isTimeout(err error) bool {
 }
 
 func (ac *linuxARP) cache(current IP) error {
-	if current.cached {
+	if ac.skipCache {
 		return nil
 	}
 
-	pinger, err := ping.NewPinger(current.String())
+	return warmNeighbour(ac.iface, current, ac.cacheState)
+}
+
+// try implements arpClient for linuxARP, reading the kernel's IPv4 neighbour table.
+func (ac *linuxARP) try(chans channels) {
+	tryNeighbours(chans, ac.iface, netlink.FAMILY_V4, ac.targets, ac.accept)
+}
+
+// resolve finds the MAC of the host at ip on the interface the client was initialised with. The kernel's ARP
+// table is checked first, and otherwise a broadcast ARP request is sent every backoff period until ctx expires or
+// maxAttempts requests have been sent, if set. Replies are read by the client's reader, so that it may be shared.
+func (ac *linuxARP) resolve(ctx context.Context, ip netaddr.IP, backoff time.Duration, maxAttempts int) (net.HardwareAddr, error) {
+	if err := ac.startReader(); err != nil {
+		return nil, err
+	}
+
+	pkt, dst, err := ac.packet(ip)
 	if err != nil {
-		return fmt.Errorf("failure creating new `pinger`: %w", err)
+		return nil, err
 	}
 
-	uid := syscall.Getuid()
-	if uid == 0 {
-		pinger.SetPrivileged(true)
+	for attempt := 1; ; attempt++ {
+		entries, err := readNeighbours(ac.iface, netlink.FAMILY_V4)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if entry.ip == ip && ac.accept.accepts(entry.state) {
+				return entry.mac, nil
+			}
+		}
+
+		reply, err := ac.replies.await(ctx, ip, backoff, func() error {
+			return ac.conn.writeTo(pkt, dst)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !reply.IP.IsZero() {
+			return reply.mac, nil
+		}
+
+		if attempt == maxAttempts {
+			return nil, errNoMAC
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, expired(ctx, errNoMAC)
+		default:
+		}
 	}
+}
+
+// startReader starts the goroutine reading replies off the client's socket if it is not already running, and
+// reports whether it has since stopped.
+func (ac *linuxARP) startReader() error {
+	ac.readOnce.Do(func() { go ac.read() })
 
-	pinger.Count = 1
-	if err := pinger.Run(); err != nil {
-		return fmt.Errorf("failure adding IP %s to cache: %w", current.String(), err)
+	select {
+	case <-ac.readDone:
+		return fmt.Errorf("unable to read ARP replies: %w", ac.readErr)
+	default:
 	}
 
 	return nil
 }
 
-func (ac *linuxARP) try(chans channels) {
-	arp, err := os.Open("/proc/net/arp")
+// packet creates an ARP request for current, addressed to the desired MAC.
+func (ac *linuxARP) packet(current netaddr.IP) (*arp.Packet, net.HardwareAddr, error) {
+	dst := ac.targets.target()
+	pkt, err := arp.NewPacket(
+		arp.OperationRequest,
+		ac.iface.HardwareAddr,
+		fromNetaddr(ac.srcIP),
+		dst,
+		fromNetaddr(current))
+
+	return pkt, dst, err
+}
+
+func (ac *linuxARP) request(ctx context.Context, current netaddr.IP) (IP, error) {
+	if err := ac.startReader(); err != nil {
+		return IP{}, err
+	}
+
+	pkt, dst, err := ac.packet(current)
 	if err != nil {
-		chans.errors <- err
-		return
+		return IP{}, err
 	}
-	defer arp.Close()
 
-	// proc arp table has the MAC on field 3 and IP on field 0
-	scanner := bufio.NewScanner(arp)
-	for scanner.Scan() {
-		text := scanner.Text()
-		fields := strings.Fields(text)
+	timeout := orDefault(ac.requestTimeout, defaultRequestTimeout)
+	if ac.transport == transportRing && timeout < ringRequestTimeout {
+		timeout = ringRequestTimeout
+	}
 
-		ip := fields[0]
-		mac := fields[3]
+	return ac.replies.await(ctx, current, timeout, func() error {
+		return ac.conn.writeTo(pkt, dst)
+	})
+}
 
-		if strings.EqualFold(mac, ac.dstMAC.String()) {
-			select {
-			case <-chans.stop:
-				return
-			default:
-			}
+// send implements asyncClient for linuxARP.
+func (ac *linuxARP) send(current netaddr.IP) error {
+	if err := ac.startReader(); err != nil {
+		return err
+	}
 
-			ip, err := netaddr.ParseIP(ip)
-			if err != nil {
-				chans.errors <- fmt.Errorf("line: \"%s\" error %w", text, err)
-				return
-			}
+	pkt, dst, err := ac.packet(current)
+	if err != nil {
+		return err
+	}
 
-			chans.results <- IP{cached: true, IP: ip}
-			return
-		}
+	return ac.conn.writeTo(pkt, dst)
+}
+
+// watch implements passiveClient for linuxARP. Only packets the interface receives are seen, which includes
+// broadcast requests and gratuitous announcements but not replies unicast to other hosts.
+func (ac *linuxARP) watch(out chan<- IP) (func(), error) {
+	if err := ac.startReader(); err != nil {
+		return nil, err
 	}
+
+	return ac.announcements.subscribe(out), nil
 }
 
-func (ac *linuxARP) request(current netaddr.IP) (IP, error) {
-	deadline := time.Now().Add(arpRequestDeadline)
-	ac.client.SetReadDeadline(deadline)
+// listen implements asyncClient for linuxARP.
+func (ac *linuxARP) listen(out chan<- IP) (func(), error) {
+	if err := ac.startReader(); err != nil {
+		return nil, err
+	}
 
-	for {
-		// Create ARP request addressed to desired MAC and IP
-		pkt, err := arp.NewPacket(
-			arp.OperationRequest,
-			ac.client.HardwareAddr(),
-			fromNetaddr(ac.srcIP),
-			ac.dstMAC,
-			fromNetaddr(current))
-		if err != nil {
-			return IP{}, err
-		}
-		if err = ac.client.WriteTo(pkt, ac.dstMAC); err != nil {
-			return IP{}, err
-		}
+	return ac.replies.subscribe(out), nil
+}
+
+// read is the single reader of the client's socket, so that concurrent requests can share it. Replies are handed
+// to the request waiting on their sender IP. It returns once the socket is closed by destroy, or reading it fails.
+func (ac *linuxARP) read() {
+	defer close(ac.readDone)
 
-		// Read the client's socket for a response, and if we time out, break the loop and return a nil IP
-		pkt, _, err = ac.client.Read()
+	for {
+		pkt, err := ac.conn.read()
 		if isTimeout(err) {
-			return IP{}, nil
+			continue
 		}
 		if err != nil {
-			return IP{}, err
+			ac.readErr = err
+			return
 		}
 
-		// If we don't recieve a response from the desired MAC or a reply, continue
-		if pkt.Operation != arp.OperationReply || !reflect.DeepEqual(pkt.SenderHardwareAddr, ac.dstMAC) {
+		// If we don't recieve a packet from a desired MAC, continue
+		if !ac.targets.matches(pkt.SenderHardwareAddr) {
 			continue
 		}
 
-		return IP{cached: false, IP: toNetaddr(pkt.SenderIP)}, nil
+		sender := IP{cached: false, mac: pkt.SenderHardwareAddr, IP: toNetaddr(pkt.SenderIP)}
+
+		// ARP probes sent while a host checks for address conflicts have no sender IP yet
+		if !sender.IsUnspecified() {
+			ac.announcements.deliver(sender)
+		}
+
+		if pkt.Operation == arp.OperationReply {
+			ac.replies.deliver(sender)
+		}
+	}
+}
+
+// failed implements failingClient for linuxARP.
+func (ac *linuxARP) failed() bool {
+	select {
+	case <-ac.readDone:
+		return true
+	default:
+		return false
 	}
 }
 
 func (ac *linuxARP) initClient(iface *net.Interface) error {
-	client, err := arp.Dial(iface)
-	if err != nil {
+	if err := checkInterfaceUp(iface); err != nil {
 		return err
 	}
 
-	ac.client = client
-
-	addrs, err := iface.Addrs()
+	prefixes, err := interfacePrefixes(iface)
 	if err != nil {
-		return err
+		return &LookupError{Kind: ErrNoSourceAddress, Detail: "unable to read addresses of " + iface.Name, Err: err}
 	}
 
-	if len(addrs) <= 0 {
-		return fmt.Errorf("selected network interface has no assigned addresses")
+	srcIP, err := selectSourceIP(prefixes, ac.sourceIP, ac.network)
+	if err != nil {
+		return err
 	}
 
-	ipaddr, err := netaddr.ParseIPPrefix(addrs[0].String())
+	conn, err := ac.dial(iface)
 	if err != nil {
-		return err
+		return socketError("unable to open ARP socket on "+iface.Name, err)
 	}
 
-	ac.srcIP = ipaddr.IP()
+	ac.conn = conn
+	ac.iface = iface
+	ac.srcIP = srcIP
+	ac.readDone = make(chan struct{})
 
 	return nil
 }
 
-// linuxGetCaps provides the process with the correct capabilities needed to perform raw socket operations.
-func linuxGetCaps() (func() error, error) {
-	orig := cap.GetProc()
-	drop := orig.SetProc // on exit drop capabilities
-
-	caps, err := orig.Dup()
+// dial opens an ARP socket on iface, through the privileged helper if one is running, and wraps it in the transport
+// selected. A socket filter is attached so that the reader is only woken for frames it is interested in.
+func (ac *linuxARP) dial(iface *net.Interface) (arpConn, error) {
+	var conn net.PacketConn
+	var err error
+	if ac.helper == nil {
+		conn, err = packet.Listen(iface, packet.Raw, etherTypeARP, nil)
+	} else {
+		conn, err = ac.helper.ListenPacket(iface, privhelper.TypeRaw, privhelper.ProtocolARP)
+	}
 	if err != nil {
-		return drop, fmt.Errorf("failed to duplicate process capabilities: %w", err)
+		return nil, err
 	}
 
-	if ok, _ := caps.GetFlag(cap.Permitted, cap.NET_RAW); !ok {
-		return drop, fmt.Errorf("insufficient privilege to bind to a raw socket - want %q, have %q", cap.NET_RAW, caps)
+	ac.filtered = attachARPFilter(conn, ac.targets, ac.passive)
+
+	if ac.transport == transportRing {
+		ring, err := mkRingConn(conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return ring, nil
 	}
 
-	if err := caps.SetFlag(cap.Effective, true, cap.NET_RAW); err != nil {
-		return drop, fmt.Errorf("unable to set capability: %v", err)
+	client, err := arp.New(iface, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
 	}
 
-	if err := caps.SetProc(); err != nil {
-		return drop, fmt.Errorf("unable to raise capabilities %q: %v", caps, err)
+	return socketConn{client: client}, nil
+}
+
+// arpConn exchanges ARP packets with the kernel on behalf of linuxARP.
+type arpConn interface {
+	// read returns the next ARP packet received, skipping frames of other protocols
+	read() (*arp.Packet, error)
+	// writeTo sends pkt in an ethernet frame addressed to dst
+	writeTo(pkt *arp.Packet, dst net.HardwareAddr) error
+	close() error
+}
+
+// socketConn is an arpConn making a system call for each packet sent or received.
+type socketConn struct {
+	client *arp.Client
+}
+
+func (c socketConn) read() (*arp.Packet, error) {
+	for {
+		pkt, _, err := c.client.Read()
+
+		// Errors reading the socket are all *net.OpError, and anything else is a frame that couldn't be parsed,
+		// which is skipped so that one malformed frame doesn't stop the reader
+		var opErr *net.OpError
+		if err != nil && !errors.As(err, &opErr) {
+			continue
+		}
+
+		return pkt, err
 	}
+}
 
-	return drop, nil
+func (c socketConn) writeTo(pkt *arp.Packet, dst net.HardwareAddr) error {
+	return c.client.WriteTo(pkt, dst)
+}
+
+func (c socketConn) close() error {
+	return c.client.Close()
 }
 
 func (ac *linuxARP) init(iface *net.Interface) error {
-	// Not needed if running as Root
-	uid := syscall.Getuid()
-	if uid == 0 {
+	// The helper holds the capabilities needed for the ARP socket
+	if ac.helper != nil {
 		return ac.initClient(iface)
 	}
 
-	drop, err := linuxGetCaps()
-	ac.dropCaps = drop
+	release, err := privileges.acquire(cap.NET_RAW)
+	ac.release = release
 	if err != nil {
 		return err
 	}
@@ -208,10 +362,35 @@ func (ac *linuxARP) init(iface *net.Interface) error {
 }
 
 func (ac *linuxARP) destroy() error {
-	uid := syscall.Getuid()
-	if uid != 0 {
-		ac.dropCaps()
+	if ac.conn != nil {
+		ac.conn.close()
+	}
+
+	if ac.release != nil {
+		ac.release()
+	}
+
+	return nil
+}
+
+// checkInterfaceUp returns an error of kind ErrInterfaceDown if iface is not up.
+func checkInterfaceUp(iface *net.Interface) error {
+	if iface == nil {
+		return &LookupError{Kind: ErrInterfaceDown, Detail: "no network interface selected"}
+	}
+
+	if iface.Flags&net.FlagUp == 0 {
+		return &LookupError{Kind: ErrInterfaceDown, Detail: fmt.Sprintf("network interface %s is down", iface.Name)}
 	}
 
 	return nil
 }
+
+// socketError wraps an error opening a raw socket, reporting a PermissionError if the kernel refused it.
+func socketError(detail string, err error) error {
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) {
+		return &PermissionError{Capabilities: []string{cap.NET_RAW.String()}, Err: err}
+	}
+
+	return &LookupError{Kind: ErrSocket, Detail: detail, Err: err}
+}
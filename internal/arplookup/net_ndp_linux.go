@@ -0,0 +1,251 @@
+package arplookup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/ndp"
+	"github.com/vishvananda/netlink"
+	"inet.af/netaddr"
+	"kernel.org/pub/linux/libs/security/libcap/cap"
+)
+
+// linuxNDP is an arpClient that resolves IPv6 hosts using ICMPv6 Neighbor Discovery.
+type linuxNDP struct {
+	targets macSet
+	accept  neighState // neighbour table states to accept
+	// how long to wait for a reply to each request, or defaultRequestTimeout if zero
+	requestTimeout time.Duration
+	skipCache      bool       // don't add found hosts to the neighbour table
+	cacheState     neighState // state found hosts are added to the neighbour table in
+	iface          *net.Interface
+	conn           *ndp.Conn
+	release        func() // releases the capabilities acquired by init
+
+	replies       replyDemux
+	announcements replyDemux // every packet from a target, for passive listening
+	readOnce      sync.Once
+	readDone      chan struct{}
+	readErr       error
+}
+
+func mkLinuxNDP(dstMACs ...net.HardwareAddr) *linuxNDP {
+	return &linuxNDP{
+		targets: mkMACSet(dstMACs...),
+	}
+}
+
+func (ac *linuxNDP) cache(current IP) error {
+	if ac.skipCache {
+		return nil
+	}
+
+	return warmNeighbour(ac.iface, current, ac.cacheState)
+}
+
+// try implements arpClient for linuxNDP. IPv6 neighbours are not exposed through procfs, so the kernel's
+// neighbour table is read over netlink instead.
+func (ac *linuxNDP) try(chans channels) {
+	tryNeighbours(chans, ac.iface, netlink.FAMILY_V6, ac.targets, ac.accept)
+}
+
+// startReader starts the goroutine reading advertisements off the NDP connection if it is not already running, and
+// reports whether it has since stopped.
+func (ac *linuxNDP) startReader() error {
+	ac.readOnce.Do(func() { go ac.read() })
+
+	select {
+	case <-ac.readDone:
+		return fmt.Errorf("unable to read neighbor advertisements: %w", ac.readErr)
+	default:
+	}
+
+	return nil
+}
+
+// solicitation creates a Neighbor Solicitation for current, addressed to its solicited-node multicast group.
+func (ac *linuxNDP) solicitation(current netaddr.IP) (*ndp.NeighborSolicitation, net.IP, error) {
+	target := current.IPAddr().IP
+
+	snm, err := ndp.SolicitedNodeMulticast(target)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	msg := &ndp.NeighborSolicitation{
+		TargetAddress: target,
+		Options: []ndp.Option{
+			&ndp.LinkLayerAddress{
+				Direction: ndp.Source,
+				Addr:      ac.iface.HardwareAddr,
+			},
+		},
+	}
+
+	return msg, snm, nil
+}
+
+func (ac *linuxNDP) request(ctx context.Context, current netaddr.IP) (IP, error) {
+	msg, snm, err := ac.solicitation(current)
+	if err != nil {
+		return IP{}, err
+	}
+
+	if err := ac.startReader(); err != nil {
+		return IP{}, err
+	}
+
+	return ac.replies.await(ctx, current, orDefault(ac.requestTimeout, defaultRequestTimeout), func() error {
+		return ac.conn.WriteTo(msg, nil, snm)
+	})
+}
+
+// send implements asyncClient for linuxNDP.
+func (ac *linuxNDP) send(current netaddr.IP) error {
+	msg, snm, err := ac.solicitation(current)
+	if err != nil {
+		return err
+	}
+
+	if err := ac.startReader(); err != nil {
+		return err
+	}
+
+	return ac.conn.WriteTo(msg, nil, snm)
+}
+
+// watch implements passiveClient for linuxNDP. Hosts are heard through their solicitations and unsolicited
+// advertisements.
+func (ac *linuxNDP) watch(out chan<- IP) (func(), error) {
+	if err := ac.startReader(); err != nil {
+		return nil, err
+	}
+
+	return ac.announcements.subscribe(out), nil
+}
+
+// listen implements asyncClient for linuxNDP.
+func (ac *linuxNDP) listen(out chan<- IP) (func(), error) {
+	if err := ac.startReader(); err != nil {
+		return nil, err
+	}
+
+	return ac.replies.subscribe(out), nil
+}
+
+// read is the single reader of the NDP connection, so that concurrent requests can share it. Advertisements are
+// handed to the request waiting on their target address. It returns once the connection is closed by destroy, or
+// reading it fails.
+func (ac *linuxNDP) read() {
+	defer close(ac.readDone)
+
+	for {
+		msg, _, from, err := ac.conn.ReadFrom()
+		if isTimeout(err) {
+			continue
+		}
+
+		// Errors reading the connection are all *net.OpError, and anything else is a message that couldn't be
+		// parsed, which is skipped
+		var opErr *net.OpError
+		if err != nil && !errors.As(err, &opErr) {
+			continue
+		}
+		if err != nil {
+			ac.readErr = err
+			return
+		}
+
+		// Solicitations carry the sender's MAC in the source link-layer address option
+		if ns, ok := msg.(*ndp.NeighborSolicitation); ok {
+			sender, ok := netaddr.FromStdIP(from)
+			if !ok || sender.IsUnspecified() {
+				continue
+			}
+
+			for _, opt := range ns.Options {
+				lla, ok := opt.(*ndp.LinkLayerAddress)
+				if ok && lla.Direction == ndp.Source && ac.targets.matches(lla.Addr) {
+					ac.announcements.deliver(IP{cached: false, mac: lla.Addr, IP: sender})
+				}
+			}
+			continue
+		}
+
+		na, ok := msg.(*ndp.NeighborAdvertisement)
+		if !ok {
+			continue
+		}
+
+		target, ok := netaddr.FromStdIP(na.TargetAddress)
+		if !ok {
+			continue
+		}
+
+		// The advertiser's MAC is carried in the target link-layer address option
+		for _, opt := range na.Options {
+			lla, ok := opt.(*ndp.LinkLayerAddress)
+			if !ok || lla.Direction != ndp.Target {
+				continue
+			}
+
+			if ac.targets.matches(lla.Addr) {
+				ac.announcements.deliver(IP{cached: false, mac: lla.Addr, IP: target})
+				ac.replies.deliver(IP{cached: false, mac: lla.Addr, IP: target})
+			}
+		}
+	}
+}
+
+// failed implements failingClient for linuxNDP.
+func (ac *linuxNDP) failed() bool {
+	select {
+	case <-ac.readDone:
+		return true
+	default:
+		return false
+	}
+}
+
+func (ac *linuxNDP) initClient(iface *net.Interface) error {
+	if err := checkInterfaceUp(iface); err != nil {
+		return err
+	}
+
+	conn, _, err := ndp.Dial(iface, ndp.LinkLocal)
+	if err != nil {
+		return socketError("unable to open NDP connection on "+iface.Name, err)
+	}
+
+	ac.iface = iface
+	ac.conn = conn
+	ac.readDone = make(chan struct{})
+
+	return nil
+}
+
+func (ac *linuxNDP) init(iface *net.Interface) error {
+	release, err := privileges.acquire(cap.NET_RAW)
+	ac.release = release
+	if err != nil {
+		return err
+	}
+
+	return ac.initClient(iface)
+}
+
+func (ac *linuxNDP) destroy() error {
+	if ac.conn != nil {
+		ac.conn.Close()
+	}
+
+	if ac.release != nil {
+		ac.release()
+	}
+
+	return nil
+}
@@ -0,0 +1,260 @@
+package arplookup
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"inet.af/netaddr"
+	"kernel.org/pub/linux/libs/security/libcap/cap"
+)
+
+// Flags used by the kernel for entries in /proc/net/arp.
+const (
+	procARPComplete  = 0x02 // ATF_COM
+	procARPPermanent = 0x04 // ATF_PERM
+)
+
+// neighEntry is a single entry of the kernel's neighbour table.
+type neighEntry struct {
+	ip     netaddr.IP
+	mac    net.HardwareAddr
+	device string
+	state  neighState
+}
+
+// readNeighbours dumps the kernel's neighbour table for a single interface and address family over netlink. If
+// netlink is unavailable the IPv4 table is read from /proc/net/arp instead.
+func readNeighbours(iface *net.Interface, family int) ([]neighEntry, error) {
+	neighs, err := netlink.NeighList(iface.Index, family)
+	if err != nil {
+		if family != netlink.FAMILY_V4 {
+			return nil, fmt.Errorf("unable to list neighbours: %w", err)
+		}
+
+		entries, procErr := readProcARP()
+		if procErr != nil {
+			return nil, fmt.Errorf("unable to list neighbours: %v, and unable to read ARP table: %w", err, procErr)
+		}
+
+		onIface := []neighEntry{}
+		for _, entry := range entries {
+			if entry.device == iface.Name {
+				onIface = append(onIface, entry)
+			}
+		}
+
+		return onIface, nil
+	}
+
+	entries := make([]neighEntry, 0, len(neighs))
+	for _, neigh := range neighs {
+		ip, ok := netaddr.FromStdIP(neigh.IP)
+		if !ok || len(neigh.HardwareAddr) == 0 {
+			continue
+		}
+
+		entries = append(entries, neighEntry{
+			ip:     ip,
+			mac:    neigh.HardwareAddr,
+			device: iface.Name,
+			state:  neighState(neigh.State),
+		})
+	}
+
+	return entries, nil
+}
+
+// readProcARP reads the kernel's IPv4 ARP table from /proc/net/arp.
+func readProcARP() ([]neighEntry, error) {
+	arp, err := os.Open("/proc/net/arp")
+	if err != nil {
+		return nil, err
+	}
+	defer arp.Close()
+
+	return parseProcARP(arp)
+}
+
+// parseProcARP parses an ARP table in the format of /proc/net/arp, skipping the header and incomplete entries. The
+// table does not expose the state of entries, so complete entries are reported as reachable.
+func parseProcARP(r io.Reader) ([]neighEntry, error) {
+	// proc arp table has the IP on field 0, flags on field 2, MAC on field 3 and device on field 5
+	entries := []neighEntry{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		text := scanner.Text()
+		fields := strings.Fields(text)
+		if len(fields) < 6 {
+			continue
+		}
+
+		flags, err := strconv.ParseUint(fields[2], 0, 16)
+		if err != nil || flags&procARPComplete == 0 {
+			continue
+		}
+
+		mac, err := net.ParseMAC(fields[3])
+		if err != nil {
+			continue
+		}
+
+		ip, err := netaddr.ParseIP(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line: \"%s\" error %w", text, err)
+		}
+
+		state := neighReachable
+		if flags&procARPPermanent != 0 {
+			state = neighPermanent
+		}
+
+		entries = append(entries, neighEntry{ip: ip, mac: mac, device: fields[5], state: state})
+	}
+
+	return entries, scanner.Err()
+}
+
+// tryNeighbours reports entries in the kernel's neighbour table for iface whose MAC is in targets and whose state
+// is accepted. Once every target has been seen it returns, while in discovery mode every entry is reported.
+func tryNeighbours(chans channels, iface *net.Interface, family int, targets macSet, accept neighState) {
+	entries, err := readNeighbours(iface, family)
+	if err != nil {
+		select {
+		case chans.errors <- err:
+		case <-chans.stop:
+		}
+		return
+	}
+
+	seen := macSet{}
+	for _, entry := range entries {
+		if !accept.accepts(entry.state) || !targets.matches(entry.mac) || seen.has(entry.mac) {
+			continue
+		}
+
+		select {
+		case chans.results <- IP{cached: true, mac: entry.mac, IP: entry.ip}:
+		case <-chans.stop:
+			return
+		}
+
+		// When discovering hosts every entry is reported
+		if len(targets) == 0 {
+			continue
+		}
+
+		seen[entry.mac.String()] = entry.mac
+		if len(seen) == len(targets) {
+			return
+		}
+	}
+}
+
+// withNetAdmin runs fn with the capabilities needed to change the kernel's neighbour table.
+func withNetAdmin(fn func() error) error {
+	release, err := privileges.acquire(cap.NET_ADMIN)
+	defer release()
+	if err != nil {
+		return err
+	}
+
+	return fn()
+}
+
+// neighbourFamily returns the netlink address family of ip.
+func neighbourFamily(ip netaddr.IP) int {
+	if ip.Is4() {
+		return netlink.FAMILY_V4
+	}
+
+	return netlink.FAMILY_V6
+}
+
+// setStaticNeighbour adds a permanent entry for ip to the kernel's neighbour table for iface, replacing any
+// existing entry.
+func setStaticNeighbour(iface *net.Interface, ip netaddr.IP, mac net.HardwareAddr) error {
+	neigh := &netlink.Neigh{
+		LinkIndex:    iface.Index,
+		Family:       neighbourFamily(ip),
+		State:        netlink.NUD_PERMANENT,
+		IP:           ip.IPAddr().IP,
+		HardwareAddr: mac,
+	}
+
+	return withNetAdmin(func() error {
+		if err := netlink.NeighSet(neigh); err != nil {
+			return fmt.Errorf("unable to set neighbour entry for %s on %s: %w", ip.String(), iface.Name, err)
+		}
+		return nil
+	})
+}
+
+// warmNeighbour adds the host found by a request to the kernel's neighbour table for iface in the given state, or
+// neighReachable if zero, so that later traffic to it needn't wait on resolution. Hosts that were read from the
+// table to begin with are skipped, as is everything when the provider lacks NET_ADMIN, such as when it only uses the
+// privileged helper, since warming is an optimisation the lookup doesn't depend on.
+func warmNeighbour(iface *net.Interface, current IP, state neighState) error {
+	if current.cached || !privileges.permitted(cap.NET_ADMIN) {
+		return nil
+	}
+
+	if state == 0 {
+		state = neighReachable
+	}
+
+	neigh := &netlink.Neigh{
+		LinkIndex:    iface.Index,
+		Family:       neighbourFamily(current.IP),
+		State:        int(state),
+		IP:           current.IPAddr().IP,
+		HardwareAddr: current.mac,
+	}
+
+	return withNetAdmin(func() error {
+		if err := netlink.NeighSet(neigh); err != nil {
+			return fmt.Errorf("failure adding IP %s to cache: %w", current.String(), err)
+		}
+		return nil
+	})
+}
+
+// getNeighbour finds the entry for ip in the kernel's neighbour table for iface, reporting whether it exists.
+func getNeighbour(iface *net.Interface, ip netaddr.IP) (neighEntry, bool, error) {
+	entries, err := readNeighbours(iface, neighbourFamily(ip))
+	if err != nil {
+		return neighEntry{}, false, err
+	}
+
+	for _, entry := range entries {
+		if entry.ip == ip {
+			return entry, true, nil
+		}
+	}
+
+	return neighEntry{}, false, nil
+}
+
+// deleteNeighbour removes the entry for ip from the kernel's neighbour table for iface. Entries that do not
+// exist are ignored.
+func deleteNeighbour(iface *net.Interface, ip netaddr.IP) error {
+	neigh := &netlink.Neigh{
+		LinkIndex: iface.Index,
+		Family:    neighbourFamily(ip),
+		IP:        ip.IPAddr().IP,
+	}
+
+	return withNetAdmin(func() error {
+		if err := netlink.NeighDel(neigh); err != nil && !errors.Is(err, syscall.ENOENT) {
+			return fmt.Errorf("unable to delete neighbour entry for %s on %s: %w", ip.String(), iface.Name, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,154 @@
+package arplookup
+
+import (
+	"fmt"
+	"sync"
+)
+
+// clientPool shares ARP clients between concurrent lookups on the same interface, so that they read replies off a
+// single socket and hold the capabilities needed to open it once. A client is closed once the last sweep using it
+// releases it.
+type clientPool struct {
+	mu      sync.Mutex
+	clients map[string]*pooledClient // clients in use, keyed by clientKey
+	closed  bool                     // whether the runtime owning the pool has been closed
+	// creates the clients shared, which is mkClientFor unless testing
+	mkClient func(ctxData) arpClient
+}
+
+// failingClient is implemented by clients that stop working for good once reading their socket fails. The pool
+// stops sharing such a client, leaving it to the sweeps already holding it.
+type failingClient interface {
+	failed() bool
+}
+
+// pooledClient is a client shared by refs sweeps. Its refs are guarded by the pool's lock.
+type pooledClient struct {
+	ac    arpClient
+	refs  int
+	ready chan struct{} // closed once init has returned
+	err   error         // returned by init
+}
+
+func mkClientPool() *clientPool {
+	return &clientPool{
+		clients: map[string]*pooledClient{},
+		mkClient: func(data ctxData) arpClient {
+			return mkClientFor(data, data.macs.list()...)
+		},
+	}
+}
+
+// clientKey identifies the clients a sweep with data can share, which must agree on every setting mkClientFor
+// passes on to the client, including the MACs it unicasts requests to and filters replies by.
+func clientKey(data ctxData) string {
+	iface := ""
+	if data.iface != nil {
+		iface = data.iface.Name
+	}
+	v4, v6 := families(data.network)
+
+	return fmt.Sprintf("%s/%s/%s/%t/%t/%d/%s/%t/%d/%t/%s/%p/%s", iface, lookupMethod(data), data.sourceIP, v4, v6,
+		data.acceptStates, data.requestTimeout, data.skipCache, data.cacheState, data.passive, data.transport,
+		data.helper, data.macs.key())
+}
+
+// acquire returns an initialised client for data, shared with every other sweep holding one for the same interface
+// and settings, along with a function releasing it. Clients searching a VLAN aren't shared, as the lookup that
+// created its sub-interface may remove it while others are still using it.
+func (p *clientPool) acquire(data ctxData) (arpClient, func(), error) {
+	if data.vlanID != 0 {
+		ac := p.mkClient(data)
+		if err := ac.init(data.iface); err != nil {
+			ac.destroy()
+			return nil, nil, err
+		}
+
+		return ac, func() { ac.destroy() }, nil
+	}
+
+	// The address requests are sent from is chosen before the key is made, so that sweeps of different networks
+	// from the same address share a client
+	if data.sourceIP.IsZero() && data.iface != nil {
+		if prefixes, err := interfacePrefixes(data.iface); err == nil {
+			if srcIP, err := selectSourceIP(prefixes, data.sourceIP, data.network); err == nil {
+				data.sourceIP = srcIP
+			}
+		}
+	}
+
+	key := clientKey(data)
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, nil, errReconfigured
+	}
+	pc, ok := p.clients[key]
+	if ok && pc.failed() {
+		delete(p.clients, key)
+		ok = false
+	}
+	if !ok {
+		pc = &pooledClient{ac: p.mkClient(data), ready: make(chan struct{})}
+		p.clients[key] = pc
+	}
+	pc.refs++
+	p.mu.Unlock()
+
+	if !ok {
+		pc.err = pc.ac.init(data.iface)
+		close(pc.ready)
+	}
+	<-pc.ready
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() { p.release(key, pc) })
+	}
+
+	if pc.err != nil {
+		release()
+		return nil, nil, pc.err
+	}
+
+	return pc.ac, release, nil
+}
+
+// failed reports whether pc's client has been initialised and has since stopped working. The pool's lock must be
+// held.
+func (pc *pooledClient) failed() bool {
+	select {
+	case <-pc.ready:
+	default:
+		return false
+	}
+
+	ac, ok := pc.ac.(failingClient)
+	return ok && pc.err == nil && ac.failed()
+}
+
+// release drops a sweep's reference to pc, closing it once no sweep is using it.
+func (p *clientPool) release(key string, pc *pooledClient) {
+	p.mu.Lock()
+	pc.refs--
+	last := pc.refs == 0
+	if last && p.clients[key] == pc {
+		delete(p.clients, key)
+	}
+	p.mu.Unlock()
+
+	if last {
+		pc.ac.destroy()
+	}
+}
+
+// close stops the pool from handing out clients. Sweeps still holding one keep it until they release it, which closes
+// it as before.
+func (p *clientPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	p.clients = map[string]*pooledClient{}
+}
@@ -0,0 +1,120 @@
+package arplookup
+
+import (
+	"fmt"
+	"sync"
+
+	"kernel.org/pub/linux/libs/security/libcap/cap"
+)
+
+// privilegeManager raises capabilities into the process's effective set for as long as any lookup needs them.
+// Capabilities are shared by every thread in the process, so each raised capability is reference counted and only
+// lowered once the last concurrent lookup using it has released it. Capabilities that were already effective
+// before the first acquire, such as when running as root, are never lowered.
+type privilegeManager struct {
+	mu      sync.Mutex
+	raised  map[cap.Value]int // number of holders of each capability raised by the manager
+	proc    func() *cap.Set   // reads the process's capabilities
+	setProc func(*cap.Set) error
+}
+
+// privileges manages the capabilities of the running provider.
+var privileges = &privilegeManager{
+	raised:  map[cap.Value]int{},
+	proc:    cap.GetProc,
+	setProc: (*cap.Set).SetProc,
+}
+
+// acquire makes values effective until the returned function is called, which is safe to call more than once.
+// A PermissionError is returned if any of values isn't in the process's permitted set.
+func (pm *privilegeManager) acquire(values ...cap.Value) (func(), error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	caps := pm.proc()
+	missing := []string{}
+	raise := []cap.Value{}
+	held := []cap.Value{}
+	for _, value := range values {
+		if pm.raised[value] > 0 {
+			held = append(held, value)
+			continue
+		}
+
+		if ok, _ := caps.GetFlag(cap.Effective, value); ok {
+			continue
+		}
+
+		if ok, _ := caps.GetFlag(cap.Permitted, value); !ok {
+			missing = append(missing, value.String())
+			continue
+		}
+
+		raise = append(raise, value)
+	}
+	if len(missing) > 0 {
+		return func() {}, &PermissionError{Capabilities: missing, Err: fmt.Errorf("have %q", caps)}
+	}
+
+	if len(raise) > 0 {
+		if err := caps.SetFlag(cap.Effective, true, raise...); err != nil {
+			return func() {}, fmt.Errorf("unable to set capability: %w", err)
+		}
+
+		if err := pm.setProc(caps); err != nil {
+			return func() {}, fmt.Errorf("unable to raise capabilities %q: %w", caps, err)
+		}
+	}
+
+	counted := append(held, raise...)
+	for _, value := range counted {
+		pm.raised[value]++
+	}
+
+	var once sync.Once
+
+	return func() { once.Do(func() { pm.release(counted...) }) }, nil
+}
+
+// release drops a hold on each of values, lowering those no longer held by anyone.
+func (pm *privilegeManager) release(values ...cap.Value) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	lower := []cap.Value{}
+	for _, value := range values {
+		pm.raised[value]--
+		if pm.raised[value] <= 0 {
+			delete(pm.raised, value)
+			lower = append(lower, value)
+		}
+	}
+	if len(lower) == 0 {
+		return
+	}
+
+	caps := pm.proc()
+	if err := caps.SetFlag(cap.Effective, false, lower...); err == nil {
+		pm.setProc(caps)
+	}
+}
+
+// effective reports whether value is currently in the process's effective set.
+func (pm *privilegeManager) effective(value cap.Value) bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	ok, _ := pm.proc().GetFlag(cap.Effective, value)
+
+	return ok
+}
+
+// permitted reports whether value is in the process's permitted set, so could be acquired.
+func (pm *privilegeManager) permitted(value cap.Value) bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	ok, _ := pm.proc().GetFlag(cap.Permitted, value)
+
+	return ok
+}
@@ -0,0 +1,147 @@
+package arplookup
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"inet.af/netaddr"
+	"kernel.org/pub/linux/libs/security/libcap/cap"
+	"terraform-provider-arplookup/internal/privhelper"
+)
+
+// mkTestPrivileges returns a privilegeManager operating on an in-memory capability set instead of the process's.
+func mkTestPrivileges(t *testing.T, permitted, effective []cap.Value) (*privilegeManager, func() *cap.Set) {
+	t.Helper()
+
+	current := cap.NewSet()
+	if err := current.SetFlag(cap.Permitted, true, permitted...); err != nil {
+		t.Fatal(err)
+	}
+	if err := current.SetFlag(cap.Effective, true, effective...); err != nil {
+		t.Fatal(err)
+	}
+
+	dup := func() *cap.Set {
+		c, err := current.Dup()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	return &privilegeManager{
+		raised: map[cap.Value]int{},
+		proc:   dup,
+		setProc: func(c *cap.Set) error {
+			current = c
+			return nil
+		},
+	}, dup
+}
+
+// TestPrivilegeRefcount checks whether a capability raised by concurrent holders stays effective until the last
+// of them releases it.
+func TestPrivilegeRefcount(t *testing.T) {
+	pm, proc := mkTestPrivileges(t, []cap.Value{cap.NET_RAW, cap.NET_ADMIN}, nil)
+
+	first, err := pm.acquire(cap.NET_RAW)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := pm.acquire(cap.NET_RAW, cap.NET_ADMIN)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first()
+	first() // releasing twice must not drop the second holder's capability
+	if ok, _ := proc().GetFlag(cap.Effective, cap.NET_RAW); !ok {
+		t.Fatal("expected NET_RAW to stay effective while still held")
+	}
+
+	second()
+	for _, value := range []cap.Value{cap.NET_RAW, cap.NET_ADMIN} {
+		if ok, _ := proc().GetFlag(cap.Effective, value); ok {
+			t.Fatalf("expected %s to be lowered once released", value)
+		}
+	}
+}
+
+// TestPrivilegeInherent checks whether capabilities that were effective before being acquired, as when running as
+// root, are left raised.
+func TestPrivilegeInherent(t *testing.T) {
+	pm, proc := mkTestPrivileges(t, []cap.Value{cap.NET_RAW}, []cap.Value{cap.NET_RAW})
+
+	release, err := pm.acquire(cap.NET_RAW)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	if ok, _ := proc().GetFlag(cap.Effective, cap.NET_RAW); !ok {
+		t.Fatal("expected inherent NET_RAW to stay effective")
+	}
+	if !pm.effective(cap.NET_RAW) {
+		t.Fatal("expected NET_RAW to be reported as effective")
+	}
+}
+
+// TestPrivilegeMissing checks whether acquiring a capability that isn't permitted reports which are missing
+// without raising any of the others.
+func TestPrivilegeMissing(t *testing.T) {
+	pm, proc := mkTestPrivileges(t, []cap.Value{cap.NET_RAW}, nil)
+
+	release, err := pm.acquire(cap.NET_RAW, cap.NET_ADMIN)
+	defer release()
+
+	var permission *PermissionError
+	if !errors.As(err, &permission) || !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected PermissionError, got: %v", err)
+	}
+	if len(permission.Capabilities) != 1 || permission.Capabilities[0] != cap.NET_ADMIN.String() {
+		t.Fatalf("expected only %s to be missing, got: %v", cap.NET_ADMIN, permission.Capabilities)
+	}
+	if ok, _ := proc().GetFlag(cap.Effective, cap.NET_RAW); ok {
+		t.Fatal("expected NET_RAW not to be raised when acquire fails")
+	}
+}
+
+// TestLookupMethod checks whether raw sockets are used when NET_RAW can be raised, the helper when one is running,
+// and UDP probes otherwise.
+func TestLookupMethod(t *testing.T) {
+	privileged := privileges
+	defer func() { privileges = privileged }()
+
+	privileges, _ = mkTestPrivileges(t, []cap.Value{cap.NET_RAW}, nil)
+	if method := lookupMethod(ctxData{}); method != methodRaw {
+		t.Fatalf("expected %s method with NET_RAW permitted, got: %s", methodRaw, method)
+	}
+
+	privileges, _ = mkTestPrivileges(t, nil, nil)
+	if method := lookupMethod(ctxData{}); method != methodUDP {
+		t.Fatalf("expected %s method without NET_RAW, got: %s", methodUDP, method)
+	}
+	if _, ok := mkClientFor(ctxData{network: &netaddr.IPSet{}}).(*linuxProbe); !ok {
+		t.Fatal("expected a UDP probe client without NET_RAW")
+	}
+
+	if method := lookupMethod(ctxData{helper: &privhelper.Client{}}); method != methodHelper {
+		t.Fatalf("expected %s method with a helper, got: %s", methodHelper, method)
+	}
+}
+
+// TestWarmNeighbourUnprivileged checks whether found hosts are left out of the neighbour table, rather than failing
+// the lookup, when NET_ADMIN can't be raised.
+func TestWarmNeighbourUnprivileged(t *testing.T) {
+	privileged := privileges
+	defer func() { privileges = privileged }()
+
+	privileges, _ = mkTestPrivileges(t, []cap.Value{cap.NET_RAW}, nil)
+
+	// No interface has index 0, so the kernel would refuse the entry if it were added
+	found := IP{IP: netaddr.MustParseIP("10.0.0.1"), mac: net.HardwareAddr{0x02, 0, 0, 0, 0, 1}}
+	if err := warmNeighbour(&net.Interface{Name: "none"}, found, neighReachable); err != nil {
+		t.Fatalf("expected warming to be skipped without NET_ADMIN, got: %s", err.Error())
+	}
+}
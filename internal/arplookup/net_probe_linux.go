@@ -0,0 +1,174 @@
+package arplookup
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"inet.af/netaddr"
+	"kernel.org/pub/linux/libs/security/libcap/cap"
+)
+
+// Methods used to look hosts up, as reported by the `method` attribute of data sources.
+const (
+	methodRaw    = "raw"    // ARP and NDP over raw sockets opened by the provider
+	methodHelper = "helper" // ARP over raw sockets opened by the privileged helper
+	methodUDP    = "udp"    // unprivileged UDP probes, with replies read from the neighbour table
+)
+
+// probePort is the port UDP probes are sent to. It is the discard port, so hosts that are listening ignore them.
+const probePort = 9
+
+// lookupMethod returns the method a search with data will use. Raw sockets are preferred, and the unprivileged
+// UDP fallback is only used when the provider has neither a helper nor the NET_RAW capability.
+func lookupMethod(data ctxData) string {
+	switch {
+	case data.helper != nil:
+		return methodHelper
+	case privileges.permitted(cap.NET_RAW):
+		return methodRaw
+	default:
+		return methodUDP
+	}
+}
+
+// linuxProbe is an arpClient that needs no privileges. Rather than sending ARP or NDP requests itself, it sends
+// a UDP datagram to each host so that the kernel resolves the host's MAC, and then reads the result from the
+// kernel's neighbour table.
+type linuxProbe struct {
+	targets macSet
+	accept  neighState // neighbour table states to accept
+	// how long to wait for the kernel to resolve each host, or defaultRequestTimeout if zero
+	requestTimeout time.Duration
+	v4, v6         bool // address families to read from the neighbour table
+	iface          *net.Interface
+}
+
+func mkLinuxProbe(dstMACs ...net.HardwareAddr) *linuxProbe {
+	return &linuxProbe{
+		targets: mkMACSet(dstMACs...),
+		v4:      true,
+	}
+}
+
+func (ac *linuxProbe) init(iface *net.Interface) error {
+	if err := checkInterfaceUp(iface); err != nil {
+		return err
+	}
+
+	ac.iface = iface
+
+	return nil
+}
+
+func (ac *linuxProbe) destroy() error {
+	return nil
+}
+
+// cache implements arpClient for linuxProbe. Hosts are only ever found in the neighbour table, so there is
+// nothing to add.
+func (ac *linuxProbe) cache(current IP) error {
+	return nil
+}
+
+// try implements arpClient for linuxProbe, reading the kernel's neighbour table.
+func (ac *linuxProbe) try(chans channels) {
+	if ac.v4 {
+		tryNeighbours(chans, ac.iface, netlink.FAMILY_V4, ac.targets, ac.accept)
+	}
+	if ac.v6 {
+		tryNeighbours(chans, ac.iface, netlink.FAMILY_V6, ac.targets, ac.accept)
+	}
+}
+
+// request implements arpClient for linuxProbe. Hosts that the kernel hasn't resolved within the request timeout
+// are reported as not found, but will still be picked up by a later try once they are.
+func (ac *linuxProbe) request(ctx context.Context, current netaddr.IP) (IP, error) {
+	if err := ac.probe(current); err != nil {
+		return IP{}, err
+	}
+
+	t := time.NewTimer(orDefault(ac.requestTimeout, defaultRequestTimeout))
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+		t.Stop()
+		return IP{}, nil
+	}
+
+	entry, ok, err := getNeighbour(ac.iface, current)
+	if err != nil || !ok {
+		return IP{}, err
+	}
+	if !ac.accept.accepts(entry.state) || !ac.targets.matches(entry.mac) {
+		return IP{}, nil
+	}
+
+	return IP{cached: true, mac: entry.mac, IP: entry.ip}, nil
+}
+
+// resolve finds the MAC of the host at ip, probing it every backoff period until it appears in the neighbour
+// table, ctx expires or maxAttempts probes have been sent, if set.
+func (ac *linuxProbe) resolve(ctx context.Context, ip netaddr.IP, backoff time.Duration, maxAttempts int) (net.HardwareAddr, error) {
+	for attempt := 1; ; attempt++ {
+		entry, ok, err := getNeighbour(ac.iface, ip)
+		if err != nil {
+			return nil, err
+		}
+		if ok && ac.accept.accepts(entry.state) {
+			return entry.mac, nil
+		}
+
+		if attempt > maxAttempts && maxAttempts > 0 {
+			return nil, errNoMAC
+		}
+
+		if err := ac.probe(ip); err != nil {
+			return nil, err
+		}
+
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, expired(ctx, errNoMAC)
+		case <-t.C:
+		}
+	}
+}
+
+// probe sends an empty UDP datagram to current out of the client's interface, which makes the kernel resolve
+// current's MAC if it isn't already in the neighbour table.
+func (ac *linuxProbe) probe(current netaddr.IP) error {
+	dialer := net.Dialer{
+		Control: func(network, address string, c syscall.RawConn) error {
+			// Binding to a device needs no privileges since Linux 5.7. On older kernels the route to current is
+			// relied upon instead.
+			return c.Control(func(fd uintptr) {
+				syscall.BindToDevice(int(fd), ac.iface.Name)
+			})
+		},
+	}
+
+	addr := current.WithZone("")
+	if current.Is6() && current.IsLinkLocalUnicast() {
+		addr = current.WithZone(ac.iface.Name)
+	}
+
+	conn, err := dialer.Dial("udp", net.JoinHostPort(addr.String(), strconv.Itoa(probePort)))
+	if err != nil {
+		return socketError("unable to open UDP socket on "+ac.iface.Name, err)
+	}
+	defer conn.Close()
+
+	// Hosts that failed to resolve last time make the write fail, which just means they haven't been found yet
+	if _, err := conn.Write([]byte{}); err != nil && !errors.Is(err, syscall.EHOSTUNREACH) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,261 @@
+package arplookup
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/mdlayher/arp"
+	"github.com/mdlayher/ethernet"
+	"golang.org/x/sys/unix"
+)
+
+// Layout of the TPACKET_V3 rings shared with the kernel. The RX ring is made of blocks, each packed with the frames
+// that arrive until it fills or is retired, and handed over a whole block at a time. The TX ring is made of fixed
+// size frames, every one starting with a tpacket3_hdr whose status tells whether it is owned by the kernel or the
+// provider.
+const (
+	ringBlockSize   = 1 << 16 // bytes in each block, a multiple of the page size
+	ringRXBlocks    = 32
+	ringRXFrameSize = 512 // only used by the kernel to check the size of the RX ring
+	ringTXBlocks    = 1
+	ringTXFrameSize = 128
+	ringRXFrames    = ringBlockSize / ringRXFrameSize * ringRXBlocks
+	ringTXFrames    = ringBlockSize / ringTXFrameSize * ringTXBlocks
+	// milliseconds after which the kernel retires an RX block holding any frames, rounded up to a jiffy
+	ringRetireTimeout = 1
+	// offset of the frame to send within a TX slot, which follows the header aligned to TPACKET_ALIGNMENT
+	ringTXData = (unix.SizeofTpacket3Hdr + unix.TPACKET_ALIGNMENT - 1) &^ (unix.TPACKET_ALIGNMENT - 1)
+)
+
+// ringRequestTimeout is the least time a host is given to reply to a single request with the ring transport, as
+// its reply may wait in an RX block for a jiffy before the block is retired, which is 10ms with the coarsest clock
+// Linux is built with.
+const ringRequestTimeout = 10 * time.Millisecond
+
+// ringConn is an arpConn exchanging frames with the kernel through TPACKET_V3 rings memory-mapped from a packet
+// socket. Frames received are copied into the RX ring as they arrive and handed over a block at a time, so a reader
+// woken once can take every reply that arrived since, and requests queued in the TX ring are sent by a single
+// system call. Frames are otherwise matched exactly as they would be read off the socket.
+type ringConn struct {
+	conn net.PacketConn
+	raw  syscall.RawConn
+	mem  []byte // both rings, as mapped
+	rx   []byte
+	tx   []byte
+
+	rxBlock int           // next RX block the kernel will hand over, only used by the reader
+	pending []*arp.Packet // packets taken off the RX ring but not yet read
+
+	txMu    sync.Mutex
+	txFrame int // next TX frame to queue a request in
+}
+
+// mkRingConn sets up and maps the rings of conn, which must be a packet socket bound to an interface.
+func mkRingConn(conn net.PacketConn) (*ringConn, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("socket does not support memory-mapped rings")
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var mem []byte
+	cerr := raw.Control(func(fd uintptr) {
+		mem, err = mapRings(int(fd))
+	})
+	if cerr != nil {
+		return nil, cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rxSize := ringBlockSize * ringRXBlocks
+
+	return &ringConn{conn: conn, raw: raw, mem: mem, rx: mem[:rxSize], tx: mem[rxSize:]}, nil
+}
+
+// mapRings switches fd to TPACKET_V3, creates its RX and TX rings and maps them, RX first, into a single region.
+// TX rings are only supported with TPACKET_V3 since Linux 4.11.
+func mapRings(fd int) ([]byte, error) {
+	if err := unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_VERSION, unix.TPACKET_V3); err != nil {
+		return nil, fmt.Errorf("unable to select TPACKET_V3: %w", err)
+	}
+
+	rx := unix.TpacketReq3{
+		Block_size:     ringBlockSize,
+		Block_nr:       ringRXBlocks,
+		Frame_size:     ringRXFrameSize,
+		Frame_nr:       ringRXFrames,
+		Retire_blk_tov: ringRetireTimeout,
+	}
+	if err := unix.SetsockoptTpacketReq3(fd, unix.SOL_PACKET, unix.PACKET_RX_RING, &rx); err != nil {
+		return nil, fmt.Errorf("unable to create RX ring: %w", err)
+	}
+
+	// The kernel rejects a TX ring with a retire timeout, private area or features
+	tx := unix.TpacketReq3{
+		Block_size: ringBlockSize,
+		Block_nr:   ringTXBlocks,
+		Frame_size: ringTXFrameSize,
+		Frame_nr:   ringTXFrames,
+	}
+	if err := unix.SetsockoptTpacketReq3(fd, unix.SOL_PACKET, unix.PACKET_TX_RING, &tx); err != nil {
+		return nil, fmt.Errorf("unable to create TX ring: %w", err)
+	}
+
+	mem, err := unix.Mmap(fd, 0, ringBlockSize*(ringRXBlocks+ringTXBlocks), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("unable to map rings: %w", err)
+	}
+
+	return mem, nil
+}
+
+// frameHeader returns the header of frame i of a ring of frames of the given size.
+func frameHeader(ring []byte, size int, i int) (*unix.Tpacket3Hdr, []byte) {
+	frame := ring[i*size : (i+1)*size]
+	return (*unix.Tpacket3Hdr)(unsafe.Pointer(&frame[0])), frame
+}
+
+// blockHeader returns the header of block i of the RX ring.
+func blockHeader(ring []byte, i int) (*unix.TpacketHdrV1, []byte) {
+	block := ring[i*ringBlockSize : (i+1)*ringBlockSize]
+	desc := (*unix.TpacketBlockDesc)(unsafe.Pointer(&block[0]))
+	return (*unix.TpacketHdrV1)(unsafe.Pointer(&desc.Hdr[0])), block
+}
+
+// read implements arpConn, waiting for the kernel to hand over a block if no packets are pending.
+func (r *ringConn) read() (*arp.Packet, error) {
+	for len(r.pending) == 0 {
+		if err := r.raw.Read(func(uintptr) bool { return r.takeBlocks() }); err != nil {
+			return nil, err
+		}
+	}
+
+	pkt := r.pending[0]
+	r.pending[0] = nil
+	r.pending = r.pending[1:]
+
+	return pkt, nil
+}
+
+// takeBlocks decodes the frames of every block the kernel has handed over, in order, returning each block to the
+// kernel once its frames are copied out. It reports whether any were taken, so that the reader waits for the socket
+// to become readable otherwise.
+func (r *ringConn) takeBlocks() bool {
+	taken := 0
+	for ; taken < ringRXBlocks; taken++ {
+		hdr, block := blockHeader(r.rx, r.rxBlock)
+		if atomic.LoadUint32(&hdr.Block_status)&unix.TP_STATUS_USER == 0 {
+			break
+		}
+
+		offset := hdr.Offset_to_first_pkt
+		for i := uint32(0); i < hdr.Num_pkts; i++ {
+			frame := (*unix.Tpacket3Hdr)(unsafe.Pointer(&block[offset]))
+			start := offset + uint32(frame.Mac)
+
+			// Frames that aren't ARP or are malformed are skipped, as arp.Client does
+			if pkt, ok := parseARPFrame(block[start : start+frame.Snaplen]); ok {
+				r.pending = append(r.pending, pkt)
+			}
+
+			offset += frame.Next_offset
+		}
+
+		atomic.StoreUint32(&hdr.Block_status, unix.TP_STATUS_KERNEL)
+		r.rxBlock = (r.rxBlock + 1) % ringRXBlocks
+	}
+
+	return taken > 0
+}
+
+// parseARPFrame decodes the ARP packet carried by an ethernet frame, reporting false if there is none.
+func parseARPFrame(b []byte) (*arp.Packet, bool) {
+	var f ethernet.Frame
+	if err := f.UnmarshalBinary(b); err != nil || f.EtherType != ethernet.EtherTypeARP {
+		return nil, false
+	}
+
+	pkt := new(arp.Packet)
+	if err := pkt.UnmarshalBinary(f.Payload); err != nil {
+		return nil, false
+	}
+
+	return pkt, true
+}
+
+// writeTo implements arpConn, queuing pkt in the next TX frame and asking the kernel to send it. If the kernel is
+// still sending the frame previously queued there, it waits for the socket to become writable.
+func (r *ringConn) writeTo(pkt *arp.Packet, dst net.HardwareAddr) error {
+	payload, err := pkt.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	f := &ethernet.Frame{
+		Destination: dst,
+		Source:      pkt.SenderHardwareAddr,
+		EtherType:   ethernet.EtherTypeARP,
+		Payload:     payload,
+	}
+	b, err := f.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if len(b) > ringTXFrameSize-ringTXData {
+		return fmt.Errorf("frame of %d bytes does not fit in TX ring", len(b))
+	}
+
+	r.txMu.Lock()
+	defer r.txMu.Unlock()
+
+	queued := false
+	werr := r.raw.Write(func(fd uintptr) bool {
+		if !queued {
+			hdr, frame := frameHeader(r.tx, ringTXFrameSize, r.txFrame)
+			if atomic.LoadUint32(&hdr.Status)&(unix.TP_STATUS_SEND_REQUEST|unix.TP_STATUS_SENDING) != 0 {
+				return false
+			}
+
+			copy(frame[ringTXData:], b)
+			hdr.Len = uint32(len(b))
+			atomic.StoreUint32(&hdr.Status, unix.TP_STATUS_SEND_REQUEST)
+			r.txFrame = (r.txFrame + 1) % ringTXFrames
+			queued = true
+		}
+
+		// Sending nothing to no address asks the kernel to send the frames queued, which unix.Sendto can't do
+		_, _, errno := unix.Syscall6(unix.SYS_SENDTO, fd, 0, 0, 0, 0, 0)
+		if errno == unix.EAGAIN {
+			return false
+		}
+		if errno != 0 {
+			err = errno
+		}
+
+		return true
+	})
+	if werr != nil {
+		return werr
+	}
+
+	return err
+}
+
+// close implements arpConn. The socket is closed first, which waits for a reader or writer still using the rings to
+// return before they are unmapped.
+func (r *ringConn) close() error {
+	err := r.conn.Close()
+	unix.Munmap(r.mem)
+
+	return err
+}
@@ -0,0 +1,141 @@
+package arplookup
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/arp"
+	"github.com/mdlayher/packet"
+	"inet.af/netaddr"
+)
+
+// TestRingConn checks whether ARP packets sent through the TX ring are sent on the wire and read back off the RX
+// ring, using the loopback interface so that nothing leaves the host.
+func TestRingConn(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("no loopback interface: %s", err.Error())
+	}
+
+	conn, err := packet.Listen(lo, packet.Raw, etherTypeARP, nil)
+	if err != nil {
+		t.Skipf("unable to open ARP socket, which needs CAP_NET_RAW: %s", err.Error())
+	}
+
+	ring, err := mkRingConn(conn)
+	if err != nil {
+		conn.Close()
+		t.Fatalf("error encountered while mapping rings: %s", err.Error())
+	}
+	defer ring.close()
+
+	sender := net.HardwareAddr{0x3e, 0x50, 0x6e, 0x54, 0x28, 0x01}
+	target := net.HardwareAddr{0x3e, 0x50, 0x6e, 0x54, 0x28, 0x02}
+	// More requests are sent than the TX ring holds, so that frames are reused once sent
+	sent := ringTXFrames + 16
+	for i := 0; i < sent; i++ {
+		ip := netaddr.IPFrom4([4]byte{192, 168, byte(32 + i>>8), byte(i)})
+		pkt, err := arp.NewPacket(arp.OperationReply, sender, fromNetaddr(ip), target, fromNetaddr(netaddr.MustParseIP("192.168.33.1")))
+		if err != nil {
+			t.Fatalf("error encountered while creating packet: %s", err.Error())
+		}
+
+		if err := ring.writeTo(pkt, target); err != nil {
+			t.Fatalf("error encountered while sending packet %d: %s", i, err.Error())
+		}
+	}
+
+	received := make(chan *arp.Packet)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			pkt, err := ring.read()
+			if err != nil {
+				return
+			}
+
+			select {
+			case received <- pkt:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// Each frame sent on the loopback interface is seen both leaving and arriving
+	seen := map[netaddr.IP]int{}
+	timeout := time.After(5 * time.Second)
+	for len(seen) < sent {
+		select {
+		case pkt := <-received:
+			if pkt.Operation != arp.OperationReply || pkt.SenderHardwareAddr.String() != sender.String() {
+				t.Fatalf("unexpected packet read: %+v", pkt)
+			}
+			seen[toNetaddr(pkt.SenderIP)]++
+		case <-timeout:
+			t.Fatalf("expected %d packets to be read, got %d", sent, len(seen))
+		}
+	}
+}
+
+// The transport benchmarks sweep a /16 whose every address answers from the far end of a veth pair.
+const (
+	benchInterface = "vethbench"
+	benchAddr      = "10.19.0.1/16"
+	benchNetwork   = "10.19.0.0/16"
+)
+
+// BenchmarkTransport measures how long a sweep of a /16 takes with each transport and scan mode, along with how many
+// of its hosts were found. It needs the environment of the acceptance tests, so is run with
+// tools/pretest.sh -run '^$' -bench Transport.
+func BenchmarkTransport(b *testing.B) {
+	if os.Getenv("TF_ACC") == "" {
+		b.Skip("TF_ACC must be set to benchmark against the test network")
+	}
+
+	if err := driver.Init(rand.New(rand.NewSource(time.Now().UTC().Unix()))); err != nil {
+		b.Fatalf("unable to init test driver: %s", err.Error())
+	}
+
+	iface, err := driver.VethPair(benchInterface, benchAddr, benchNetwork)
+	if err != nil {
+		b.Fatalf("unable to create veth pair: %s", err.Error())
+	}
+
+	var builder netaddr.IPSetBuilder
+	builder.AddPrefix(netaddr.MustParseIPPrefix(benchNetwork))
+	ipSet, _ := builder.IPSet()
+
+	clients := mkClientPool()
+	for _, mode := range []string{scanModeRequest, scanModeAsync} {
+		for _, transport := range []string{transportSocket, transportRing} {
+			b.Run(fmt.Sprintf("%s/%s", mode, transport), func(b *testing.B) {
+				data := ctxData{
+					iface:       iface,
+					network:     ipSet,
+					parallelism: 16,
+					scanMode:    mode,
+					transport:   transport,
+					skipCache:   true,
+				}
+
+				for i := 0; i < b.N; i++ {
+					ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+					hosts, err := getHostsFor(ctx, clients, data)
+					cancel()
+					if err != nil {
+						b.Fatalf("error encountered while sweeping with %s transport: %s", transport, err.Error())
+					}
+
+					b.ReportMetric(float64(len(hosts)), "hosts/op")
+				}
+			})
+		}
+	}
+}
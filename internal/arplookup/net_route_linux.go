@@ -0,0 +1,84 @@
+package arplookup
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"inet.af/netaddr"
+)
+
+// routeHop is the kernel's route to a single address being searched for.
+type routeHop struct {
+	ip        netaddr.IP
+	linkIndex int
+	onLink    bool // whether the address is directly connected, rather than reached through a gateway
+}
+
+// routeInterface selects the interface every range in network is directly connected to, by asking the kernel's
+// routing table for the route to the first and last address of each range.
+func routeInterface(network *netaddr.IPSet) (*net.Interface, error) {
+	hops := []routeHop{}
+	for _, r := range network.Ranges() {
+		for _, ip := range []netaddr.IP{r.From(), r.To()} {
+			routes, err := netlink.RouteGet(ip.IPAddr().IP)
+			if err != nil {
+				return nil, &LookupError{
+					Kind:   ErrInterfaceSelection,
+					Detail: fmt.Sprintf("no route to %s", ip.String()),
+					Err:    err,
+				}
+			}
+			if len(routes) == 0 {
+				return nil, &LookupError{Kind: ErrInterfaceSelection, Detail: fmt.Sprintf("no route to %s", ip.String())}
+			}
+
+			hops = append(hops, routeHop{
+				ip:        ip,
+				linkIndex: routes[0].LinkIndex,
+				onLink:    routes[0].Gw == nil,
+			})
+		}
+	}
+
+	index, err := pickInterface(hops)
+	if err != nil {
+		return nil, err
+	}
+
+	return net.InterfaceByIndex(index)
+}
+
+// pickInterface returns the index of the interface every hop leaves through, failing if any hop is through a
+// gateway or the hops leave through more than one interface.
+func pickInterface(hops []routeHop) (int, error) {
+	if len(hops) == 0 {
+		return 0, &LookupError{Kind: ErrInterfaceSelection, Detail: "no networks to select an interface for"}
+	}
+
+	offLink := []string{}
+	links := map[int]struct{}{}
+	for _, hop := range hops {
+		if !hop.onLink {
+			offLink = append(offLink, hop.ip.String())
+		}
+		links[hop.linkIndex] = struct{}{}
+	}
+
+	if len(offLink) > 0 {
+		return 0, &LookupError{
+			Kind:   ErrInterfaceSelection,
+			Detail: fmt.Sprintf("%s not directly connected to any interface", strings.Join(offLink, ", ")),
+		}
+	}
+
+	if len(links) > 1 {
+		return 0, &LookupError{
+			Kind:   ErrInterfaceSelection,
+			Detail: fmt.Sprintf("network spans %d interfaces", len(links)),
+		}
+	}
+
+	return hops[0].linkIndex, nil
+}
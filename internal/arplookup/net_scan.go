@@ -0,0 +1,339 @@
+package arplookup
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"inet.af/netaddr"
+)
+
+// scanCoordinator merges concurrent lookups of hosts on the same interface and network into a single shared sweep.
+// Terraform reads data sources in parallel, and without it each would open its own socket and send requests to
+// every host in the network. Lookups that start while a sweep is in progress join it, returning straight away if
+// their host has already replied, and the sweep ends once every lookup waiting on it has found its host, given up,
+// or had its context expire.
+type scanCoordinator struct {
+	mu      sync.Mutex
+	scans   map[string]*sharedScan // in-progress sweeps, keyed by scanKey
+	clients *clientPool            // provides the client each sweep is run with
+}
+
+// sharedScan is a sweep shared by every lookup in waiters. Its fields are guarded by the coordinator's lock.
+type sharedScan struct {
+	waiters map[string][]*scanWaiter // lookups waiting on the sweep, keyed by the MAC they're looking for
+	seen    map[string]netaddr.IP    // every host heard from since the sweep started, keyed by MAC
+	attempt int                      // number of the sweep currently in progress
+	joined  chan struct{}            // signalled when a lookup joins, so the system's cache is checked for it
+	cancel  context.CancelFunc
+}
+
+// scanWaiter is a lookup waiting on a shared sweep.
+type scanWaiter struct {
+	mac         net.HardwareAddr
+	joined      int // attempt that was in progress when the lookup joined
+	maxAttempts int
+	result      chan scanResult
+}
+
+type scanResult struct {
+	ip  netaddr.IP
+	err error
+}
+
+func mkScanCoordinator(clients *clientPool) *scanCoordinator {
+	return &scanCoordinator{
+		scans:   map[string]*sharedScan{},
+		clients: clients,
+	}
+}
+
+// scanKey identifies the sweeps a lookup with data can share, which must agree on every setting that changes which
+// hosts are found or what is done with them. Settings not in the key, such as the backoff or parallelism, only pace
+// the sweep and are taken from the lookup that started it.
+func scanKey(data ctxData) string {
+	iface := ""
+	if data.iface != nil {
+		iface = data.iface.Name
+	}
+
+	// Sources are recreated by every read, so they are told apart by what they read rather than their address
+	sources := make([]string, len(data.sources))
+	for i, source := range data.sources {
+		sources[i] = fmt.Sprint(source)
+	}
+
+	return fmt.Sprintf("%s/%d/%s/%v/%d/%t/%d/%s/%s/%s/%q/%p", iface, data.vlanID, data.sourceIP, data.network.Ranges(),
+		data.acceptStates, data.skipCache, data.cacheState, data.scanMode, data.transport, data.requestTimeout, sources,
+		data.helper)
+}
+
+// lookup finds the IP of the host with MAC, joining any sweep already in progress for data's interface and
+// network. It returns once the host is found, once data.maxAttempts sweeps have completed since it joined, or
+// once ctx expires, whichever is first.
+func (c *scanCoordinator) lookup(ctx context.Context, MAC net.HardwareAddr, data ctxData) (netaddr.IP, error) {
+	key := scanKey(data)
+	w := &scanWaiter{mac: MAC, maxAttempts: data.maxAttempts, result: make(chan scanResult, 1)}
+
+	c.mu.Lock()
+	s, ok := c.scans[key]
+	if !ok {
+		scanCtx, cancel := context.WithCancel(context.Background())
+		s = &sharedScan{
+			waiters: map[string][]*scanWaiter{},
+			seen:    map[string]netaddr.IP{},
+			joined:  make(chan struct{}, 1),
+			cancel:  cancel,
+		}
+		c.scans[key] = s
+		go c.run(scanCtx, key, s, data)
+	}
+	if ip, ok := s.seen[MAC.String()]; ok {
+		c.mu.Unlock()
+		return ip, nil
+	}
+	w.joined = s.attempt
+	s.waiters[MAC.String()] = append(s.waiters[MAC.String()], w)
+	c.mu.Unlock()
+
+	select {
+	case s.joined <- struct{}{}:
+	default:
+	}
+
+	select {
+	case r := <-w.result:
+		return r.ip, r.err
+	case <-ctx.Done():
+		c.leave(key, s, w)
+
+		// The host may have been found just as ctx expired
+		select {
+		case r := <-w.result:
+			return r.ip, r.err
+		default:
+		}
+
+		return netaddr.IP{}, expired(ctx, errNoIP)
+	}
+}
+
+// run sweeps the network every data.backoff period, passing hosts found to the lookups waiting on them, until no
+// lookups are left waiting and ctx is cancelled. The sweep's client targets the MACs being waited on, and is replaced
+// by one targeting the new set whenever it changes.
+func (c *scanCoordinator) run(ctx context.Context, key string, s *sharedScan, data ctxData) {
+	detach, err := attachVLAN(&data)
+	if err != nil {
+		c.fail(key, s, err)
+		return
+	}
+	defer detach()
+
+	// Replaced clients may still be used by a sweep in progress, so every client is held until the run has stopped
+	var ac arpClient
+	releases := []func(){}
+	defer func() {
+		for _, release := range releases {
+			release()
+		}
+	}()
+	retarget := func() error {
+		c.mu.Lock()
+		macs := s.macs()
+		c.mu.Unlock()
+
+		if ac != nil && (len(macs) == 0 || macs.key() == data.macs.key()) {
+			return nil
+		}
+
+		data.macs = macs
+		next, release, err := c.clients.acquire(data)
+		if err != nil {
+			return err
+		}
+		ac = next
+		releases = append(releases, release)
+
+		return nil
+	}
+
+	if err := retarget(); err != nil {
+		c.fail(key, s, err)
+		return
+	}
+
+	run := startLookupRun(ctx)
+	defer run.stop()
+
+	for attempt := 1; ; attempt++ {
+		c.mu.Lock()
+		s.attempt = attempt
+		c.mu.Unlock()
+
+		if err := retarget(); err != nil {
+			c.fail(key, s, err)
+			return
+		}
+		run.attempt(ac, data, true)
+
+		t := time.NewTimer(data.backoff)
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return
+			case err := <-run.chans.errors:
+				t.Stop()
+				c.fail(key, s, err)
+				return
+			case ip := <-run.chans.results:
+				if !c.waiting(s, ip) {
+					continue
+				}
+
+				if err := ac.cache(ip); err != nil {
+					c.resolve(key, s, ip.mac, scanResult{err: err})
+					continue
+				}
+				c.resolve(key, s, ip.mac, scanResult{ip: ip.IP})
+			case <-s.joined:
+				// Lookups joining mid-sweep may already be in the system's cache
+				if err := retarget(); err != nil {
+					t.Stop()
+					c.fail(key, s, err)
+					return
+				}
+				run.check(ac, data)
+			case <-t.C:
+				c.exhaust(key, s, attempt)
+				break wait
+			}
+		}
+	}
+}
+
+// macs returns the set of MACs being waited on. The coordinator's lock must be held.
+func (s *sharedScan) macs() macSet {
+	macs := make(macSet, len(s.waiters))
+	for key, waiters := range s.waiters {
+		macs[key] = waiters[0].mac
+	}
+
+	return macs
+}
+
+// waiting records that the sweep has heard from ip, and reports whether any lookup is waiting on it.
+func (c *scanCoordinator) waiting(s *sharedScan, ip IP) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := ip.mac.String()
+	if _, ok := s.seen[key]; !ok {
+		s.seen[key] = ip.IP
+	}
+
+	return len(s.waiters[key]) > 0
+}
+
+// resolve passes r to every lookup waiting on mac.
+func (c *scanCoordinator) resolve(key string, s *sharedScan, mac net.HardwareAddr, r scanResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, w := range s.waiters[mac.String()] {
+		w.result <- r
+	}
+	delete(s.waiters, mac.String())
+	c.finishIdle(key, s)
+}
+
+// exhaust fails the lookups that have waited on data.maxAttempts complete sweeps, once attempt has completed.
+func (c *scanCoordinator) exhaust(key string, s *sharedScan, attempt int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for mac, waiters := range s.waiters {
+		remaining := waiters[:0]
+		for _, w := range waiters {
+			if w.maxAttempts > 0 && attempt-w.joined >= w.maxAttempts {
+				w.result <- scanResult{err: errNoIP}
+				continue
+			}
+			remaining = append(remaining, w)
+		}
+
+		if len(remaining) == 0 {
+			delete(s.waiters, mac)
+		} else {
+			s.waiters[mac] = remaining
+		}
+	}
+	c.finishIdle(key, s)
+}
+
+// fail passes err to every lookup waiting on the sweep, ending it.
+func (c *scanCoordinator) fail(key string, s *sharedScan, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for mac, waiters := range s.waiters {
+		for _, w := range waiters {
+			w.result <- scanResult{err: err}
+		}
+		delete(s.waiters, mac)
+	}
+	c.finishIdle(key, s)
+}
+
+// leave stops w from waiting on the sweep.
+func (c *scanCoordinator) leave(key string, s *sharedScan, w *scanWaiter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	waiters := s.waiters[w.mac.String()]
+	for i, waiter := range waiters {
+		if waiter == w {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+
+	if len(waiters) == 0 {
+		delete(s.waiters, w.mac.String())
+	} else {
+		s.waiters[w.mac.String()] = waiters
+	}
+	c.finishIdle(key, s)
+}
+
+// finishIdle ends the sweep if nothing is waiting on it, so that later lookups start a new one. The coordinator's
+// lock must be held.
+func (c *scanCoordinator) finishIdle(key string, s *sharedScan) {
+	if len(s.waiters) > 0 {
+		return
+	}
+
+	if c.scans[key] == s {
+		delete(c.scans, key)
+	}
+	s.cancel()
+}
+
+// close ends every sweep in progress, failing the lookups waiting on them, so that they release their clients.
+func (c *scanCoordinator) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, s := range c.scans {
+		for mac, waiters := range s.waiters {
+			for _, w := range waiters {
+				w.result <- scanResult{err: errReconfigured}
+			}
+			delete(s.waiters, mac)
+		}
+		c.finishIdle(key, s)
+	}
+}
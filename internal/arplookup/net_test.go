@@ -1,14 +1,25 @@
 package arplookup
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/hashicorp/terraform-plugin-framework/types"
 	"inet.af/netaddr"
+	"terraform-provider-arplookup/internal/privhelper"
 )
 
-// TestCheckARPRunTimeout checks wether an empty IP, and errNoIP is returned from checkARPRun if an invalid
+// TestCheckARPRunTimeout checks wether an empty IP, and ErrTimedOut is returned from checkARPRun if an invalid
 // set of IP ranges, in relation to the expected output, is passed to checkARPun. This attempts to model what
 // happens with a scan for a machine with a desired MAC doesn't exist is ran.
 func TestCheckARPRunInvalid(t *testing.T) {
@@ -37,10 +48,10 @@ func TestCheckARPRunInvalid(t *testing.T) {
 
 		ctx, cancel := context.WithTimeout(context.Background(), timeout)
 		defer cancel()
-		ip, err := checkARPRun(ctx, ac, ctxData{nil, test.ipset, arpFuncBackoff})
+		ip, err := checkARPRun(ctx, ac, ctxData{network: test.ipset, backoff: arpFuncBackoff})
 
-		if err != nil && err != errNoIP {
-			t.Fatalf("expected errNoIP from checkARPRun, got: %s", err.Error())
+		if err != nil && !errors.Is(err, ErrTimedOut) {
+			t.Fatalf("expected ErrTimedOut from checkARPRun, got: %s", err.Error())
 		}
 
 		if !ip.IsZero() {
@@ -82,7 +93,7 @@ func TestCheckARPRunTimeout(t *testing.T) {
 		defer cancel()
 
 		start := time.Now()
-		checkARPRun(ctx, ac, ctxData{nil, test.ipset, arpFuncBackoff})
+		checkARPRun(ctx, ac, ctxData{network: test.ipset, backoff: arpFuncBackoff})
 		elapsed := time.Since(start)
 		if elapsed.Round(2*time.Millisecond) != test.timeout.Round(2*time.Millisecond) {
 			t.Fatalf("checkARPRun did not respect context timeout, took \"%s\", should have taken \"%s\"",
@@ -115,7 +126,7 @@ func TestCheckARPRun(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 		defer cancel()
 
-		ip, err := checkARPRun(ctx, ac, ctxData{nil, test.ipset, arpFuncBackoff})
+		ip, err := checkARPRun(ctx, ac, ctxData{network: test.ipset, backoff: arpFuncBackoff})
 		if err != nil && err != test.expectErr {
 			t.Fatalf("error encountered while running test: %s", err.Error())
 		}
@@ -125,3 +136,1060 @@ func TestCheckARPRun(t *testing.T) {
 		}
 	}
 }
+
+// TestCheckARPRunIPv6 checks whether checkARPRun will search IPv6 ranges for a machine with a desired MAC.
+func TestCheckARPRunIPv6(t *testing.T) {
+	var builder netaddr.IPSetBuilder
+	builder.AddPrefix(netaddr.MustParseIPPrefix("fd18::6:0/116"))
+	builder.AddPrefix(netaddr.MustParseIPPrefix("192.168.33.0/24"))
+	ipSet, _ := builder.IPSet()
+
+	testcases := []struct {
+		ipset  *netaddr.IPSet
+		expect netaddr.IP
+	}{
+		{
+			ipset:  ipSet,
+			expect: netaddr.MustParseIP("fd18::6:18"),
+		},
+		{
+			ipset:  ipSet,
+			expect: netaddr.MustParseIP("192.168.33.44"),
+		},
+	}
+
+	for _, test := range testcases {
+		ac := mkDummyARP(test.expect)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		ip, err := checkARPRun(ctx, ac, ctxData{network: test.ipset, backoff: arpFuncBackoff})
+		if err != nil {
+			t.Fatalf("error encountered while running test: %s", err.Error())
+		}
+
+		if ip != test.expect {
+			t.Fatalf("expected IP: %s, got: %s", test.expect.String(), ip.String())
+		}
+	}
+}
+
+// TestCheckARPRunAll checks whether checkARPRunAll finds every MAC in a single sweep, and reports those it cannot
+// find once its context expires.
+func TestCheckARPRunAll(t *testing.T) {
+	var builder netaddr.IPSetBuilder
+	builder.AddPrefix(netaddr.MustParseIPPrefix("192.168.33.0/16"))
+	ipSet, _ := builder.IPSet()
+
+	macA, _ := net.ParseMAC("3e:50:6e:54:28:3d")
+	macB, _ := net.ParseMAC("3e:50:6e:54:28:3e")
+	macC, _ := net.ParseMAC("0b:de:ad:be:ef:0b")
+
+	hosts := map[netaddr.IP]net.HardwareAddr{
+		netaddr.MustParseIP("192.168.33.44"): macA,
+		netaddr.MustParseIP("192.168.40.2"):  macB,
+	}
+
+	testcases := []struct {
+		macs    []net.HardwareAddr
+		expect  map[string]netaddr.IP
+		missing []string
+	}{
+		{
+			macs: []net.HardwareAddr{macA, macB},
+			expect: map[string]netaddr.IP{
+				macA.String(): netaddr.MustParseIP("192.168.33.44"),
+				macB.String(): netaddr.MustParseIP("192.168.40.2"),
+			},
+		},
+		{
+			macs: []net.HardwareAddr{macA, macC},
+			expect: map[string]netaddr.IP{
+				macA.String(): netaddr.MustParseIP("192.168.33.44"),
+			},
+			missing: []string{macC.String()},
+		},
+	}
+
+	for _, test := range testcases {
+		ac := mkDummyARPHosts(hosts)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		found, err := checkARPRunAll(ctx, ac, mkMACSet(test.macs...), ctxData{network: ipSet, backoff: arpFuncBackoff})
+		if test.missing == nil && err != nil {
+			t.Fatalf("error encountered while running test: %s", err.Error())
+		}
+
+		if test.missing != nil {
+			var missing missingError
+			if !errors.As(err, &missing) || !reflect.DeepEqual(missing.macs, test.missing) {
+				t.Fatalf("expected missing MACs %v, got: %v", test.missing, err)
+			}
+		}
+
+		if !reflect.DeepEqual(found, test.expect) {
+			t.Fatalf("expected IPs: %v, got: %v", test.expect, found)
+		}
+	}
+}
+
+// TestCheckARPRunCollect checks whether checkARPRunCollect reports every host that replies during a sweep, along with
+// hosts in the neighbour table that are on the network swept.
+func TestCheckARPRunCollect(t *testing.T) {
+	var builder netaddr.IPSetBuilder
+	builder.AddPrefix(netaddr.MustParseIPPrefix("192.168.33.0/20"))
+	ipSet, _ := builder.IPSet()
+
+	macA, _ := net.ParseMAC("3e:50:6e:54:28:3d")
+	macB, _ := net.ParseMAC("3e:50:6e:54:28:3e")
+	macC, _ := net.ParseMAC("3e:50:6e:54:28:3f")
+
+	ac := mkDummyARPHosts(map[netaddr.IP]net.HardwareAddr{
+		netaddr.MustParseIP("192.168.40.2"):  macB,
+		netaddr.MustParseIP("192.168.33.44"): macA,
+		netaddr.MustParseIP("10.0.33.44"):    macA,
+	})
+	ac.cached = []IP{
+		{cached: true, mac: macC, IP: netaddr.MustParseIP("192.168.34.7")},
+		// On another network of the same interface
+		{cached: true, mac: macC, IP: netaddr.MustParseIP("10.0.34.7")},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	hosts, err := checkARPRunCollect(ctx, ac, ctxData{network: ipSet, backoff: arpFuncBackoff})
+	if err != nil {
+		t.Fatalf("error encountered while running test: %s", err.Error())
+	}
+
+	expect := []IP{
+		{mac: macA, IP: netaddr.MustParseIP("192.168.33.44")},
+		{cached: true, mac: macC, IP: netaddr.MustParseIP("192.168.34.7")},
+		{mac: macB, IP: netaddr.MustParseIP("192.168.40.2")},
+	}
+	if !reflect.DeepEqual(hosts, expect) {
+		t.Fatalf("expected hosts: %v, got: %v", expect, hosts)
+	}
+}
+
+// TestCheckARPRunAsync checks whether the asynchronous scan mode picks up replies that arrive well after their
+// request was sent, both when searching for a single host and when collecting every host.
+func TestCheckARPRunAsync(t *testing.T) {
+	var builder netaddr.IPSetBuilder
+	builder.AddPrefix(netaddr.MustParseIPPrefix("192.168.33.0/20"))
+	ipSet, _ := builder.IPSet()
+
+	data := ctxData{network: ipSet, backoff: arpFuncBackoff, scanMode: scanModeAsync}
+
+	ac := mkDummyARP(netaddr.MustParseIP("192.168.40.2"))
+	ac.latency = 50 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	ip, err := checkARPRun(ctx, ac, data)
+	if err != nil {
+		t.Fatalf("error encountered while running test: %s", err.Error())
+	}
+	if ip != ac.needle {
+		t.Fatalf("expected IP: %s, got: %s", ac.needle.String(), ip.String())
+	}
+
+	macA, _ := net.ParseMAC("3e:50:6e:54:28:3d")
+	macB, _ := net.ParseMAC("3e:50:6e:54:28:3e")
+
+	hostsAC := mkDummyARPHosts(map[netaddr.IP]net.HardwareAddr{
+		netaddr.MustParseIP("192.168.40.2"):  macB,
+		netaddr.MustParseIP("192.168.33.44"): macA,
+		netaddr.MustParseIP("10.0.33.44"):    macA,
+	})
+	hostsAC.latency = 50 * time.Millisecond
+
+	hosts, err := checkARPRunCollect(ctx, hostsAC, data)
+	if err != nil {
+		t.Fatalf("error encountered while running test: %s", err.Error())
+	}
+
+	expect := []IP{
+		{mac: macA, IP: netaddr.MustParseIP("192.168.33.44")},
+		{mac: macB, IP: netaddr.MustParseIP("192.168.40.2")},
+	}
+	if !reflect.DeepEqual(hosts, expect) {
+		t.Fatalf("expected hosts: %v, got: %v", expect, hosts)
+	}
+}
+
+// TestCheckARPRunPassive checks whether passive mode finds a host that announces itself without sending any
+// requests.
+func TestCheckARPRunPassive(t *testing.T) {
+	var builder netaddr.IPSetBuilder
+	builder.AddPrefix(netaddr.MustParseIPPrefix("192.168.33.0/24"))
+	ipSet, _ := builder.IPSet()
+
+	ac := mkDummyARP(netaddr.MustParseIP("192.168.33.250"))
+	ac.latency = 50 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	ip, err := checkARPRun(ctx, ac, ctxData{network: ipSet, backoff: arpFuncBackoff, passive: true})
+	if err != nil {
+		t.Fatalf("error encountered while running test: %s", err.Error())
+	}
+	if ip != ac.needle {
+		t.Fatalf("expected IP: %s, got: %s", ac.needle.String(), ip.String())
+	}
+	if requests := atomic.LoadInt64(&ac.requests); requests != 0 {
+		t.Fatalf("passive lookup sent %d requests", requests)
+	}
+
+	// Hosts outside of the network are ignored
+	ac = mkDummyARP(netaddr.MustParseIP("10.0.33.250"))
+
+	ctx, cancel = context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+
+	if _, err := checkARPRun(ctx, ac, ctxData{network: ipSet, backoff: arpFuncBackoff, passive: true}); !errors.Is(err, ErrTimedOut) {
+		t.Fatalf("expected error: %v, got: %v", ErrTimedOut, err)
+	}
+}
+
+// TestCheckARPRunAny checks whether the search that finds the host is reported, and that the others are
+// cancelled rather than left to run until the context expires.
+func TestCheckARPRunAny(t *testing.T) {
+	networks := []*netaddr.IPSet{}
+	for _, prefix := range []string{"192.168.33.0/24", "192.168.40.0/24", "10.0.33.0/24"} {
+		var builder netaddr.IPSetBuilder
+		builder.AddPrefix(netaddr.MustParseIPPrefix(prefix))
+		ipSet, _ := builder.IPSet()
+		networks = append(networks, ipSet)
+	}
+
+	searches := []ctxData{}
+	for _, network := range networks {
+		searches = append(searches, ctxData{network: network, backoff: arpFuncBackoff})
+	}
+
+	needle := netaddr.MustParseIP("192.168.40.2")
+	var cancelled int64
+	run := func(ctx context.Context, data ctxData) (netaddr.IP, error) {
+		ip, err := checkARPRun(ctx, mkDummyARP(needle), data)
+		if ctx.Err() == context.Canceled {
+			atomic.AddInt64(&cancelled, 1)
+		}
+		return ip, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	ip, index, err := checkARPRunAny(ctx, searches, run)
+	if err != nil {
+		t.Fatalf("error encountered while running test: %s", err.Error())
+	}
+	if ip != needle || index != 1 {
+		t.Fatalf("expected IP: %s from search 1, got: %s from search %d", needle.String(), ip.String(), index)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("remaining searches were not cancelled, took \"%s\"", elapsed.String())
+	}
+	if n := atomic.LoadInt64(&cancelled); n != 2 {
+		t.Fatalf("expected 2 searches to be cancelled, got: %d", n)
+	}
+
+	// Errors other than not finding the host take precedence
+	failed := fmt.Errorf("interface failed")
+	_, _, err = checkARPRunAny(ctx, searches, func(ctx context.Context, data ctxData) (netaddr.IP, error) {
+		if data.network == networks[2] {
+			return netaddr.IP{}, failed
+		}
+		return netaddr.IP{}, errNoIP
+	})
+	if err != failed {
+		t.Fatalf("expected error: %v, got: %v", failed, err)
+	}
+}
+
+// TestCheckARPRunMaxAttempts checks whether checkARPRun and checkARPRunAll give up once data.maxAttempts sweeps
+// have been made, well before the context expires.
+func TestCheckARPRunMaxAttempts(t *testing.T) {
+	var builder netaddr.IPSetBuilder
+	builder.AddPrefix(netaddr.MustParseIPPrefix("192.168.34.0/30"))
+	ipSet, _ := builder.IPSet()
+
+	data := ctxData{network: ipSet, backoff: 20 * time.Millisecond, maxAttempts: 3}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	ip, err := checkARPRun(ctx, mkDummyARP(netaddr.MustParseIP("10.0.33.44")), data)
+	if err != errNoIP || errors.Is(err, ErrTimedOut) {
+		t.Fatalf("expected errNoIP from checkARPRun, got: %v", err)
+	}
+	if !ip.IsZero() {
+		t.Fatalf("expected empty IP, got: %s", ip.String())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("checkARPRun did not stop after %d attempts, took \"%s\"", data.maxAttempts, elapsed.String())
+	}
+
+	mac, _ := net.ParseMAC("3e:50:6e:54:28:3d")
+	start = time.Now()
+	_, err = checkARPRunAll(ctx, mkDummyARPHosts(map[netaddr.IP]net.HardwareAddr{}), mkMACSet(mac), data)
+	var missing missingError
+	if !errors.As(err, &missing) || len(missing.macs) != 1 {
+		t.Fatalf("expected missingError from checkARPRunAll, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("checkARPRunAll did not stop after %d attempts, took \"%s\"", data.maxAttempts, elapsed.String())
+	}
+}
+
+// TestSearchMerge checks whether search settings set by a data source override the provider's, and unset ones
+// fall back to them.
+func TestSearchMerge(t *testing.T) {
+	ctx := context.Background()
+
+	defaults, err := defaultSearch().merge(ctx, searchConfig{
+		Timeout:     types.String{Value: "1m"},
+		MaxAttempts: types.Int64{Value: 4},
+		Backoff:     types.String{Null: true},
+		WarmCache:   types.Bool{Null: true},
+	})
+	if err != nil {
+		t.Fatalf("error encountered while merging provider settings: %s", err.Error())
+	}
+
+	search, err := defaults.merge(ctx, searchConfig{
+		Timeout:        types.String{Null: true},
+		RequestTimeout: types.String{Value: "250ms"},
+		MaxAttempts:    types.Int64{Value: 2},
+		WarmCache:      types.Bool{Value: false},
+		CacheState:     types.String{Value: "permanent"},
+	})
+	if err != nil {
+		t.Fatalf("error encountered while merging data source settings: %s", err.Error())
+	}
+
+	expect := defaultSearch()
+	expect.timeout = time.Minute
+	expect.requestTimeout = 250 * time.Millisecond
+	expect.maxAttempts = 2
+	expect.skipCache = true
+	expect.cacheState = neighPermanent
+	if !reflect.DeepEqual(search, expect) {
+		t.Fatalf("expected search settings: %+v, got: %+v", expect, search)
+	}
+
+	if _, err := defaults.merge(ctx, searchConfig{RequestTimeout: types.String{Value: "soon"}}); err == nil {
+		t.Fatalf("expected an error merging an invalid duration")
+	}
+
+	if _, err := defaults.merge(ctx, searchConfig{CacheState: types.String{Value: "delay"}}); err == nil {
+		t.Fatalf("expected an error merging a state hosts can't be cached in")
+	}
+}
+
+// TestSelectSourceIP checks whether requests are sent from the requested address, or otherwise the interface
+// address in the searched network, skipping IPv6 addresses.
+func TestSelectSourceIP(t *testing.T) {
+	prefixes := []netaddr.IPPrefix{
+		netaddr.MustParseIPPrefix("fe80::1/64"),
+		netaddr.MustParseIPPrefix("192.168.1.10/24"),
+		netaddr.MustParseIPPrefix("10.18.6.1/24"),
+	}
+
+	var builder netaddr.IPSetBuilder
+	builder.AddPrefix(netaddr.MustParseIPPrefix("10.18.6.128/25"))
+	network, _ := builder.IPSet()
+
+	testcases := []struct {
+		prefixes []netaddr.IPPrefix
+		want     netaddr.IP
+		network  *netaddr.IPSet
+		expect   netaddr.IP
+		err      error
+	}{
+		{prefixes: prefixes, network: network, expect: netaddr.MustParseIP("10.18.6.1")},
+		{prefixes: prefixes, expect: netaddr.MustParseIP("192.168.1.10")},
+		{prefixes: prefixes, network: &netaddr.IPSet{}, expect: netaddr.MustParseIP("192.168.1.10")},
+		{prefixes: prefixes, want: netaddr.MustParseIP("192.168.1.10"), network: network,
+			expect: netaddr.MustParseIP("192.168.1.10")},
+		{prefixes: prefixes, want: netaddr.MustParseIP("192.168.1.11"), err: ErrNoSourceAddress},
+		{prefixes: prefixes[:1], err: ErrNoSourceAddress},
+	}
+
+	for _, test := range testcases {
+		ip, err := selectSourceIP(test.prefixes, test.want, test.network)
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error: %v, got: %v", test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("error encountered while running test: %s", err.Error())
+		}
+		if ip != test.expect {
+			t.Fatalf("expected IP: %s, got: %s", test.expect.String(), ip.String())
+		}
+	}
+}
+
+// TestPickInterface checks whether an interface is only picked when every searched address is directly connected
+// to it.
+func TestPickInterface(t *testing.T) {
+	a := netaddr.MustParseIP("10.18.6.0")
+	b := netaddr.MustParseIP("10.18.6.255")
+
+	testcases := []struct {
+		hops   []routeHop
+		expect int
+		err    error
+	}{
+		{
+			hops:   []routeHop{{ip: a, linkIndex: 3, onLink: true}, {ip: b, linkIndex: 3, onLink: true}},
+			expect: 3,
+		},
+		{
+			hops: []routeHop{{ip: a, linkIndex: 3, onLink: true}, {ip: b, linkIndex: 4, onLink: true}},
+			err:  ErrInterfaceSelection,
+		},
+		{
+			hops: []routeHop{{ip: a, linkIndex: 3, onLink: true}, {ip: b, linkIndex: 3, onLink: false}},
+			err:  ErrInterfaceSelection,
+		},
+		{
+			err: ErrInterfaceSelection,
+		},
+	}
+
+	for _, test := range testcases {
+		index, err := pickInterface(test.hops)
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error: %v, got: %v", test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("error encountered while running test: %s", err.Error())
+		}
+		if index != test.expect {
+			t.Fatalf("expected interface: %d, got: %d", test.expect, index)
+		}
+	}
+}
+
+// TestParseProcARP checks whether the header and incomplete entries of an ARP table are skipped, and whether
+// permanent entries are reported as such.
+func TestParseProcARP(t *testing.T) {
+	table := `IP address       HW type     Flags       HW address            Mask     Device
+192.168.1.1      0x1         0x2         3e:50:6e:54:28:3d     *        br0
+192.168.1.2      0x1         0x0         00:00:00:00:00:00     *        br0
+192.168.1.3      0x1         0x6         3e:50:6e:54:28:3e     *        eth0
+`
+
+	entries, err := parseProcARP(strings.NewReader(table))
+	if err != nil {
+		t.Fatalf("error encountered while running test: %s", err.Error())
+	}
+
+	macA, _ := net.ParseMAC("3e:50:6e:54:28:3d")
+	macB, _ := net.ParseMAC("3e:50:6e:54:28:3e")
+
+	expect := []neighEntry{
+		{ip: netaddr.MustParseIP("192.168.1.1"), mac: macA, device: "br0", state: neighReachable},
+		{ip: netaddr.MustParseIP("192.168.1.3"), mac: macB, device: "eth0", state: neighPermanent},
+	}
+	if !reflect.DeepEqual(entries, expect) {
+		t.Fatalf("expected entries: %v, got: %v", expect, entries)
+	}
+}
+
+// TestNeighStates checks whether neighbour states are parsed from their names, and whether an empty set accepts
+// the default states.
+func TestNeighStates(t *testing.T) {
+	states, err := parseNeighStates([]string{"reachable", "Permanent"})
+	if err != nil {
+		t.Fatalf("error encountered while running test: %s", err.Error())
+	}
+
+	if !states.accepts(neighPermanent) || states.accepts(neighStale) {
+		t.Fatalf("unexpected states parsed: %#x", states)
+	}
+
+	var empty neighState
+	if !empty.accepts(neighStale) || empty.accepts(neighIncomplete) || empty.accepts(neighFailed) {
+		t.Fatalf("unexpected default states: %#x", defaultNeighStates)
+	}
+
+	if _, err := parseNeighStates([]string{"forgotten"}); err == nil {
+		t.Fatalf("expected error parsing unknown state")
+	}
+}
+
+// TestLookupIPRangeParallel checks whether a sweep spread across several workers still finds the host with a
+// desired MAC, and respects the rate limit it is given.
+func TestLookupIPRangeParallel(t *testing.T) {
+	var builder netaddr.IPSetBuilder
+	builder.AddPrefix(netaddr.MustParseIPPrefix("192.168.33.0/24"))
+	ipSet, _ := builder.IPSet()
+
+	testcases := []struct {
+		parallelism int
+		rateLimit   int
+		minimum     time.Duration
+	}{
+		{parallelism: 1},
+		{parallelism: 16},
+		{parallelism: 16, rateLimit: 1000, minimum: 200 * time.Millisecond},
+	}
+
+	for _, test := range testcases {
+		ac := mkDummyARP(netaddr.MustParseIP("192.168.33.250"))
+		ac.latency = 100 * time.Microsecond
+
+		chans := makeChannels()
+		data := ctxData{network: ipSet, parallelism: test.parallelism, rateLimit: test.rateLimit}
+
+		start := time.Now()
+		lookupIPRange(context.Background(), ac, data, chans, false)
+		elapsed := time.Since(start)
+		close(chans.stop)
+
+		select {
+		case ip := <-chans.results:
+			if ip.IP != ac.needle {
+				t.Fatalf("expected IP: %s, got: %s", ac.needle.String(), ip.String())
+			}
+		default:
+			t.Fatalf("no result found with parallelism %d", test.parallelism)
+		}
+
+		if elapsed < test.minimum {
+			t.Fatalf("sweep with rate limit %d took %s, should have taken at least %s",
+				test.rateLimit, elapsed.String(), test.minimum.String())
+		}
+	}
+}
+
+// BenchmarkLookupIPRange measures how long a sweep of a /22 takes as the number of workers grows.
+func BenchmarkLookupIPRange(b *testing.B) {
+	var builder netaddr.IPSetBuilder
+	builder.AddPrefix(netaddr.MustParseIPPrefix("192.168.32.0/22"))
+	ipSet, _ := builder.IPSet()
+
+	for _, parallelism := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("parallelism-%d", parallelism), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				ac := mkDummyARP(netaddr.MustParseIP("192.168.35.250"))
+				ac.latency = 100 * time.Microsecond
+
+				chans := makeChannels()
+				lookupIPRange(context.Background(), ac, ctxData{network: ipSet, parallelism: parallelism}, chans, false)
+				close(chans.stop)
+			}
+		})
+	}
+}
+
+// TestScanCoordinator checks whether concurrent lookups on the same network share a single sweep, including those
+// joining mid-sweep, and that the sweep ends once every lookup has returned. The sweep's client is only replaced when
+// the set of MACs it targets changes.
+func TestScanCoordinator(t *testing.T) {
+	var builder netaddr.IPSetBuilder
+	builder.AddPrefix(netaddr.MustParseIPPrefix("192.168.33.0/24"))
+	ipSet, _ := builder.IPSet()
+
+	hosts := map[netaddr.IP]net.HardwareAddr{
+		netaddr.MustParseIP("192.168.33.20"):  {0x3e, 0x50, 0x6e, 0x54, 0x28, 0x01},
+		netaddr.MustParseIP("192.168.33.200"): {0x3e, 0x50, 0x6e, 0x54, 0x28, 0x02},
+	}
+	ac := mkDummyARPHosts(hosts)
+	ac.latency = time.Millisecond
+
+	var mu sync.Mutex
+	clients := map[string]int{}
+	pool := mkClientPool()
+	pool.mkClient = func(data ctxData) arpClient {
+		mu.Lock()
+		clients[data.macs.key()]++
+		mu.Unlock()
+		return ac
+	}
+	scans := mkScanCoordinator(pool)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	data := ctxData{network: ipSet, backoff: arpFuncBackoff}
+	type lookup struct {
+		ip  netaddr.IP
+		mac net.HardwareAddr
+	}
+	// The host furthest into the sweep is looked for first, so the sweep is still running as the others join,
+	// including those for the host that has already replied
+	far, near := netaddr.MustParseIP("192.168.33.200"), netaddr.MustParseIP("192.168.33.20")
+	lookups := []lookup{{far, hosts[far]}, {near, hosts[near]}, {near, hosts[near]}, {far, hosts[far]}}
+
+	errs := make(chan error, len(lookups))
+	for i, l := range lookups {
+		// Later lookups join the sweep part way through
+		time.Sleep(time.Duration(i) * 15 * time.Millisecond)
+
+		go func(l lookup) {
+			ip, err := scans.lookup(ctx, l.mac, data)
+			if err == nil && ip != l.ip {
+				err = fmt.Errorf("expected IP: %s for %s, got: %s", l.ip, l.mac, ip)
+			}
+			errs <- err
+		}(l)
+	}
+
+	for range lookups {
+		if err := <-errs; err != nil {
+			t.Fatalf("error encountered while running test: %s", err.Error())
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if clients[hosts[far].String()] != 1 {
+		t.Fatalf("expected the sweep to start with a client targeting %s, got: %v", hosts[far], clients)
+	}
+	for macs, n := range clients {
+		if n != 1 {
+			t.Fatalf("expected 1 client for %s, got: %d", macs, n)
+		}
+	}
+	if n := atomic.LoadInt64(&ac.requests); n > 256 {
+		t.Fatalf("expected at most one sweep of 256 requests, got: %d", n)
+	}
+
+	scans.mu.Lock()
+	defer scans.mu.Unlock()
+	if len(scans.scans) != 0 {
+		t.Fatalf("expected sweep to end once every lookup returned, %d still running", len(scans.scans))
+	}
+}
+
+// TestScanCoordinatorDeadline checks whether each lookup sharing a sweep gives up at its own deadline or attempt
+// limit, without ending the sweep for the others.
+func TestScanCoordinatorDeadline(t *testing.T) {
+	var builder netaddr.IPSetBuilder
+	builder.AddPrefix(netaddr.MustParseIPPrefix("192.168.33.0/24"))
+	ipSet, _ := builder.IPSet()
+
+	found := net.HardwareAddr{0x3e, 0x50, 0x6e, 0x54, 0x28, 0x01}
+	missing := net.HardwareAddr{0x3e, 0x50, 0x6e, 0x54, 0x28, 0x02}
+	ac := mkDummyARPHosts(map[netaddr.IP]net.HardwareAddr{netaddr.MustParseIP("192.168.33.250"): found})
+	ac.latency = time.Millisecond
+
+	pool := mkClientPool()
+	pool.mkClient = func(ctxData) arpClient { return ac }
+	scans := mkScanCoordinator(pool)
+
+	data := ctxData{network: ipSet, backoff: 50 * time.Millisecond}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := scans.lookup(ctx, found, data)
+		result <- err
+	}()
+
+	short, cancelShort := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancelShort()
+	start := time.Now()
+	if _, err := scans.lookup(short, missing, data); !errors.Is(err, ErrTimedOut) {
+		t.Fatalf("expected ErrTimedOut, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Fatalf("lookup did not respect its own deadline, took \"%s\"", elapsed.String())
+	}
+
+	limited := data
+	limited.maxAttempts = 1
+	if _, err := scans.lookup(ctx, missing, limited); !errors.Is(err, ErrNotFound) || errors.Is(err, ErrTimedOut) {
+		t.Fatalf("expected ErrNotFound after max_attempts, got: %v", err)
+	}
+
+	if err := <-result; err != nil {
+		t.Fatalf("expected the remaining lookup to succeed, got: %s", err.Error())
+	}
+}
+
+// TestScanKey checks whether lookups only share sweeps with others that would accept the same hosts and handle them
+// the same way, while settings that only pace the sweep don't keep them apart.
+func TestScanKey(t *testing.T) {
+	var builder netaddr.IPSetBuilder
+	builder.AddPrefix(netaddr.MustParseIPPrefix("192.168.33.0/24"))
+	ipSet, _ := builder.IPSet()
+
+	leases, err := mkLeaseFile(leaseFormatDnsmasq, "/var/lib/misc/dnsmasq.leases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := ctxData{network: ipSet, backoff: 50 * time.Millisecond, sources: []lookupSource{leases}}
+
+	testcases := []struct {
+		name   string
+		modify func(*ctxData)
+		shared bool
+	}{
+		{name: "backoff", modify: func(d *ctxData) { d.backoff = time.Second }, shared: true},
+		{name: "parallelism", modify: func(d *ctxData) { d.parallelism = 4 }, shared: true},
+		{name: "recreated sources", modify: func(d *ctxData) {
+			same, _ := mkLeaseFile(leaseFormatDnsmasq, "/var/lib/misc/dnsmasq.leases")
+			d.sources = []lookupSource{same}
+		}, shared: true},
+		{name: "accepted states", modify: func(d *ctxData) { d.acceptStates = neighReachable }},
+		{name: "skip cache", modify: func(d *ctxData) { d.skipCache = true }},
+		{name: "cache state", modify: func(d *ctxData) { d.cacheState = neighStale }},
+		{name: "scan mode", modify: func(d *ctxData) { d.scanMode = scanModeAsync }},
+		{name: "transport", modify: func(d *ctxData) { d.transport = transportRing }},
+		{name: "request timeout", modify: func(d *ctxData) { d.requestTimeout = time.Second }},
+		{name: "sources", modify: func(d *ctxData) { d.sources = nil }},
+		{name: "helper", modify: func(d *ctxData) { d.helper = &privhelper.Client{} }},
+	}
+
+	for _, test := range testcases {
+		data := base
+		test.modify(&data)
+		if shared := scanKey(data) == scanKey(base); shared != test.shared {
+			t.Fatalf("%s: expected sweeps to be shared: %t, got %t", test.name, test.shared, shared)
+		}
+	}
+}
+
+// countingARP is a dummyARP that counts how often it is initialised and destroyed.
+type countingARP struct {
+	*dummyARP
+	inits, destroys int64
+	broken          int32 // whether the client reports having failed, set atomically
+}
+
+func (ac *countingARP) failed() bool {
+	return atomic.LoadInt32(&ac.broken) != 0
+}
+
+func (ac *countingARP) init(*net.Interface) error {
+	atomic.AddInt64(&ac.inits, 1)
+	return nil
+}
+
+func (ac *countingARP) destroy() error {
+	atomic.AddInt64(&ac.destroys, 1)
+	return nil
+}
+
+// TestClientPool checks whether sweeps with the same settings share a single client, which is destroyed once the
+// last of them releases it, while sweeps with different settings get their own.
+func TestClientPool(t *testing.T) {
+	var builder netaddr.IPSetBuilder
+	builder.AddPrefix(netaddr.MustParseIPPrefix("192.168.33.0/24"))
+	ipSet, _ := builder.IPSet()
+
+	created := []*countingARP{}
+	pool := mkClientPool()
+	pool.mkClient = func(ctxData) arpClient {
+		ac := &countingARP{dummyARP: mkDummyARP(netaddr.IP{})}
+		created = append(created, ac)
+		return ac
+	}
+
+	data := ctxData{network: ipSet, sourceIP: netaddr.MustParseIP("192.168.33.1"), requestTimeout: time.Second}
+	other := data
+	other.sourceIP = netaddr.MustParseIP("192.168.33.2")
+
+	first, releaseFirst, err := pool.acquire(data)
+	if err != nil {
+		t.Fatalf("error encountered while acquiring client: %s", err.Error())
+	}
+	second, releaseSecond, err := pool.acquire(data)
+	if err != nil {
+		t.Fatalf("error encountered while acquiring client: %s", err.Error())
+	}
+	_, releaseOther, err := pool.acquire(other)
+	if err != nil {
+		t.Fatalf("error encountered while acquiring client: %s", err.Error())
+	}
+
+	if first != second {
+		t.Fatalf("expected sweeps with the same settings to share a client")
+	}
+	if len(created) != 2 {
+		t.Fatalf("expected 2 clients to be created, got: %d", len(created))
+	}
+
+	shared := created[0]
+	releaseFirst()
+	releaseFirst()
+	if n := atomic.LoadInt64(&shared.destroys); n != 0 {
+		t.Fatalf("expected client to stay open while in use, destroyed %d times", n)
+	}
+	releaseSecond()
+	releaseOther()
+
+	for _, ac := range created {
+		if ac.inits != 1 || ac.destroys != 1 {
+			t.Fatalf("expected each client to be initialised and destroyed once, got: %d and %d", ac.inits, ac.destroys)
+		}
+	}
+	if len(pool.clients) != 0 {
+		t.Fatalf("expected released clients to leave the pool, %d remain", len(pool.clients))
+	}
+
+	// Clients only share a socket with sweeps for the same hosts, so that requests can be unicast to them
+	target := net.HardwareAddr{0x3e, 0x50, 0x6e, 0x54, 0x28, 0x01}
+	targeted := data
+	targeted.macs = mkMACSet(target)
+	if clientKey(targeted) == clientKey(data) {
+		t.Fatalf("expected sweeps for different hosts not to share a client")
+	}
+
+	var targets macSet
+	switch ac := mkClientPool().mkClient(targeted).(type) {
+	case *linuxARP:
+		targets = ac.targets
+	case *linuxProbe:
+		targets = ac.targets
+	default:
+		t.Fatalf("unexpected client %T", ac)
+	}
+	if !bytes.Equal(targets.target(), target) {
+		t.Fatalf("expected pooled client to target %s, got: %s", target, targets.target())
+	}
+}
+
+// TestClientPoolFailed checks whether a client that has failed is no longer shared, while sweeps already holding it
+// keep it until they release it.
+func TestClientPoolFailed(t *testing.T) {
+	var builder netaddr.IPSetBuilder
+	builder.AddPrefix(netaddr.MustParseIPPrefix("192.168.33.0/24"))
+	ipSet, _ := builder.IPSet()
+
+	created := []*countingARP{}
+	pool := mkClientPool()
+	pool.mkClient = func(ctxData) arpClient {
+		ac := &countingARP{dummyARP: mkDummyARP(netaddr.IP{})}
+		created = append(created, ac)
+		return ac
+	}
+
+	data := ctxData{network: ipSet, sourceIP: netaddr.MustParseIP("192.168.33.1")}
+
+	failed, releaseFailed, err := pool.acquire(data)
+	if err != nil {
+		t.Fatalf("error encountered while acquiring client: %s", err.Error())
+	}
+	atomic.StoreInt32(&created[0].broken, 1)
+
+	replaced, releaseReplaced, err := pool.acquire(data)
+	if err != nil {
+		t.Fatalf("error encountered while acquiring client: %s", err.Error())
+	}
+	if replaced == failed {
+		t.Fatalf("expected a failed client to be replaced")
+	}
+
+	releaseFailed()
+	if n := atomic.LoadInt64(&created[1].destroys); n != 0 {
+		t.Fatalf("expected replacement to stay open when the failed client is released, destroyed %d times", n)
+	}
+	releaseReplaced()
+
+	for _, ac := range created {
+		if ac.inits != 1 || ac.destroys != 1 {
+			t.Fatalf("expected each client to be initialised and destroyed once, got: %d and %d", ac.inits, ac.destroys)
+		}
+	}
+	if len(pool.clients) != 0 {
+		t.Fatalf("expected released clients to leave the pool, %d remain", len(pool.clients))
+	}
+}
+
+// TestReplyDemuxShared checks whether a reply is delivered to every request waiting on its IP, as happens when
+// sweeps share a client.
+func TestReplyDemuxShared(t *testing.T) {
+	var demux replyDemux
+	ip := netaddr.MustParseIP("192.168.33.20")
+	reply := IP{mac: net.HardwareAddr{0x3e, 0x50, 0x6e, 0x54, 0x28, 0x01}, IP: ip}
+
+	var waiting sync.WaitGroup
+	waiting.Add(2)
+	results := make(chan IP, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			result, _ := demux.await(context.Background(), ip, time.Second, func() error {
+				waiting.Done()
+				return nil
+			})
+			results <- result
+		}()
+	}
+
+	waiting.Wait()
+	demux.deliver(reply)
+
+	for i := 0; i < 2; i++ {
+		if result := <-results; result.IP != ip {
+			t.Fatalf("expected every request to receive the reply from %s, got: %s", ip, result.IP)
+		}
+	}
+
+	if len(demux.pending) != 0 {
+		t.Fatalf("expected requests to stop waiting once answered, %d remain", len(demux.pending))
+	}
+}
+
+// checkGoroutines fails the test if more goroutines are running than when it was called, dumping their stacks.
+// It's called as soon as a lookup returns, as every goroutine the lookup started should have exited by then.
+func checkGoroutines(t *testing.T) func() {
+	before := runtime.NumGoroutine()
+
+	return func() {
+		t.Helper()
+
+		if after := runtime.NumGoroutine(); after > before {
+			buf := make([]byte, 1<<16)
+			buf = buf[:runtime.Stack(buf, true)]
+			t.Fatalf("expected %d goroutines once the lookup returned, got: %d\n%s", before, after, buf)
+		}
+	}
+}
+
+// TestCheckARPRunLeak checks whether checkARPRun waits for every goroutine it starts before returning, whether the
+// host is found, its context expires or it runs out of attempts, and that sweeps slower than the backoff period
+// are not started again before they finish.
+func TestCheckARPRunLeak(t *testing.T) {
+	var builder netaddr.IPSetBuilder
+	builder.AddPrefix(netaddr.MustParseIPPrefix("192.168.33.0/24"))
+	ipSet, _ := builder.IPSet()
+
+	testcases := []struct {
+		name        string
+		needle      netaddr.IP
+		timeout     time.Duration
+		maxAttempts int
+		expect      error
+	}{
+		{name: "found", needle: netaddr.MustParseIP("192.168.33.30"), timeout: time.Second},
+		{name: "timeout", needle: netaddr.MustParseIP("10.0.33.44"), timeout: 100 * time.Millisecond, expect: ErrTimedOut},
+		{name: "attempts", needle: netaddr.MustParseIP("10.0.33.44"), timeout: time.Second, maxAttempts: 5, expect: ErrNotFound},
+	}
+
+	for _, test := range testcases {
+		t.Run(test.name, func(t *testing.T) {
+			ac := mkDummyARP(test.needle)
+			ac.latency = time.Millisecond
+
+			ctx, cancel := context.WithTimeout(context.Background(), test.timeout)
+			defer cancel()
+
+			check := checkGoroutines(t)
+			_, err := checkARPRun(ctx, ac, ctxData{network: ipSet, backoff: 5 * time.Millisecond, maxAttempts: test.maxAttempts})
+			check()
+
+			if !errors.Is(err, test.expect) && !(test.expect == nil && err == nil) {
+				t.Fatalf("expected error: %v, got: %v", test.expect, err)
+			}
+			if n := atomic.LoadInt64(&ac.requests); n > 254 {
+				t.Fatalf("expected at most one sweep of 254 requests to run at a time, got: %d", n)
+			}
+		})
+	}
+}
+
+// TestCheckARPRunCancelRequest checks whether a request waiting on a reply is abandoned once the lookup's context
+// expires, rather than holding up its return.
+func TestCheckARPRunCancelRequest(t *testing.T) {
+	var builder netaddr.IPSetBuilder
+	builder.AddPrefix(netaddr.MustParseIPPrefix("192.168.33.0/24"))
+	ipSet, _ := builder.IPSet()
+
+	ac := mkDummyARP(netaddr.MustParseIP("10.0.33.44"))
+	ac.latency = 5 * time.Second
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	check := checkGoroutines(t)
+	start := time.Now()
+	if _, err := checkARPRun(ctx, ac, ctxData{network: ipSet, backoff: arpFuncBackoff, parallelism: 4}); !errors.Is(err, ErrTimedOut) {
+		t.Fatalf("expected ErrTimedOut, got: %v", err)
+	}
+	check()
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("checkARPRun waited on requests after its context expired, took \"%s\"", elapsed.String())
+	}
+}
+
+// TestCheckARPRunAllLeak checks whether checkARPRunAll and checkARPRunCollect wait for every goroutine they start
+// before returning.
+func TestCheckARPRunAllLeak(t *testing.T) {
+	var builder netaddr.IPSetBuilder
+	builder.AddPrefix(netaddr.MustParseIPPrefix("192.168.33.0/24"))
+	ipSet, _ := builder.IPSet()
+
+	mac := net.HardwareAddr{0x3e, 0x50, 0x6e, 0x54, 0x28, 0x01}
+	missing := net.HardwareAddr{0x3e, 0x50, 0x6e, 0x54, 0x28, 0x02}
+	hosts := map[netaddr.IP]net.HardwareAddr{netaddr.MustParseIP("192.168.33.20"): mac}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	ac := mkDummyARPHosts(hosts)
+	ac.latency = time.Millisecond
+	check := checkGoroutines(t)
+	checkARPRunAll(ctx, ac, mkMACSet(mac, missing), ctxData{network: ipSet, backoff: 5 * time.Millisecond})
+	check()
+
+	ac = mkDummyARPHosts(hosts)
+	check = checkGoroutines(t)
+	if _, err := checkARPRunCollect(context.Background(), ac, ctxData{network: ipSet}); err != nil {
+		t.Fatalf("error encountered while collecting hosts: %s", err.Error())
+	}
+	check()
+}
+
+// TestVerifyIPForPassive checks whether a cached IP is verified from the system's neighbour table alone, without
+// sending a request, for passive and DHCP snooping lookups.
+func TestVerifyIPForPassive(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("no loopback interface: %s", err.Error())
+	}
+
+	ip := netaddr.MustParseIP("127.0.0.2")
+	mac := net.HardwareAddr{0x3e, 0x50, 0x6e, 0x54, 0x28, 0x01}
+	ac := mkDummyARPHosts(map[netaddr.IP]net.HardwareAddr{ip: mac})
+
+	pool := mkClientPool()
+	pool.mkClient = func(ctxData) arpClient { return ac }
+
+	for _, data := range []ctxData{{iface: lo, passive: true}, {iface: lo, dhcpSnoop: true}} {
+		verified, err := verifyIPFor(context.Background(), pool, mac, ip, data)
+		if err != nil {
+			t.Fatalf("error encountered while verifying cached IP: %s", err.Error())
+		}
+		// The loopback interface has no neighbour table entries, so only a request would have found the host
+		if verified {
+			t.Fatal("expected cached IP not to be verified without a neighbour table entry")
+		}
+	}
+
+	if requests := atomic.LoadInt64(&ac.requests); requests != 0 {
+		t.Fatalf("expected no requests to be sent, got %d", requests)
+	}
+}
@@ -0,0 +1,173 @@
+package arplookup
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/vishvananda/netlink"
+)
+
+// vlanLinkName returns the name of the temporary sub-interface created for tagged lookups on VLAN id of the
+// interface with index parent, which fits within the kernel's 15 characters for any index and VLAN.
+func vlanLinkName(parent int, id int) string {
+	return fmt.Sprintf("arpl%d.%d", parent, id)
+}
+
+// vlanKey identifies the sub-interface for a VLAN of a parent interface, of which the kernel allows only one.
+type vlanKey struct {
+	parent int
+	id     int
+}
+
+// vlanLinks shares the sub-interfaces used by concurrent lookups on the same VLAN, so that a temporary one is only
+// removed once the last lookup using it is done, and is never mistaken for one that existed beforehand.
+type vlanLinks struct {
+	mu    sync.Mutex
+	links map[vlanKey]*vlanLink // sub-interfaces in use, guarded by mu
+}
+
+// vlanLink is a sub-interface used by refs lookups. link is only set if it was created by the provider, so must be
+// removed once they are done.
+type vlanLink struct {
+	iface *net.Interface
+	link  *netlink.Vlan
+	refs  int
+}
+
+func mkVLANLinks() *vlanLinks {
+	return &vlanLinks{links: map[vlanKey]*vlanLink{}}
+}
+
+// attachVLAN replaces data.iface with its 802.1Q sub-interface for data.vlanID, if set, shared with other lookups
+// through data.vlans. An existing sub-interface is used if there is one, and otherwise a temporary one is created
+// with data.sourceIP assigned to it. The returned function releases the sub-interface, removing it once it was
+// created for these lookups and none are still using it.
+func attachVLAN(data *ctxData) (func(), error) {
+	if data.vlanID == 0 {
+		return func() {}, nil
+	}
+
+	links := data.vlans
+	if links == nil {
+		links = mkVLANLinks()
+	}
+	key := vlanKey{parent: data.iface.Index, id: data.vlanID}
+
+	// The lock is held while the sub-interface is created or removed, so that no lookup sees it half made
+	links.mu.Lock()
+	defer links.mu.Unlock()
+
+	vl, ok := links.links[key]
+	if !ok {
+		var err error
+		vl, err = links.open(data)
+		if err != nil {
+			return nil, err
+		}
+		links.links[key] = vl
+	}
+	vl.refs++
+
+	data.iface = vl.iface
+	if vl.link != nil {
+		// The sub-interface only has a host route, and entries added to its neighbour table are removed with it
+		data.skipCache = true
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { links.release(key, vl) })
+	}, nil
+}
+
+// open finds the existing sub-interface for data.vlanID, or otherwise creates a temporary one. The lock must be
+// held.
+func (links *vlanLinks) open(data *ctxData) (*vlanLink, error) {
+	all, err := netlink.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list network interfaces: %w", err)
+	}
+
+	for _, link := range all {
+		vlan, ok := link.(*netlink.Vlan)
+		if !ok || vlan.ParentIndex != data.iface.Index || vlan.VlanId != data.vlanID {
+			continue
+		}
+
+		iface, err := net.InterfaceByIndex(vlan.Attrs().Index)
+		if err != nil {
+			return nil, err
+		}
+
+		return &vlanLink{iface: iface}, nil
+	}
+
+	// Without an address on the VLAN, requests would have nothing to be sent from
+	if data.sourceIP.IsZero() {
+		return nil, &LookupError{
+			Kind: ErrNoSourceAddress,
+			Detail: fmt.Sprintf("%s has no sub-interface for VLAN %d, so `source_ip` must be set to send requests from",
+				data.iface.Name, data.vlanID),
+		}
+	}
+
+	link := &netlink.Vlan{
+		LinkAttrs: netlink.LinkAttrs{Name: vlanLinkName(data.iface.Index, data.vlanID), ParentIndex: data.iface.Index},
+		VlanId:    data.vlanID,
+	}
+	addr := &netlink.Addr{IPNet: &net.IPNet{IP: data.sourceIP.IPAddr().IP, Mask: net.CIDRMask(32, 32)}}
+
+	err = withNetAdmin(func() error {
+		if err := netlink.LinkAdd(link); err != nil {
+			return fmt.Errorf("unable to add sub-interface for VLAN %d on %s: %w", data.vlanID, data.iface.Name, err)
+		}
+
+		if err := netlink.AddrAdd(link, addr); err != nil {
+			netlink.LinkDel(link)
+			return fmt.Errorf("unable to assign %s to %s: %w", data.sourceIP.String(), link.Name, err)
+		}
+
+		if err := netlink.LinkSetUp(link); err != nil {
+			netlink.LinkDel(link)
+			return fmt.Errorf("unable to bring up %s: %w", link.Name, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	iface, err := net.InterfaceByName(link.Name)
+	if err != nil {
+		removeVLANLink(link)
+		return nil, err
+	}
+
+	return &vlanLink{iface: iface, link: link}, nil
+}
+
+// release drops a lookup's reference to vl, removing it once no lookup is using it if it was created by the
+// provider.
+func (links *vlanLinks) release(key vlanKey, vl *vlanLink) {
+	links.mu.Lock()
+	defer links.mu.Unlock()
+
+	vl.refs--
+	if vl.refs > 0 {
+		return
+	}
+
+	delete(links.links, key)
+	if vl.link != nil {
+		removeVLANLink(vl.link)
+	}
+}
+
+// removeVLANLink deletes a temporary sub-interface.
+func removeVLANLink(link *netlink.Vlan) {
+	withNetAdmin(func() error {
+		return netlink.LinkDel(link)
+	})
+}
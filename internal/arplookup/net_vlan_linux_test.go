@@ -0,0 +1,60 @@
+package arplookup
+
+import (
+	"math/rand"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"inet.af/netaddr"
+)
+
+// TestAttachVLANShared checks whether concurrent lookups on the same VLAN share the temporary sub-interface created
+// for it, which is only removed once the last of them is done. It needs the environment of the acceptance tests.
+func TestAttachVLANShared(t *testing.T) {
+	if os.Getenv("TF_ACC") == "" {
+		t.Skip("TF_ACC must be set to create sub-interfaces on the test network")
+	}
+
+	if err := driver.Init(rand.New(rand.NewSource(time.Now().UTC().Unix()))); err != nil {
+		t.Fatalf("unable to init test driver: %s", err.Error())
+	}
+
+	parent, err := net.InterfaceByName("br0")
+	if err != nil {
+		t.Fatalf("unable to find test network: %s", err.Error())
+	}
+
+	data := ctxData{iface: parent, vlanID: 3999, sourceIP: netaddr.MustParseIP("10.18.250.1"), vlans: mkVLANLinks()}
+
+	first := data
+	detachFirst, err := attachVLAN(&first)
+	if err != nil {
+		t.Fatalf("error encountered while attaching VLAN: %s", err.Error())
+	}
+	second := data
+	detachSecond, err := attachVLAN(&second)
+	if err != nil {
+		detachFirst()
+		t.Fatalf("error encountered while attaching VLAN a second time: %s", err.Error())
+	}
+
+	if first.iface.Index == parent.Index || first.iface.Index != second.iface.Index {
+		detachFirst()
+		detachSecond()
+		t.Fatalf("expected both lookups to share a sub-interface, got %s and %s", first.iface.Name, second.iface.Name)
+	}
+
+	detachFirst()
+	detachFirst()
+	if _, err := net.InterfaceByIndex(second.iface.Index); err != nil {
+		detachSecond()
+		t.Fatalf("expected sub-interface to stay while in use: %s", err.Error())
+	}
+
+	detachSecond()
+	if _, err := net.InterfaceByName(first.iface.Name); err == nil {
+		t.Fatalf("expected sub-interface to be removed once released")
+	}
+}
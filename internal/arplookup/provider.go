@@ -3,12 +3,16 @@ package arplookup
 import (
 	"context"
 	"fmt"
+	"net"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/terraform-plugin-framework/diag"
 	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 	"inet.af/netaddr"
+	"terraform-provider-arplookup/internal/privhelper"
 )
 
 var _ tfsdk.Provider = &provider{}
@@ -17,7 +21,7 @@ func (p *provider) GetSchema(ctx context.Context) (tfsdk.Schema, diag.Diagnostic
 	return tfsdk.Schema{
 		Attributes: map[string]tfsdk.Attribute{
 			"network": {
-				MarkdownDescription: "Network CIDR to search for.",
+				MarkdownDescription: "Network CIDR to search for. IPv6 prefixes must be `/112` or longer.",
 				Optional:            true,
 				Type: types.ListType{
 					ElemType: types.StringType,
@@ -47,58 +51,354 @@ Global attribute that can be overidden by being set in data sources.`,
 					timeValidator{},
 				},
 			},
+			"request_timeout": {
+				MarkdownDescription: `How long to wait for a reply to each request sent while scanning ` + "`network`" + `. Defaults to 1ms.
+Global attribute that can be overidden by being set in data sources.`,
+				Optional: true,
+				Type:     types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					timeValidator{},
+				},
+			},
+			"max_attempts": {
+				MarkdownDescription: `How many scans of ` + "`network`" + ` to make before giving up, if ` + "`timeout`" + ` has not expired first. Unlimited by default.
+Global attribute that can be overidden by being set in data sources.`,
+				Optional: true,
+				Type:     types.Int64Type,
+				Validators: []tfsdk.AttributeValidator{
+					positiveIntValidator{},
+				},
+			},
+			"parallelism": {
+				MarkdownDescription: `How many hosts to send requests to concurrently while scanning ` + "`network`" + `. Defaults to 1.
+Global attribute that can be overidden by being set in data sources.`,
+				Optional: true,
+				Type:     types.Int64Type,
+				Validators: []tfsdk.AttributeValidator{
+					positiveIntValidator{},
+				},
+			},
+			"rate_limit": {
+				MarkdownDescription: `Maximum number of requests to send per second while scanning ` + "`network`" + `. Unlimited by default.
+Global attribute that can be overidden by being set in data sources.`,
+				Optional: true,
+				Type:     types.Int64Type,
+				Validators: []tfsdk.AttributeValidator{
+					positiveIntValidator{},
+				},
+			},
+			"lease_files": {
+				MarkdownDescription: `DHCP server lease files to search for MAC addresses alongside the system's ARP cache.`,
+				Optional:            true,
+				Attributes: tfsdk.ListNestedAttributes(map[string]tfsdk.Attribute{
+					"format": {
+						MarkdownDescription: "Format of the lease file, one of `dnsmasq`, `isc` for ISC dhcpd or `kea` for Kea's CSV lease file.",
+						Required:            true,
+						Type:                types.StringType,
+						Validators: []tfsdk.AttributeValidator{
+							leaseFormatValidator{},
+						},
+					},
+					"path": {
+						MarkdownDescription: "Path to the lease file.",
+						Required:            true,
+						Type:                types.StringType,
+					},
+				}),
+			},
+			"helper": {
+				MarkdownDescription: `Path to the ` + "`arplookup-helper`" + ` binary. When set, ARP and DHCP sockets are opened by the helper, so only the helper needs the NET_RAW capability. IPv6 lookups and changes to the neighbour table are still performed by the provider itself.`,
+				Optional:            true,
+				Type:                types.StringType,
+			},
+			"warm_cache": {
+				MarkdownDescription: `Whether to add hosts found by sending requests to the system's neighbour table, which needs the NET_ADMIN capability and is skipped without it. Defaults to true.
+Global attribute that can be overidden by being set in data sources.`,
+				Optional: true,
+				Type:     types.BoolType,
+			},
+			"cache_state": {
+				MarkdownDescription: "State to add found hosts to the system's neighbour table in when `warm_cache` is true, one of `reachable`, `stale` or `permanent`. Defaults to `reachable`." + `
+Global attribute that can be overidden by being set in data sources.`,
+				Optional: true,
+				Type:     types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					cacheStateValidator{},
+				},
+			},
+			"cache_file": {
+				MarkdownDescription: "Path to a file remembering the IP each MAC was last found at, shared between Terraform runs. When set, `arplookup_ip` first checks the remembered IP with a single request, and only sweeps `network` if the host is no longer there.",
+				Optional:            true,
+				Type:                types.StringType,
+			},
+			"cache_ttl": {
+				MarkdownDescription: "How long IPs are remembered in `cache_file` for. Defaults to 1h.",
+				Optional:            true,
+				Type:                types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					timeValidator{},
+				},
+			},
+			"max_scans": {
+				MarkdownDescription: "Most sweeps of a network that may run at once across every data source. Lookups wait for a sweep to finish before starting their own, and the wait counts towards their `timeout`. Defaults to 4.",
+				Optional:            true,
+				Type:                types.Int64Type,
+				Validators: []tfsdk.AttributeValidator{
+					positiveIntValidator{},
+				},
+			},
+			"scan_mode": {
+				MarkdownDescription: `How to scan ` + "`network`" + `. ` + "`request`" + ` waits for a reply from each host in turn, while ` + "`async`" + ` sends requests to every host and collects replies as they arrive. Defaults to ` + "`request`" + `.
+Global attribute that can be overidden by being set in data sources.`,
+				Optional: true,
+				Type:     types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					scanModeValidator{},
+				},
+			},
+			"transport": {
+				MarkdownDescription: `How ARP packets are passed to and from the kernel. ` + "`socket`" + ` makes a system call for every packet, while ` + "`ring`" + ` exchanges them through memory-mapped rings shared with the kernel, which is faster when sweeping large networks with the ` + "`async`" + ` scan mode. As the kernel hands replies over in batches, each request waits at least 10ms for a reply with the ` + "`request`" + ` scan mode. ` + "`ring`" + ` can only be used with IPv4 networks. Defaults to ` + "`socket`" + `.
+Global attribute that can be overidden by being set in data sources.`,
+				Optional: true,
+				Type:     types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					transportValidator{},
+				},
+			},
 		},
 	}, nil
 }
 
 type provider struct {
-	configured bool
-	version    string
-	network    netaddr.IPSet
-	timeout    time.Duration
-	backoff    time.Duration
+	version string
+	mu      sync.Mutex
+	current *providerRuntime // built by Configure, or nil until the provider is configured
 }
 
 type providerData struct {
-	Network types.List   `tfsdk:"network"`
-	Timeout types.String `tfsdk:"timeout"`
-	Backoff types.String `tfsdk:"backoff"`
+	Network        types.List      `tfsdk:"network"`
+	Timeout        types.String    `tfsdk:"timeout"`
+	Backoff        types.String    `tfsdk:"backoff"`
+	RequestTimeout types.String    `tfsdk:"request_timeout"`
+	MaxAttempts    types.Int64     `tfsdk:"max_attempts"`
+	Parallelism    types.Int64     `tfsdk:"parallelism"`
+	RateLimit      types.Int64     `tfsdk:"rate_limit"`
+	ScanMode       types.String    `tfsdk:"scan_mode"`
+	Transport      types.String    `tfsdk:"transport"`
+	WarmCache      types.Bool      `tfsdk:"warm_cache"`
+	CacheState     types.String    `tfsdk:"cache_state"`
+	CacheFile      types.String    `tfsdk:"cache_file"`
+	CacheTTL       types.String    `tfsdk:"cache_ttl"`
+	MaxScans       types.Int64     `tfsdk:"max_scans"`
+	LeaseFiles     []leaseFileData `tfsdk:"lease_files"`
+	Helper         types.String    `tfsdk:"helper"`
 }
 
-func (data *providerData) configure(ctx context.Context, p *provider) error {
-	p.network = netaddr.IPSet{}
-	p.timeout = 5 * time.Minute
-	p.backoff = 5 * time.Second
+type leaseFileData struct {
+	Format types.String `tfsdk:"format"`
+	Path   types.String `tfsdk:"path"`
+}
+
+// defaultSearch returns the search settings used when neither the provider nor a data source sets them.
+func defaultSearch() ctxData {
+	return ctxData{
+		network:        &netaddr.IPSet{},
+		timeout:        5 * time.Minute,
+		backoff:        5 * time.Second,
+		requestTimeout: defaultRequestTimeout,
+		parallelism:    1,
+		scanMode:       scanModeRequest,
+		transport:      transportSocket,
+		cacheState:     neighReachable,
+	}
+}
 
-	if !data.Network.Null {
+// configure builds the runtime shared by the provider's data sources from its configuration.
+func (data *providerData) configure(ctx context.Context) (*providerRuntime, error) {
+	defaults, err := defaultSearch().merge(ctx, searchConfig{
+		Network:        data.Network,
+		Timeout:        data.Timeout,
+		Backoff:        data.Backoff,
+		RequestTimeout: data.RequestTimeout,
+		MaxAttempts:    data.MaxAttempts,
+		Parallelism:    data.Parallelism,
+		RateLimit:      data.RateLimit,
+		ScanMode:       data.ScanMode,
+		Transport:      data.Transport,
+		WarmCache:      data.WarmCache,
+		CacheState:     data.CacheState,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range data.LeaseFiles {
+		source, err := mkLeaseFile(file.Format.Value, file.Path.Value)
+		if err != nil {
+			return nil, err
+		}
+		defaults.sources = append(defaults.sources, source)
+	}
+
+	var cache *lookupCache
+	if !data.CacheFile.Null && data.CacheFile.Value != "" {
+		var ttl time.Duration
+		if !data.CacheTTL.Null && data.CacheTTL.Value != "" {
+			ttl, err = time.ParseDuration(data.CacheTTL.Value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		cache = mkLookupCache(data.CacheFile.Value, ttl)
+	}
+
+	if !data.Helper.Null && data.Helper.Value != "" {
+		helper, err := privhelper.Start(data.Helper.Value)
+		if err != nil {
+			return nil, err
+		}
+		defaults.helper = helper
+	}
+
+	var maxScans int
+	if !data.MaxScans.Null {
+		maxScans = int(data.MaxScans.Value)
+	}
+
+	return mkProviderRuntime(defaults, cache, maxScans), nil
+}
+
+// searchConfig holds the search settings that may be set by the provider or overridden by a data source. Null
+// values, and fields left out of the literal, are left as they were, except for WarmCache, whose zero value is
+// false rather than null.
+type searchConfig struct {
+	Network        types.List
+	Timeout        types.String
+	Backoff        types.String
+	RequestTimeout types.String
+	MaxAttempts    types.Int64
+	Interface      types.String
+	Parallelism    types.Int64
+	RateLimit      types.Int64
+	ScanMode       types.String
+	Transport      types.String
+	AcceptStates   types.Set
+	SourceIP       types.String
+	VLANID         types.Int64
+	WarmCache      types.Bool
+	CacheState     types.String
+}
+
+// merge layers the values set in config over data, returning the result. This is used both to apply the
+// provider's configuration over the defaults and to apply a data source's configuration over the provider's.
+func (data ctxData) merge(ctx context.Context, config searchConfig) (ctxData, error) {
+	merged := data
+
+	if !config.Network.Null && config.Network.Elems != nil {
 		networks := []string{}
-		data.Network.ElementsAs(ctx, &networks, false)
+		config.Network.ElementsAs(ctx, &networks, false)
 		network, err := mkIPSet(networks)
 		if err != nil {
-			return err
+			return ctxData{}, err
+		}
+		merged.network = network
+	}
+
+	for _, duration := range []struct {
+		value types.String
+		out   *time.Duration
+	}{
+		{config.Timeout, &merged.timeout},
+		{config.Backoff, &merged.backoff},
+		{config.RequestTimeout, &merged.requestTimeout},
+	} {
+		if duration.value.Null || duration.value.Value == "" {
+			continue
+		}
+
+		parsed, err := time.ParseDuration(duration.value.Value)
+		if err != nil {
+			return ctxData{}, err
 		}
-		p.network = *network
+		*duration.out = parsed
+	}
+
+	if !config.MaxAttempts.Null && config.MaxAttempts.Value != 0 {
+		merged.maxAttempts = int(config.MaxAttempts.Value)
+	}
+
+	if !config.Parallelism.Null && config.Parallelism.Value != 0 {
+		merged.parallelism = int(config.Parallelism.Value)
+	}
+
+	if !config.RateLimit.Null && config.RateLimit.Value != 0 {
+		merged.rateLimit = int(config.RateLimit.Value)
 	}
 
-	if !data.Timeout.Null {
-		timeout, err := time.ParseDuration(data.Timeout.Value)
+	if !config.ScanMode.Null && config.ScanMode.Value != "" {
+		merged.scanMode = config.ScanMode.Value
+	}
+
+	if !config.Transport.Null && config.Transport.Value != "" {
+		merged.transport = config.Transport.Value
+	}
+
+	if !config.AcceptStates.Null && config.AcceptStates.Elems != nil {
+		acceptStates, err := acceptStatesFrom(ctx, config.AcceptStates)
 		if err != nil {
-			return err
+			return ctxData{}, err
 		}
-		p.timeout = timeout
+		merged.acceptStates = acceptStates
 	}
 
-	if !data.Backoff.Null {
-		backoff, err := time.ParseDuration(data.Backoff.Value)
+	if !config.SourceIP.Null && config.SourceIP.Value != "" {
+		sourceIP, err := netaddr.ParseIP(config.SourceIP.Value)
 		if err != nil {
-			return err
+			return ctxData{}, err
 		}
-		p.backoff = backoff
+		merged.sourceIP = sourceIP
 	}
 
-	p.configured = true
+	if !config.VLANID.Null && config.VLANID.Value != 0 {
+		merged.vlanID = int(config.VLANID.Value)
+	}
 
-	return nil
+	if !config.WarmCache.Null && !config.WarmCache.Unknown {
+		merged.skipCache = !config.WarmCache.Value
+	}
+
+	if !config.CacheState.Null && config.CacheState.Value != "" {
+		state, ok := cacheStateNames[strings.ToLower(config.CacheState.Value)]
+		if !ok {
+			return ctxData{}, fmt.Errorf("unknown cache state \"%s\"", config.CacheState.Value)
+		}
+		merged.cacheState = state
+	}
+
+	if !config.Interface.Null && config.Interface.Value != "" {
+		iface, err := net.InterfaceByName(config.Interface.Value)
+		if err != nil {
+			return ctxData{}, err
+		}
+		merged.iface = iface
+	}
+
+	return merged, nil
+}
+
+// acceptStatesFrom parses the neighbour states named by an `accept_states` attribute. A null attribute gives an
+// empty set, which accepts the default states.
+func acceptStatesFrom(ctx context.Context, states types.Set) (neighState, error) {
+	if states.Null {
+		return 0, nil
+	}
+
+	names := []string{}
+	if diags := states.ElementsAs(ctx, &names, false); diags.HasError() {
+		return 0, fmt.Errorf("unable to read accept_states")
+	}
+
+	return parseNeighStates(names)
 }
 
 func (p *provider) Configure(ctx context.Context, req tfsdk.ConfigureProviderRequest, resp *tfsdk.ConfigureProviderResponse) {
@@ -109,19 +409,27 @@ func (p *provider) Configure(ctx context.Context, req tfsdk.ConfigureProviderReq
 		return
 	}
 
-	if err := data.configure(ctx, p); err != nil {
+	rt, err := data.configure(ctx)
+	if err != nil {
 		resp.Diagnostics.AddError("issue encountered running provider configure", err.Error())
 		return
 	}
+
+	p.setRuntime(rt)
 }
 
 func (p *provider) GetResources(ctx context.Context) (map[string]tfsdk.ResourceType, diag.Diagnostics) {
-	return map[string]tfsdk.ResourceType{}, nil
+	return map[string]tfsdk.ResourceType{
+		"arplookup_static_neighbor": staticNeighborResourceType{},
+	}, nil
 }
 
 func (p *provider) GetDataSources(ctx context.Context) (map[string]tfsdk.DataSourceType, diag.Diagnostics) {
 	return map[string]tfsdk.DataSourceType{
-		"arplookup_ip": ipDataSourceType{},
+		"arplookup_ip":    ipDataSourceType{},
+		"arplookup_ips":   ipsDataSourceType{},
+		"arplookup_mac":   macDataSourceType{},
+		"arplookup_hosts": hostsDataSourceType{},
 	}, nil
 }
 
@@ -133,7 +441,9 @@ func New(version string) func() tfsdk.Provider {
 	}
 }
 
-func convertProviderType(in tfsdk.Provider) (provider, diag.Diagnostics) {
+// convertProviderType returns the provider that created a data source or resource. The provider itself is kept,
+// rather than a copy, so that the runtime built once it is configured is seen by data sources created before then.
+func convertProviderType(in tfsdk.Provider) (*provider, diag.Diagnostics) {
 	var diags diag.Diagnostics
 	p, ok := in.(*provider)
 	if !ok {
@@ -141,7 +451,7 @@ func convertProviderType(in tfsdk.Provider) (provider, diag.Diagnostics) {
 			"Unexpected Provider Instance Type",
 			fmt.Sprintf("While creating the data source or resource, an unexpected provider type (%T) was received. This is always a bug in the provider code and should be reported to the provider developers.", p),
 		)
-		return provider{}, diags
+		return nil, diags
 	}
 
 	if p == nil {
@@ -149,8 +459,8 @@ func convertProviderType(in tfsdk.Provider) (provider, diag.Diagnostics) {
 			"Unexpected Provider Instance Type",
 			"While creating the data source or resource, an unexpected empty provider instance was received. This is always a bug in the provider code and should be reported to the provider developers.",
 		)
-		return provider{}, diags
+		return nil, diags
 	}
 
-	return *p, diags
+	return p, diags
 }
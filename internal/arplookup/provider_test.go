@@ -1,10 +1,13 @@
 package arplookup
 
 import (
+	"math/rand"
 	"testing"
 
 	"github.com/hashicorp/terraform-plugin-framework/providerserver"
 	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"inet.af/netaddr"
 )
 
 var testAccProtoV6ProviderFactories = map[string]func() (tfprotov6.ProviderServer, error){
@@ -16,3 +19,34 @@ func testAccPreCheck(t *testing.T) {
 	//	t.Fatalf(initErr.Error())
 	//}
 }
+
+// testAccTest runs steps against the provider.
+func testAccTest(t *testing.T, steps []resource.TestStep) {
+	resource.Test(t, resource.TestCase{
+		PreCheck: func() {
+			testAccPreCheck(t)
+		},
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps:                    steps,
+	})
+}
+
+// testAccNeedle initialises the test driver and inserts a host with mac at ip into the test haystack, after making
+// sure no other host has mac. IPv6 hosts answer neighbor discovery rather than ARP.
+func testAccNeedle(t *testing.T, r *rand.Rand, mac string, ip string, network string, index int) {
+	if err := driver.Init(r); err != nil {
+		t.Fatalf("unable to init test driver: %s", err.Error())
+	}
+
+	if err := driver.EnsureNo(mac); err != nil {
+		t.Fatalf("unable to ensure mac doesn't exist: %s", err.Error())
+	}
+
+	needle := driver.Needle
+	if netaddr.MustParseIP(ip).Is6() {
+		needle = driver.Needle6
+	}
+	if err := needle(mac, ip, network, index); err != nil {
+		t.Fatalf("unable to insert needle into test haystack: %s", err.Error())
+	}
+}
@@ -0,0 +1,129 @@
+package arplookup
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+)
+
+// defaultMaxScans is how many sweeps may run at once when `max_scans` isn't set.
+const defaultMaxScans = 4
+
+// providerRuntime is built each time the provider is configured and shared by every data source it creates, so that
+// concurrent reads share sockets, sweeps, the lookup cache and the capabilities raised for them, rather than each
+// holding a copy of its own.
+type providerRuntime struct {
+	defaults ctxData          // search settings data sources fall back to
+	cache    *lookupCache     // remembers where hosts were found between runs, if set
+	clients  *clientPool      // ARP clients shared between sweeps of the same interface
+	scans    *scanCoordinator // merges concurrent lookups into shared sweeps
+	limiter  *scanLimiter     // bounds the number of sweeps running at once
+}
+
+func mkProviderRuntime(defaults ctxData, cache *lookupCache, maxScans int) *providerRuntime {
+	clients := mkClientPool()
+	if maxScans == 0 {
+		maxScans = defaultMaxScans
+	}
+	limiter := mkScanLimiter(maxScans)
+	defaults.limiter = limiter
+	defaults.vlans = mkVLANLinks()
+
+	return &providerRuntime{
+		defaults: defaults,
+		cache:    cache,
+		clients:  clients,
+		scans:    mkScanCoordinator(clients),
+		limiter:  limiter,
+	}
+}
+
+// close releases the resources held by the runtime once the provider has been configured again. Shared sweeps are
+// ended, and no more clients are handed out, while lookups still running with a client of their own keep it until
+// they return.
+func (rt *providerRuntime) close() {
+	rt.scans.close()
+	rt.clients.close()
+	if rt.defaults.helper != nil {
+		rt.defaults.helper.Close()
+	}
+}
+
+// searchData merges the search settings given to a data source with the provider's defaults. The ring transport is
+// rejected for networks holding IPv6 prefixes, as neighbor discovery is never sent through it.
+func (rt *providerRuntime) searchData(ctx context.Context, config searchConfig) (ctxData, error) {
+	if (rt.defaults.network == nil || len(rt.defaults.network.Ranges()) == 0) && (config.Network.Null || config.Network.Elems == nil) {
+		return ctxData{}, fmt.Errorf("neither network specified")
+	}
+
+	data, err := rt.defaults.merge(ctx, config)
+	if err != nil {
+		return ctxData{}, err
+	}
+
+	if _, v6 := families(data.network); v6 && data.transport == transportRing {
+		return ctxData{}, fmt.Errorf("the %s transport only supports IPv4 networks", transportRing)
+	}
+
+	return data, nil
+}
+
+// runtime returns the runtime built by the provider's last configuration. Terraform may read data sources before
+// configuring the provider, when the provider's configuration depends on values that are unknown until apply, in
+// which case nil is returned and an error is added to diags.
+func (p *provider) runtime(diags *diag.Diagnostics) *providerRuntime {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.current == nil {
+		diags.AddError(
+			"provider not configured",
+			"The arplookup provider must be configured before its data sources are read. This happens when the "+
+				"provider's configuration depends on values that are unknown until apply, such as attributes of "+
+				"resources that have not yet been created. Configure the provider with known values, or use "+
+				"`-target` to create those resources first.",
+		)
+		return nil
+	}
+
+	return p.current
+}
+
+// setRuntime replaces the provider's runtime with rt, closing the previous one.
+func (p *provider) setRuntime(rt *providerRuntime) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.current != nil {
+		p.current.close()
+	}
+	p.current = rt
+}
+
+// scanLimiter bounds the number of sweeps running at once across every data source, so that a configuration with
+// many lookups doesn't flood the network with requests. A nil limiter allows any number of sweeps.
+type scanLimiter struct {
+	slots chan struct{}
+}
+
+func mkScanLimiter(n int) *scanLimiter {
+	return &scanLimiter{slots: make(chan struct{}, n)}
+}
+
+// acquire waits for a free slot, returning a function that frees it. It returns false if ctx expires or stop is
+// signalled first.
+func (l *scanLimiter) acquire(ctx context.Context, stop <-chan stopType) (func(), bool) {
+	if l == nil {
+		return func() {}, true
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, true
+	case <-ctx.Done():
+		return nil, false
+	case <-stop:
+		return nil, false
+	}
+}
@@ -0,0 +1,146 @@
+package arplookup
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"inet.af/netaddr"
+)
+
+// TestProviderRuntime checks whether data sources see the runtime built once the provider is configured, even when
+// they were created beforehand, and are given an error when read before then.
+func TestProviderRuntime(t *testing.T) {
+	p := New("test")().(*provider)
+	ds, diags := ipDataSourceType{}.NewDataSource(context.Background(), p)
+	if diags.HasError() {
+		t.Fatalf("error encountered while creating data source: %v", diags)
+	}
+
+	diags = diag.Diagnostics{}
+	if rt := ds.(ipDataSource).provider.runtime(&diags); rt != nil || !diags.HasError() {
+		t.Fatalf("expected an error reading an unconfigured provider")
+	}
+
+	rt := mkProviderRuntime(defaultSearch(), nil, 0)
+	p.setRuntime(rt)
+
+	diags = diag.Diagnostics{}
+	if got := ds.(ipDataSource).provider.runtime(&diags); got != rt || diags.HasError() {
+		t.Fatalf("expected data source to see the configured runtime, got: %v", diags)
+	}
+	if rt.defaults.limiter != rt.limiter || cap(rt.limiter.slots) != defaultMaxScans {
+		t.Fatalf("expected lookups to share a limiter of %d sweeps", defaultMaxScans)
+	}
+}
+
+// TestScanLimiter checks whether sweeps wait for a free slot, giving up if their context expires or they are
+// stopped first.
+func TestScanLimiter(t *testing.T) {
+	limiter := mkScanLimiter(1)
+	stop := make(chan stopType)
+
+	release, ok := limiter.acquire(context.Background(), stop)
+	if !ok {
+		t.Fatalf("expected a free slot")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, ok := limiter.acquire(ctx, stop); ok {
+		t.Fatalf("expected sweep to wait for a slot until its context expired")
+	}
+
+	close(stop)
+	if _, ok := limiter.acquire(context.Background(), stop); ok {
+		t.Fatalf("expected a stopped sweep to stop waiting for a slot")
+	}
+
+	release()
+	release, ok = limiter.acquire(context.Background(), make(chan stopType))
+	if !ok {
+		t.Fatalf("expected a slot to be free once released")
+	}
+	release()
+
+	var unlimited *scanLimiter
+	if _, ok := unlimited.acquire(context.Background(), nil); !ok {
+		t.Fatalf("expected a nil limiter to allow any number of sweeps")
+	}
+}
+
+// TestSearchDataTransport checks whether the ring transport is rejected for networks holding IPv6 prefixes, which
+// are searched with neighbor discovery instead.
+func TestSearchDataTransport(t *testing.T) {
+	for _, test := range []struct {
+		networks  []string
+		transport string
+		valid     bool
+	}{
+		{[]string{"192.168.32.0/24"}, transportRing, true},
+		{[]string{"192.168.32.0/24", "fd00::/120"}, transportSocket, true},
+		{[]string{"192.168.32.0/24", "fd00::/120"}, transportRing, false},
+		{[]string{"fd00::/120"}, transportRing, false},
+	} {
+		network, err := mkIPSet(test.networks)
+		if err != nil {
+			t.Fatalf("error encountered while building network: %s", err.Error())
+		}
+
+		defaults := defaultSearch()
+		defaults.network = network
+		defaults.transport = test.transport
+		rt := mkProviderRuntime(defaults, nil, 0)
+
+		_, err = rt.searchData(context.Background(), searchConfig{Network: types.List{Null: true}})
+		if test.valid && err != nil {
+			t.Fatalf("expected %s transport to be accepted for %v, got: %s", test.transport, test.networks, err.Error())
+		}
+		if !test.valid && err == nil {
+			t.Fatalf("expected %s transport to be rejected for %v", test.transport, test.networks)
+		}
+	}
+}
+
+// TestProviderRuntimeClose checks whether closing a runtime ends the sweeps in progress, failing the lookups waiting
+// on them, and stops its pool handing out clients.
+func TestProviderRuntimeClose(t *testing.T) {
+	var builder netaddr.IPSetBuilder
+	builder.AddPrefix(netaddr.MustParseIPPrefix("192.168.33.0/24"))
+	ipSet, _ := builder.IPSet()
+
+	rt := mkProviderRuntime(defaultSearch(), nil, 0)
+	rt.clients.mkClient = func(ctxData) arpClient { return mkDummyARP(netaddr.IP{}) }
+
+	data := ctxData{network: ipSet, backoff: time.Minute}
+	result := make(chan error, 1)
+	go func() {
+		_, err := rt.scans.lookup(context.Background(), net.HardwareAddr{0x3e, 0x50, 0x6e, 0x54, 0x28, 0x01}, data)
+		result <- err
+	}()
+
+	// Wait for the sweep to start before closing the runtime
+	for started := false; !started; time.Sleep(time.Millisecond) {
+		rt.scans.mu.Lock()
+		started = len(rt.scans.scans) > 0
+		rt.scans.mu.Unlock()
+	}
+	rt.close()
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, errReconfigured) {
+			t.Fatalf("expected lookup to fail as the provider was configured again, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected lookup to return once the runtime was closed")
+	}
+
+	if _, _, err := rt.clients.acquire(data); !errors.Is(err, errReconfigured) {
+		t.Fatalf("expected closed pool to refuse clients, got: %v", err)
+	}
+}
@@ -0,0 +1,252 @@
+package arplookup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"inet.af/netaddr"
+)
+
+// Ensure provider defined types fully satisfy framework interfaces
+var _ tfsdk.ResourceType = staticNeighborResourceType{}
+var _ tfsdk.Resource = staticNeighborResource{}
+var _ tfsdk.ResourceWithImportState = staticNeighborResource{}
+
+type staticNeighborResourceType struct{}
+
+func (t staticNeighborResourceType) GetSchema(ctx context.Context) (tfsdk.Schema, diag.Diagnostics) {
+	return tfsdk.Schema{
+		MarkdownDescription: "This resource pins `ip` to `macaddr` with a permanent entry in the kernel's neighbour table for `interface`. ",
+		Attributes: map[string]tfsdk.Attribute{
+			"interface": {
+				MarkdownDescription: "Interface the neighbour entry is added to.",
+				Required:            true,
+				Type:                types.StringType,
+				PlanModifiers: tfsdk.AttributePlanModifiers{
+					tfsdk.RequiresReplace(),
+				},
+				Validators: []tfsdk.AttributeValidator{
+					interfaceValidator{},
+				},
+			},
+			"ip": {
+				MarkdownDescription: "IPv4 or IPv6 address of the neighbour.",
+				Required:            true,
+				Type:                types.StringType,
+				PlanModifiers: tfsdk.AttributePlanModifiers{
+					tfsdk.RequiresReplace(),
+				},
+				Validators: []tfsdk.AttributeValidator{
+					ipValidator{ipv6: true},
+				},
+			},
+			"macaddr": {
+				MarkdownDescription: "MAC address of the neighbour.",
+				Required:            true,
+				Type:                types.StringType,
+				Validators: []tfsdk.AttributeValidator{
+					macValidator{},
+				},
+			},
+			"id": {
+				MarkdownDescription: "Unique identifier, in the form `interface/ip`.",
+				Type:                types.StringType,
+				Computed:            true,
+				PlanModifiers: tfsdk.AttributePlanModifiers{
+					tfsdk.UseStateForUnknown(),
+				},
+			},
+		},
+	}, nil
+}
+
+func (t staticNeighborResourceType) NewResource(ctx context.Context, in tfsdk.Provider) (tfsdk.Resource, diag.Diagnostics) {
+	provider, diags := convertProviderType(in)
+	return staticNeighborResource{
+		provider: provider,
+	}, diags
+}
+
+type staticNeighborResourceData struct {
+	Interface types.String `tfsdk:"interface"`
+	IP        types.String `tfsdk:"ip"`
+	MACAddr   types.String `tfsdk:"macaddr"`
+	Id        types.String `tfsdk:"id"`
+}
+
+type staticNeighborResource struct {
+	provider *provider
+}
+
+// parse reads the neighbour entry described by the resource's attributes.
+func (data *staticNeighborResourceData) parse() (*net.Interface, netaddr.IP, error) {
+	iface, err := net.InterfaceByName(data.Interface.Value)
+	if err != nil {
+		return nil, netaddr.IP{}, err
+	}
+
+	ip, err := netaddr.ParseIP(data.IP.Value)
+	if err != nil {
+		return nil, netaddr.IP{}, err
+	}
+
+	return iface, ip, nil
+}
+
+// set adds or replaces the neighbour entry described by the resource's attributes.
+func (data *staticNeighborResourceData) set() error {
+	iface, ip, err := data.parse()
+	if err != nil {
+		return err
+	}
+
+	mac, err := net.ParseMAC(data.MACAddr.Value)
+	if err != nil {
+		return err
+	}
+
+	if err := setStaticNeighbour(iface, ip, mac); err != nil {
+		return err
+	}
+
+	data.MACAddr = macValue(data.MACAddr, mac)
+	data.Id = types.String{Value: iface.Name + "/" + ip.String()}
+
+	return nil
+}
+
+// read refreshes the resource's attributes from the kernel's neighbour table, reporting whether the permanent
+// entry still exists. Entries that have been removed or replaced by a dynamic entry are reported as missing.
+func (data *staticNeighborResourceData) read() (bool, error) {
+	iface, ip, err := data.parse()
+	if err != nil {
+		return false, err
+	}
+
+	entry, ok, err := getNeighbour(iface, ip)
+	if err != nil {
+		return false, err
+	}
+	if !ok || entry.state != neighPermanent {
+		return false, nil
+	}
+
+	data.MACAddr = macValue(data.MACAddr, entry.mac)
+	data.Id = types.String{Value: iface.Name + "/" + ip.String()}
+
+	return true, nil
+}
+
+// macValue returns the value macaddr is stored as when the entry has mac. The address is kept as configured if it
+// names mac, as any form net.ParseMAC accepts may be configured, and otherwise given in canonical form.
+func macValue(configured types.String, mac net.HardwareAddr) types.String {
+	if current, err := net.ParseMAC(configured.Value); err == nil && bytes.Equal(current, mac) {
+		return configured
+	}
+
+	return types.String{Value: mac.String()}
+}
+
+func (r staticNeighborResource) Create(ctx context.Context, req tfsdk.CreateResourceRequest, resp *tfsdk.CreateResourceResponse) {
+	var data staticNeighborResourceData
+	diags := req.Plan.Get(ctx, &data)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if err := data.set(); err != nil {
+		resp.Diagnostics.AddError("issue encountered while creating neighbour entry", err.Error())
+		return
+	}
+
+	diags = resp.State.Set(ctx, &data)
+	resp.Diagnostics.Append(diags...)
+}
+
+func (r staticNeighborResource) Read(ctx context.Context, req tfsdk.ReadResourceRequest, resp *tfsdk.ReadResourceResponse) {
+	var data staticNeighborResourceData
+	diags := req.State.Get(ctx, &data)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	exists, err := data.read()
+	if err != nil {
+		resp.Diagnostics.AddError("issue encountered while reading neighbour entry", err.Error())
+		return
+	}
+
+	// The entry has drifted away, so it should be created again
+	if !exists {
+		resp.State.RemoveResource(ctx)
+		return
+	}
+
+	diags = resp.State.Set(ctx, &data)
+	resp.Diagnostics.Append(diags...)
+}
+
+func (r staticNeighborResource) Update(ctx context.Context, req tfsdk.UpdateResourceRequest, resp *tfsdk.UpdateResourceResponse) {
+	var data staticNeighborResourceData
+	diags := req.Plan.Get(ctx, &data)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if err := data.set(); err != nil {
+		resp.Diagnostics.AddError("issue encountered while updating neighbour entry", err.Error())
+		return
+	}
+
+	diags = resp.State.Set(ctx, &data)
+	resp.Diagnostics.Append(diags...)
+}
+
+func (r staticNeighborResource) Delete(ctx context.Context, req tfsdk.DeleteResourceRequest, resp *tfsdk.DeleteResourceResponse) {
+	var data staticNeighborResourceData
+	diags := req.State.Get(ctx, &data)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	iface, ip, err := data.parse()
+	if err != nil {
+		resp.Diagnostics.AddError("issue encountered while deleting neighbour entry", err.Error())
+		return
+	}
+
+	if err := deleteNeighbour(iface, ip); err != nil {
+		resp.Diagnostics.AddError("issue encountered while deleting neighbour entry", err.Error())
+		return
+	}
+}
+
+// ImportState imports a neighbour entry by an ID of the form `interface/ip`.
+func (r staticNeighborResource) ImportState(ctx context.Context, req tfsdk.ImportResourceStateRequest, resp *tfsdk.ImportResourceStateResponse) {
+	iface, ip, ok := strings.Cut(req.ID, "/")
+	if !ok || iface == "" || ip == "" {
+		resp.Diagnostics.AddError("invalid import ID",
+			fmt.Sprintf("\"%s\" provided: expected an ID of the form `interface/ip`", req.ID))
+		return
+	}
+
+	if _, err := netaddr.ParseIP(ip); err != nil {
+		resp.Diagnostics.AddError("invalid import ID", fmt.Sprintf("\"%s\" provided: %s", req.ID, err.Error()))
+		return
+	}
+
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("interface"), iface)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("ip"), ip)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), req.ID)...)
+}
@@ -0,0 +1,145 @@
+package arplookup
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"inet.af/netaddr"
+)
+
+var (
+	neighborIP   = "10.18.6.200"
+	neighborMAC  = "3e:50:6e:54:28:40"
+	neighborMAC2 = "3e:50:6e:54:28:41"
+	// neighborMAC2 in other forms accepted by net.ParseMAC
+	neighborMAC2Upper  = "3E:50:6E:54:28:41"
+	neighborMAC2Dashed = "3e-50-6e-54-28-41"
+)
+
+// testAccCheckNeighbor checks whether the kernel's neighbour table for br0 has a permanent entry of ip to mac, or
+// no entry if mac is empty.
+func testAccCheckNeighbor(ip string, mac string) resource.TestCheckFunc {
+	return func(*terraform.State) error {
+		iface, err := net.InterfaceByName("br0")
+		if err != nil {
+			return err
+		}
+
+		entry, ok, err := getNeighbour(iface, netaddr.MustParseIP(ip))
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case mac == "" && ok && entry.state == neighPermanent:
+			return fmt.Errorf("neighbour entry for %s still exists", ip)
+		case mac == "":
+			return nil
+		case !ok || entry.state != neighPermanent:
+			return fmt.Errorf("no permanent neighbour entry for %s", ip)
+		case entry.mac.String() != mac:
+			return fmt.Errorf("neighbour entry for %s has MAC %s, want %s", ip, entry.mac.String(), mac)
+		}
+
+		return nil
+	}
+}
+
+func TestAccStaticNeighborResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck: func() {
+			testAccPreCheck(t)
+		},
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		CheckDestroy:             testAccCheckNeighbor(neighborIP, ""),
+		Steps: []resource.TestStep{
+			{
+				Config: testAccStaticNeighborConfig(neighborMAC),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("arplookup_static_neighbor.test", "id", "br0/"+neighborIP),
+					resource.TestCheckResourceAttr("arplookup_static_neighbor.test", "macaddr", neighborMAC),
+					testAccCheckNeighbor(neighborIP, neighborMAC),
+				),
+			}, {
+				Config: testAccStaticNeighborConfig(neighborMAC2),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("arplookup_static_neighbor.test", "macaddr", neighborMAC2),
+					testAccCheckNeighbor(neighborIP, neighborMAC2),
+				),
+			}, {
+				ResourceName:      "arplookup_static_neighbor.test",
+				ImportState:       true,
+				ImportStateId:     "br0/" + neighborIP,
+				ImportStateVerify: true,
+			}, {
+				// Entries removed outside of Terraform are created again
+				PreConfig: func() {
+					iface, err := net.InterfaceByName("br0")
+					if err != nil {
+						t.Fatalf("unable to get interface: %s", err.Error())
+					}
+
+					if err := deleteNeighbour(iface, netaddr.MustParseIP(neighborIP)); err != nil {
+						t.Fatalf("unable to delete neighbour entry: %s", err.Error())
+					}
+				},
+				Config: testAccStaticNeighborConfig(neighborMAC2),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("arplookup_static_neighbor.test", "macaddr", neighborMAC2),
+					testAccCheckNeighbor(neighborIP, neighborMAC2),
+				),
+			}, {
+				// MACs are kept as configured, whatever form they are given in
+				Config: testAccStaticNeighborConfig(neighborMAC2Upper),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("arplookup_static_neighbor.test", "macaddr", neighborMAC2Upper),
+					testAccCheckNeighbor(neighborIP, neighborMAC2),
+				),
+			}, {
+				Config: testAccStaticNeighborConfig(neighborMAC2Dashed),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("arplookup_static_neighbor.test", "macaddr", neighborMAC2Dashed),
+					testAccCheckNeighbor(neighborIP, neighborMAC2),
+				),
+			},
+		},
+	})
+}
+
+// TestMACValue checks whether configured MACs are kept in the form they were given in when they name the entry's
+// MAC, and replaced by the entry's MAC otherwise.
+func TestMACValue(t *testing.T) {
+	mac, _ := net.ParseMAC(neighborMAC2)
+
+	testcases := []struct {
+		configured types.String
+		expect     string
+	}{
+		{configured: types.String{Value: neighborMAC2}, expect: neighborMAC2},
+		{configured: types.String{Value: neighborMAC2Upper}, expect: neighborMAC2Upper},
+		{configured: types.String{Value: neighborMAC2Dashed}, expect: neighborMAC2Dashed},
+		{configured: types.String{Value: neighborMAC}, expect: neighborMAC2},
+		// Imported resources have no MAC until read
+		{configured: types.String{Null: true}, expect: neighborMAC2},
+	}
+
+	for _, test := range testcases {
+		if got := macValue(test.configured, mac); got.Value != test.expect {
+			t.Fatalf("expected macaddr %s to be stored as %s, got: %s", test.configured.Value, test.expect, got.Value)
+		}
+	}
+}
+
+func testAccStaticNeighborConfig(mac string) string {
+	return `
+resource "arplookup_static_neighbor" "test" {
+  interface = "br0"
+  ip = "` + neighborIP + `"
+  macaddr = "` + mac + `"
+}
+`
+}
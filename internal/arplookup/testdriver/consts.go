@@ -21,5 +21,6 @@ var (
 	bridge = "br0"
 	tap    = "tap0"
 
-	bridgeAddr = "10.18.0.1/16"
+	bridgeAddr  = "10.18.0.1/16"
+	bridgeAddr6 = "fd18::1/64"
 )
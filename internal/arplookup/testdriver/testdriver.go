@@ -28,7 +28,10 @@ func mkBridge() (*net.Interface, error) {
 
 		// add bridge and assign an address
 		exec.Command("ip", "link", "add", "name", bridge, "type", "bridge"),
+		// skip duplicate address detection so the bridge's link-local address is usable immediately
+		exec.Command("sysctl", "-w", fmt.Sprintf("net.ipv6.conf.%s.accept_dad=0", bridge)),
 		exec.Command("ip", "addr", "add", bridgeAddr, "dev", bridge),
+		exec.Command("ip", "-6", "addr", "add", bridgeAddr6, "dev", bridge, "nodad"),
 		exec.Command("ip", "link", "set", "dev", bridge, "up"),
 
 		// add tap interface for bridge
@@ -176,6 +179,24 @@ func (driver *Driver) Needle(mac string, ip string, network string, nsNumber int
 	return nil
 }
 
+// Needle6 places a host with the given MAC and IPv6 address into a namespace's veth peer.
+func (driver *Driver) Needle6(mac string, ip string, network string, nsNumber int) error {
+	netns := fmt.Sprintf("netns%d", nsNumber)
+	peer := fmt.Sprintf("veth%dp", nsNumber)
+
+	cmds := []*exec.Cmd{
+		exec.Command("ip", "netns", "exec", netns, "ifconfig", peer, "hw", "ether", mac),
+		exec.Command("ip", "netns", "exec", netns, "ip", "addr", "flush", "dev", peer),
+		exec.Command("ip", "netns", "exec", netns, "ip", "-6", "addr", "add", network, "dev", peer, "nodad"),
+	}
+
+	if err := runCmds(cmds); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (driver *Driver) NeedleAfter(mac string, ip string, network string, nsNumber int, duration time.Duration) error {
 	errs := make(chan error, 1)
 	go func(dur time.Duration, errs chan<- error) {
@@ -191,3 +212,23 @@ func (driver *Driver) NeedleAfter(mac string, ip string, network string, nsNumbe
 	err := <-errs
 	return err
 }
+
+// AnnounceAfter places a host into a namespace's veth peer, and once duration has passed has it send gratuitous ARP
+// announcements of its address. It returns immediately, and the announcements are sent in the background.
+func (driver *Driver) AnnounceAfter(mac string, ip string, network string, nsNumber int, duration time.Duration) error {
+	if err := driver.Needle(mac, ip, network, nsNumber); err != nil {
+		return err
+	}
+
+	netns := fmt.Sprintf("netns%d", nsNumber)
+	peer := fmt.Sprintf("veth%dp", nsNumber)
+
+	go func() {
+		time.Sleep(duration)
+		runCmds([]*exec.Cmd{
+			exec.Command("ip", "netns", "exec", netns, "arping", "-U", "-c", "3", "-I", peer, ip),
+		})
+	}()
+
+	return nil
+}
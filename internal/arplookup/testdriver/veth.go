@@ -0,0 +1,40 @@
+package testdriver
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+)
+
+// VethPair creates a veth pair whose peer, in a namespace of its own, answers ARP requests for every address in
+// network as if a host were using each of them, and returns the end left in this namespace, which is given addr.
+// It's used to benchmark sweeps of networks far larger than the test network's namespaces could hold. The pair is
+// only created once, so the driver must be initialised first and later calls return the existing interface.
+func (driver *Driver) VethPair(name string, addr string, network string) (*net.Interface, error) {
+	if iface, err := net.InterfaceByName(name); err == nil {
+		return iface, nil
+	}
+
+	netns := fmt.Sprintf("%sns", name)
+	peer := fmt.Sprintf("%sp", name)
+
+	cmds := []*exec.Cmd{
+		exec.Command("ip", "netns", "add", netns),
+		exec.Command("ip", "link", "add", name, "type", "veth", "peer", "name", peer, "netns", netns),
+		exec.Command("ip", "addr", "add", addr, "dev", name),
+		exec.Command("ip", "link", "set", name, "up"),
+
+		exec.Command("ip", "netns", "exec", netns, "ip", "link", "set", "dev", "lo", "up"),
+		exec.Command("ip", "netns", "exec", netns, "ip", "link", "set", peer, "up"),
+		// a local route makes every address in network one of the peer's own, so it replies to requests for any,
+		// which includes addr, so requests from it must be accepted
+		exec.Command("ip", "netns", "exec", netns, "ip", "route", "add", "local", network, "dev", "lo"),
+		exec.Command("ip", "netns", "exec", netns, "sysctl", "-w", fmt.Sprintf("net.ipv4.conf.%s.accept_local=1", peer)),
+	}
+
+	if err := runCmds(cmds); err != nil {
+		return nil, err
+	}
+
+	return net.InterfaceByName(name)
+}
@@ -4,9 +4,10 @@ import (
 	"context"
 	"fmt"
 	"net"
-	"reflect"
+	"strings"
 	"time"
 
+	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 	"github.com/hashicorp/terraform-plugin-go/tftypes"
@@ -49,6 +50,53 @@ func (v interfaceValidator) Validate(ctx context.Context, req tfsdk.ValidateAttr
 	}
 }
 
+// ipValidator checks whether a given IPv4 address, or IPv6 address if allowed, is properly formed.
+type ipValidator struct {
+	ipv6 bool // also accept IPv6 addresses
+}
+
+// Description implements AttributeValidator.
+func (v ipValidator) Description(context.Context) string {
+	if v.ipv6 {
+		return "Checks whether a valid IPv4 or IPv6 address has been passed to the provider."
+	}
+	return "Checks whether a valid IPv4 address has been passed to the provider."
+}
+
+// MarkdownDescription implements AttributeValidator.
+func (v ipValidator) MarkdownDescription(context.Context) string {
+	if v.ipv6 {
+		return "Checks whether a valid IPv4 or IPv6 address has been passed to the provider."
+	}
+	return "Checks whether a valid IPv4 address has been passed to the provider."
+}
+
+// Validate implements AttributeValidator.
+func (v ipValidator) Validate(ctx context.Context, req tfsdk.ValidateAttributeRequest, resp *tfsdk.ValidateAttributeResponse) {
+	var ip types.String
+	diags := tfsdk.ValueAs(ctx, req.AttributeConfig, &ip)
+	resp.Diagnostics.Append(diags...)
+	if diags.HasError() {
+		return
+	}
+
+	if ip.Unknown || ip.Null {
+		return
+	}
+
+	addr, err := netaddr.ParseIP(ip.Value)
+	if err == nil && !addr.Is4() && !v.ipv6 {
+		err = fmt.Errorf("only IPv4 addresses can be resolved with ARP")
+	}
+	if err != nil {
+		resp.Diagnostics.AddAttributeError(
+			req.AttributePath,
+			"malformed or invalid IP",
+			fmt.Sprintf("\"%s\" provided: %s", ip, err.Error()))
+		return
+	}
+}
+
 // macValidator checks whether a given MAC address is properly formed.
 type macValidator struct{}
 
@@ -85,6 +133,55 @@ func (v macValidator) Validate(ctx context.Context, req tfsdk.ValidateAttributeR
 	}
 }
 
+// macSetValidator checks whether every MAC address in a set is properly formed.
+type macSetValidator struct{}
+
+// Description implements AttributeValidator.
+func (v macSetValidator) Description(context.Context) string {
+	return "Checks whether a set of valid MAC addresses has been passed to the provider."
+}
+
+// MarkdownDescription implements AttributeValidator.
+func (v macSetValidator) MarkdownDescription(context.Context) string {
+	return "Checks whether a set of valid MAC addresses has been passed to the provider."
+}
+
+// Validate implements AttributeValidator.
+func (v macSetValidator) Validate(ctx context.Context, req tfsdk.ValidateAttributeRequest, resp *tfsdk.ValidateAttributeResponse) {
+	var macs types.Set
+	diags := tfsdk.ValueAs(ctx, req.AttributeConfig, &macs)
+	resp.Diagnostics.Append(diags...)
+	if diags.HasError() {
+		return
+	}
+
+	if macs.Unknown || macs.Null {
+		return
+	}
+
+	for _, elem := range macs.Elems {
+		var mac types.String
+		diags := tfsdk.ValueAs(ctx, elem, &mac)
+		resp.Diagnostics.Append(diags...)
+		if diags.HasError() {
+			return
+		}
+
+		if mac.Unknown || mac.Null {
+			continue
+		}
+
+		_, err := net.ParseMAC(mac.Value)
+		if err != nil {
+			resp.Diagnostics.AddAttributeError(
+				req.AttributePath,
+				"malformed or invalid MAC",
+				fmt.Sprintf("\"%s\" provided: %s", mac, err.Error()))
+			return
+		}
+	}
+}
+
 // timeValidator checks whether a given string representing a duration is a valid go duration.
 type timeValidator struct{}
 
@@ -121,6 +218,341 @@ func (v timeValidator) Validate(ctx context.Context, req tfsdk.ValidateAttribute
 	}
 }
 
+// scanModeValidator checks whether a given string names a supported scan strategy.
+type scanModeValidator struct{}
+
+// Description implements AttributeValidator.
+func (v scanModeValidator) Description(context.Context) string {
+	return "Checks whether a supported scan mode has been passed to the provider."
+}
+
+// MarkdownDescription implements AttributeValidator.
+func (v scanModeValidator) MarkdownDescription(context.Context) string {
+	return "Checks whether a supported scan mode has been passed to the provider."
+}
+
+// Validate implements AttributeValidator.
+func (v scanModeValidator) Validate(ctx context.Context, req tfsdk.ValidateAttributeRequest, resp *tfsdk.ValidateAttributeResponse) {
+	var mode types.String
+	diags := tfsdk.ValueAs(ctx, req.AttributeConfig, &mode)
+	resp.Diagnostics.Append(diags...)
+	if diags.HasError() {
+		return
+	}
+
+	if mode.Unknown || mode.Null {
+		return
+	}
+
+	switch mode.Value {
+	case scanModeRequest, scanModeAsync:
+	default:
+		resp.Diagnostics.AddAttributeError(
+			req.AttributePath,
+			"invalid scan mode",
+			fmt.Sprintf("\"%s\" provided: must be one of \"%s\" or \"%s\"", mode.Value, scanModeRequest, scanModeAsync))
+		return
+	}
+}
+
+// transportValidator checks whether a given string names a supported way of exchanging packets with the kernel.
+type transportValidator struct{}
+
+// Description implements AttributeValidator.
+func (v transportValidator) Description(context.Context) string {
+	return "Checks whether a supported transport has been passed to the provider."
+}
+
+// MarkdownDescription implements AttributeValidator.
+func (v transportValidator) MarkdownDescription(context.Context) string {
+	return "Checks whether a supported transport has been passed to the provider."
+}
+
+// Validate implements AttributeValidator.
+func (v transportValidator) Validate(ctx context.Context, req tfsdk.ValidateAttributeRequest, resp *tfsdk.ValidateAttributeResponse) {
+	var transport types.String
+	diags := tfsdk.ValueAs(ctx, req.AttributeConfig, &transport)
+	resp.Diagnostics.Append(diags...)
+	if diags.HasError() {
+		return
+	}
+
+	if transport.Unknown || transport.Null {
+		return
+	}
+
+	switch transport.Value {
+	case transportSocket, transportRing:
+	default:
+		resp.Diagnostics.AddAttributeError(
+			req.AttributePath,
+			"invalid transport",
+			fmt.Sprintf("\"%s\" provided: must be one of \"%s\" or \"%s\"", transport.Value, transportSocket, transportRing))
+		return
+	}
+}
+
+// cacheStateValidator checks whether a given string names a state found hosts can be added to the neighbour table in.
+type cacheStateValidator struct{}
+
+// Description implements AttributeValidator.
+func (v cacheStateValidator) Description(context.Context) string {
+	return "Checks whether a valid neighbour state to cache hosts in has been passed to the provider."
+}
+
+// MarkdownDescription implements AttributeValidator.
+func (v cacheStateValidator) MarkdownDescription(context.Context) string {
+	return "Checks whether a valid neighbour state to cache hosts in has been passed to the provider."
+}
+
+// Validate implements AttributeValidator.
+func (v cacheStateValidator) Validate(ctx context.Context, req tfsdk.ValidateAttributeRequest, resp *tfsdk.ValidateAttributeResponse) {
+	var state types.String
+	diags := tfsdk.ValueAs(ctx, req.AttributeConfig, &state)
+	resp.Diagnostics.Append(diags...)
+	if diags.HasError() {
+		return
+	}
+
+	if state.Unknown || state.Null {
+		return
+	}
+
+	if _, ok := cacheStateNames[strings.ToLower(state.Value)]; !ok {
+		resp.Diagnostics.AddAttributeError(
+			req.AttributePath,
+			"invalid cache state",
+			fmt.Sprintf("\"%s\" provided: must be one of \"reachable\", \"stale\" or \"permanent\"", state.Value))
+		return
+	}
+}
+
+// neighStatesValidator checks whether an attribute containing a set of strings names valid neighbour table states.
+type neighStatesValidator struct{}
+
+// Description implements AttributeValidator.
+func (v neighStatesValidator) Description(context.Context) string {
+	return "Checks whether a set of valid neighbour states has been passed to the provider."
+}
+
+// MarkdownDescription implements AttributeValidator.
+func (v neighStatesValidator) MarkdownDescription(context.Context) string {
+	return "Checks whether a set of valid neighbour states has been passed to the provider."
+}
+
+// Validate implements AttributeValidator.
+func (v neighStatesValidator) Validate(ctx context.Context, req tfsdk.ValidateAttributeRequest, resp *tfsdk.ValidateAttributeResponse) {
+	var states types.Set
+	diags := tfsdk.ValueAs(ctx, req.AttributeConfig, &states)
+	resp.Diagnostics.Append(diags...)
+	if diags.HasError() {
+		return
+	}
+
+	if states.Unknown || states.Null {
+		return
+	}
+
+	for _, elem := range states.Elems {
+		var state types.String
+		diags := tfsdk.ValueAs(ctx, elem, &state)
+		resp.Diagnostics.Append(diags...)
+		if diags.HasError() {
+			return
+		}
+
+		if state.Unknown || state.Null {
+			continue
+		}
+
+		if _, err := parseNeighStates([]string{state.Value}); err != nil {
+			resp.Diagnostics.AddAttributeError(
+				req.AttributePath,
+				"invalid neighbour state",
+				fmt.Sprintf("\"%s\" provided: %s", state.Value, err.Error()))
+			return
+		}
+	}
+}
+
+// leaseFormatValidator checks whether a given string names a supported lease file format.
+type leaseFormatValidator struct{}
+
+// Description implements AttributeValidator.
+func (v leaseFormatValidator) Description(context.Context) string {
+	return "Checks whether a supported lease file format has been passed to the provider."
+}
+
+// MarkdownDescription implements AttributeValidator.
+func (v leaseFormatValidator) MarkdownDescription(context.Context) string {
+	return "Checks whether a supported lease file format has been passed to the provider."
+}
+
+// Validate implements AttributeValidator.
+func (v leaseFormatValidator) Validate(ctx context.Context, req tfsdk.ValidateAttributeRequest, resp *tfsdk.ValidateAttributeResponse) {
+	var format types.String
+	diags := tfsdk.ValueAs(ctx, req.AttributeConfig, &format)
+	resp.Diagnostics.Append(diags...)
+	if diags.HasError() {
+		return
+	}
+
+	if format.Unknown || format.Null {
+		return
+	}
+
+	if _, err := mkLeaseFile(format.Value, ""); err != nil {
+		resp.Diagnostics.AddAttributeError(
+			req.AttributePath,
+			"invalid lease file format",
+			fmt.Sprintf("\"%s\" provided: must be one of \"%s\", \"%s\" or \"%s\"",
+				format.Value, leaseFormatDnsmasq, leaseFormatISC, leaseFormatKea))
+		return
+	}
+}
+
+// positiveIntValidator checks whether a given number is greater than zero.
+type positiveIntValidator struct{}
+
+// Description implements AttributeValidator.
+func (v positiveIntValidator) Description(context.Context) string {
+	return "Checks whether a number greater than zero has been passed to the provider."
+}
+
+// MarkdownDescription implements AttributeValidator.
+func (v positiveIntValidator) MarkdownDescription(context.Context) string {
+	return "Checks whether a number greater than zero has been passed to the provider."
+}
+
+// Validate implements AttributeValidator.
+func (v positiveIntValidator) Validate(ctx context.Context, req tfsdk.ValidateAttributeRequest, resp *tfsdk.ValidateAttributeResponse) {
+	var number types.Int64
+	diags := tfsdk.ValueAs(ctx, req.AttributeConfig, &number)
+	resp.Diagnostics.Append(diags...)
+	if diags.HasError() {
+		return
+	}
+
+	if number.Unknown || number.Null {
+		return
+	}
+
+	if number.Value <= 0 {
+		resp.Diagnostics.AddAttributeError(
+			req.AttributePath,
+			"invalid number",
+			fmt.Sprintf("\"%d\" provided: must be greater than zero", number.Value))
+		return
+	}
+}
+
+// vlanIDValidator checks whether a given number is a valid 802.1Q VLAN ID.
+type vlanIDValidator struct{}
+
+// Description implements AttributeValidator.
+func (v vlanIDValidator) Description(context.Context) string {
+	return "Checks whether a valid VLAN ID has been passed to the provider."
+}
+
+// MarkdownDescription implements AttributeValidator.
+func (v vlanIDValidator) MarkdownDescription(context.Context) string {
+	return "Checks whether a valid VLAN ID has been passed to the provider."
+}
+
+// Validate implements AttributeValidator.
+func (v vlanIDValidator) Validate(ctx context.Context, req tfsdk.ValidateAttributeRequest, resp *tfsdk.ValidateAttributeResponse) {
+	var id types.Int64
+	diags := tfsdk.ValueAs(ctx, req.AttributeConfig, &id)
+	resp.Diagnostics.Append(diags...)
+	if diags.HasError() {
+		return
+	}
+
+	if id.Unknown || id.Null {
+		return
+	}
+
+	if id.Value < 1 || id.Value > 4094 {
+		resp.Diagnostics.AddAttributeError(
+			req.AttributePath,
+			"invalid VLAN ID",
+			fmt.Sprintf("\"%d\" provided: must be between 1 and 4094", id.Value))
+		return
+	}
+}
+
+// sourceIPValidator checks whether a given IPv4 address is properly formed and, unless `vlan_id` is set, assigned
+// to the configured `interface`.
+type sourceIPValidator struct{}
+
+// Description implements AttributeValidator.
+func (v sourceIPValidator) Description(context.Context) string {
+	return "Checks whether an IPv4 address assigned to the interface has been passed to the provider."
+}
+
+// MarkdownDescription implements AttributeValidator.
+func (v sourceIPValidator) MarkdownDescription(context.Context) string {
+	return "Checks whether an IPv4 address assigned to the interface has been passed to the provider."
+}
+
+// Validate implements AttributeValidator.
+func (v sourceIPValidator) Validate(ctx context.Context, req tfsdk.ValidateAttributeRequest, resp *tfsdk.ValidateAttributeResponse) {
+	var ip types.String
+	diags := tfsdk.ValueAs(ctx, req.AttributeConfig, &ip)
+	resp.Diagnostics.Append(diags...)
+	if diags.HasError() {
+		return
+	}
+
+	if ip.Unknown || ip.Null {
+		return
+	}
+
+	addr, err := netaddr.ParseIP(ip.Value)
+	if err == nil && !addr.Is4() {
+		err = fmt.Errorf("ARP requests can only be sent from IPv4 addresses")
+	}
+	if err != nil {
+		resp.Diagnostics.AddAttributeError(
+			req.AttributePath,
+			"malformed or invalid IP",
+			fmt.Sprintf("\"%s\" provided: %s", ip, err.Error()))
+		return
+	}
+
+	// The address may be assigned to a VLAN sub-interface rather than the interface itself
+	var iface types.String
+	var vlanID types.Int64
+	if diags := req.Config.GetAttribute(ctx, path.Root("interface"), &iface); diags.HasError() || iface.Unknown || iface.Null {
+		return
+	}
+	if diags := req.Config.GetAttribute(ctx, path.Root("vlan_id"), &vlanID); diags.HasError() || vlanID.Unknown || !vlanID.Null {
+		return
+	}
+
+	netIface, err := net.InterfaceByName(iface.Value)
+	if err != nil {
+		return
+	}
+
+	prefixes, err := interfacePrefixes(netIface)
+	if err != nil {
+		return
+	}
+
+	if _, err := selectSourceIP(prefixes, addr, nil); err != nil {
+		resp.Diagnostics.AddAttributeError(
+			req.AttributePath,
+			"source IP not assigned to interface",
+			fmt.Sprintf("\"%s\" provided: %s is not an address of %s", ip, ip.Value, iface.Value))
+		return
+	}
+}
+
+// minIPv6PrefixBits is the shortest IPv6 prefix a network may hold. Every address in a network is probed, and a
+// shorter prefix holds more addresses than a sweep could get through before any timeout.
+const minIPv6PrefixBits = 112
+
 // networkValidator checks whether an attribute containing a list of CIDR prefixed (as strings) represents a valid netaddr.IPSet
 type networkValidator struct{}
 
@@ -142,15 +574,7 @@ func (v networkValidator) Validate(ctx context.Context, req tfsdk.ValidateAttrib
 		return
 	}
 
-	var data ipDataSource
-
-	req.Config.Get(ctx, &data)
-	if reflect.DeepEqual(data.provider.network, net.IPNet{}) && networkValue.IsNull() {
-		resp.Diagnostics.AddAttributeError(req.AttributePath,
-			"no networks specified", "`network` must be specified in either the provider or the datasource.")
-		return
-	}
-
+	// Data sources with no network fall back to the provider's, which is checked once the data source is read
 	if networkValue.IsNull() {
 		return
 	}
@@ -180,6 +604,15 @@ func (v networkValidator) Validate(ctx context.Context, req tfsdk.ValidateAttrib
 			return
 		}
 
+		if p.IP().Is6() && p.Bits() < minIPv6PrefixBits {
+			resp.Diagnostics.AddAttributeError(
+				req.AttributePath,
+				"IPv6 prefix too large",
+				fmt.Sprintf("\"%s\" provided: IPv6 prefixes must be /%d or longer, as every address in network is probed",
+					network, minIPv6PrefixBits))
+			return
+		}
+
 		builder.AddPrefix(p)
 	}
 
@@ -2,6 +2,7 @@ package arplookup
 
 import (
 	"context"
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/terraform-plugin-framework/attr"
@@ -9,6 +10,7 @@ import (
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
 	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
 )
 
 func TestNetInterfaceValidate(t *testing.T) {
@@ -60,6 +62,151 @@ func TestNetInterfaceValidate(t *testing.T) {
 	}
 }
 
+func TestIPValidate(t *testing.T) {
+	ctx := context.Background()
+
+	testcases := []struct {
+		ip     string
+		ipv6   bool
+		expect string
+	}{
+		{
+			ip:     "10.18.6.18",
+			expect: "",
+		},
+		{
+			ip:     "10.18.6",
+			expect: "malformed or invalid IP",
+		},
+		{
+			ip:     "fd18::6:18",
+			expect: "malformed or invalid IP",
+		},
+		{
+			ip:     "fd18::6:18",
+			ipv6:   true,
+			expect: "",
+		},
+	}
+
+	for _, test := range testcases {
+		v := ipValidator{ipv6: test.ipv6}
+
+		var ip attr.Value
+		diags := tfsdk.ValueFrom(ctx, test.ip, types.StringType, &ip)
+		if diags.HasError() {
+			t.Fatal("unable to marshal go value to terraform value")
+		}
+
+		req := tfsdk.ValidateAttributeRequest{
+			AttributePath:   path.Root("ip"),
+			AttributeConfig: ip,
+			Config:          tfsdk.Config{},
+		}
+		resp := &tfsdk.ValidateAttributeResponse{
+			Diagnostics: make(diag.Diagnostics, 0),
+		}
+
+		v.Validate(ctx, req, resp)
+		if resp.Diagnostics.HasError() && test.expect == "" {
+			t.Fatalf("validation failed: %s %s",
+				resp.Diagnostics[len(resp.Diagnostics)-1].Summary(),
+				resp.Diagnostics[len(resp.Diagnostics)-1].Detail())
+		}
+		if resp.Diagnostics.HasError() && test.expect != resp.Diagnostics[len(resp.Diagnostics)-1].Summary() {
+			t.Fatalf("unexpected error recieved: want %s, got %s",
+				test.expect,
+				resp.Diagnostics[len(resp.Diagnostics)-1].Summary())
+		}
+		if !resp.Diagnostics.HasError() && test.expect != "" {
+			t.Fatalf("expected error: %s", test.expect)
+		}
+	}
+
+	for _, v := range []ipValidator{{}, {ipv6: true}} {
+		description := v.Description(ctx)
+		if strings.Contains(description, "IPv6") != v.ipv6 || v.MarkdownDescription(ctx) != description {
+			t.Fatalf("unexpected description for validator accepting IPv6 addresses (%t): %s", v.ipv6, description)
+		}
+	}
+}
+
+func TestSourceIPValidate(t *testing.T) {
+	v := sourceIPValidator{}
+
+	ctx := context.Background()
+
+	schema := tfsdk.Schema{
+		Attributes: map[string]tfsdk.Attribute{
+			"interface": {Type: types.StringType, Required: true},
+			"vlan_id":   {Type: types.Int64Type, Optional: true},
+			"source_ip": {Type: types.StringType, Optional: true},
+		},
+	}
+
+	testcases := []struct {
+		ip     string
+		vlanID interface{}
+		expect string
+	}{
+		{
+			ip:     "127.0.0.1",
+			expect: "",
+		},
+		{
+			ip:     "10.18.6.18",
+			expect: "source IP not assigned to interface",
+		},
+		{
+			ip:     "10.18.6.18",
+			vlanID: 10,
+			expect: "",
+		},
+		{
+			ip:     "::1",
+			expect: "malformed or invalid IP",
+		},
+	}
+
+	for _, test := range testcases {
+		var ip attr.Value
+		diags := tfsdk.ValueFrom(ctx, test.ip, types.StringType, &ip)
+		if diags.HasError() {
+			t.Fatal("unable to marshal go value to terraform value")
+		}
+
+		raw := tftypes.NewValue(schema.TerraformType(ctx), map[string]tftypes.Value{
+			"interface": tftypes.NewValue(tftypes.String, "lo"),
+			"vlan_id":   tftypes.NewValue(tftypes.Number, test.vlanID),
+			"source_ip": tftypes.NewValue(tftypes.String, test.ip),
+		})
+
+		req := tfsdk.ValidateAttributeRequest{
+			AttributePath:   path.Root("source_ip"),
+			AttributeConfig: ip,
+			Config:          tfsdk.Config{Raw: raw, Schema: schema},
+		}
+		resp := &tfsdk.ValidateAttributeResponse{
+			Diagnostics: make(diag.Diagnostics, 0),
+		}
+
+		v.Validate(ctx, req, resp)
+		if resp.Diagnostics.HasError() && test.expect == "" {
+			t.Fatalf("validation failed: %s %s",
+				resp.Diagnostics[len(resp.Diagnostics)-1].Summary(),
+				resp.Diagnostics[len(resp.Diagnostics)-1].Detail())
+		}
+		if resp.Diagnostics.HasError() && test.expect != resp.Diagnostics[len(resp.Diagnostics)-1].Summary() {
+			t.Fatalf("unexpected error recieved: want %s, got %s",
+				test.expect,
+				resp.Diagnostics[len(resp.Diagnostics)-1].Summary())
+		}
+		if !resp.Diagnostics.HasError() && test.expect != "" {
+			t.Fatalf("expected error: %s", test.expect)
+		}
+	}
+}
+
 func TestMACValidate(t *testing.T) {
 	v := macValidator{}
 
@@ -109,6 +256,110 @@ func TestMACValidate(t *testing.T) {
 	}
 }
 
+func TestMACSetValidate(t *testing.T) {
+	v := macSetValidator{}
+
+	ctx := context.Background()
+
+	testcases := []struct {
+		macs   []string
+		expect string
+	}{
+		{
+			macs:   []string{"00:00:00:00:00:00", "3e:50:6e:54:28:3d"},
+			expect: "",
+		},
+		{
+			macs:   []string{"00:00:00:00:00:00", "xx:xx:xx:xx:xx:xx"},
+			expect: "malformed or invalid MAC",
+		},
+	}
+
+	for _, test := range testcases {
+		var macs attr.Value
+		diags := tfsdk.ValueFrom(ctx, test.macs, types.SetType{ElemType: types.StringType}, &macs)
+		if diags.HasError() {
+			t.Fatal("unable to marshal go value to terraform value")
+		}
+
+		req := tfsdk.ValidateAttributeRequest{
+			AttributePath:   path.Root("macaddrs"),
+			AttributeConfig: macs,
+			Config:          tfsdk.Config{},
+		}
+		resp := &tfsdk.ValidateAttributeResponse{
+			Diagnostics: make(diag.Diagnostics, 0),
+		}
+
+		v.Validate(ctx, req, resp)
+		if resp.Diagnostics.HasError() && test.expect == "" {
+			t.Fatalf("validation failed: %s %s",
+				resp.Diagnostics[len(resp.Diagnostics)-1].Summary(),
+				resp.Diagnostics[len(resp.Diagnostics)-1].Detail())
+		}
+		if resp.Diagnostics.HasError() && test.expect != resp.Diagnostics[len(resp.Diagnostics)-1].Summary() {
+			t.Fatalf("unexpected error recieved: want %s, got %s",
+				test.expect,
+				resp.Diagnostics[len(resp.Diagnostics)-1].Summary())
+		}
+		if !resp.Diagnostics.HasError() && test.expect != "" {
+			t.Fatalf("expected error: %s", test.expect)
+		}
+	}
+}
+
+func TestNeighStatesValidate(t *testing.T) {
+	v := neighStatesValidator{}
+
+	ctx := context.Background()
+
+	testcases := []struct {
+		states []string
+		expect string
+	}{
+		{
+			states: []string{"reachable", "PERMANENT"},
+			expect: "",
+		},
+		{
+			states: []string{"reachable", "forgotten"},
+			expect: "invalid neighbour state",
+		},
+	}
+
+	for _, test := range testcases {
+		var states attr.Value
+		diags := tfsdk.ValueFrom(ctx, test.states, types.SetType{ElemType: types.StringType}, &states)
+		if diags.HasError() {
+			t.Fatal("unable to marshal go value to terraform value")
+		}
+
+		req := tfsdk.ValidateAttributeRequest{
+			AttributePath:   path.Root("accept_states"),
+			AttributeConfig: states,
+			Config:          tfsdk.Config{},
+		}
+		resp := &tfsdk.ValidateAttributeResponse{
+			Diagnostics: make(diag.Diagnostics, 0),
+		}
+
+		v.Validate(ctx, req, resp)
+		if resp.Diagnostics.HasError() && test.expect == "" {
+			t.Fatalf("validation failed: %s %s",
+				resp.Diagnostics[len(resp.Diagnostics)-1].Summary(),
+				resp.Diagnostics[len(resp.Diagnostics)-1].Detail())
+		}
+		if resp.Diagnostics.HasError() && test.expect != resp.Diagnostics[len(resp.Diagnostics)-1].Summary() {
+			t.Fatalf("unexpected error recieved: want %s, got %s",
+				test.expect,
+				resp.Diagnostics[len(resp.Diagnostics)-1].Summary())
+		}
+		if !resp.Diagnostics.HasError() && test.expect != "" {
+			t.Fatalf("expected error: %s", test.expect)
+		}
+	}
+}
+
 func TestTimeValidate(t *testing.T) {
 	v := timeValidator{}
 
@@ -158,6 +409,342 @@ func TestTimeValidate(t *testing.T) {
 	}
 }
 
+func TestScanModeValidate(t *testing.T) {
+	v := scanModeValidator{}
+
+	ctx := context.Background()
+
+	testcases := []struct {
+		mode   string
+		expect string
+	}{
+		{
+			mode:   "request",
+			expect: "",
+		},
+		{
+			mode:   "async",
+			expect: "",
+		},
+		{
+			mode:   "passive",
+			expect: "invalid scan mode",
+		},
+	}
+
+	for _, test := range testcases {
+		var mode attr.Value
+		diags := tfsdk.ValueFrom(ctx, test.mode, types.StringType, &mode)
+		if diags.HasError() {
+			t.Fatal("unable to marshal go value to terraform value")
+		}
+
+		req := tfsdk.ValidateAttributeRequest{
+			AttributePath:   path.Root("scan_mode"),
+			AttributeConfig: mode,
+			Config:          tfsdk.Config{},
+		}
+		resp := &tfsdk.ValidateAttributeResponse{
+			Diagnostics: make(diag.Diagnostics, 0),
+		}
+
+		v.Validate(ctx, req, resp)
+		if resp.Diagnostics.HasError() && test.expect == "" {
+			t.Fatalf("validation failed: %s %s",
+				resp.Diagnostics[len(resp.Diagnostics)-1].Summary(),
+				resp.Diagnostics[len(resp.Diagnostics)-1].Detail())
+		}
+		if resp.Diagnostics.HasError() && test.expect != resp.Diagnostics[len(resp.Diagnostics)-1].Summary() {
+			t.Fatalf("unexpected error recieved: want %s, got %s",
+				test.expect,
+				resp.Diagnostics[len(resp.Diagnostics)-1].Summary())
+		}
+		if !resp.Diagnostics.HasError() && test.expect != "" {
+			t.Fatalf("expected error: %s", test.expect)
+		}
+	}
+}
+
+func TestTransportValidate(t *testing.T) {
+	v := transportValidator{}
+
+	ctx := context.Background()
+
+	testcases := []struct {
+		transport string
+		expect    string
+	}{
+		{
+			transport: "socket",
+			expect:    "",
+		},
+		{
+			transport: "ring",
+			expect:    "",
+		},
+		{
+			transport: "xdp",
+			expect:    "invalid transport",
+		},
+	}
+
+	for _, test := range testcases {
+		var transport attr.Value
+		diags := tfsdk.ValueFrom(ctx, test.transport, types.StringType, &transport)
+		if diags.HasError() {
+			t.Fatal("unable to marshal go value to terraform value")
+		}
+
+		req := tfsdk.ValidateAttributeRequest{
+			AttributePath:   path.Root("transport"),
+			AttributeConfig: transport,
+			Config:          tfsdk.Config{},
+		}
+		resp := &tfsdk.ValidateAttributeResponse{
+			Diagnostics: make(diag.Diagnostics, 0),
+		}
+
+		v.Validate(ctx, req, resp)
+		if resp.Diagnostics.HasError() && test.expect == "" {
+			t.Fatalf("validation failed: %s %s",
+				resp.Diagnostics[len(resp.Diagnostics)-1].Summary(),
+				resp.Diagnostics[len(resp.Diagnostics)-1].Detail())
+		}
+		if resp.Diagnostics.HasError() && test.expect != resp.Diagnostics[len(resp.Diagnostics)-1].Summary() {
+			t.Fatalf("unexpected error recieved: want %s, got %s",
+				test.expect,
+				resp.Diagnostics[len(resp.Diagnostics)-1].Summary())
+		}
+		if !resp.Diagnostics.HasError() && test.expect != "" {
+			t.Fatalf("expected error: %s", test.expect)
+		}
+	}
+}
+
+func TestCacheStateValidate(t *testing.T) {
+	v := cacheStateValidator{}
+
+	ctx := context.Background()
+
+	testcases := []struct {
+		state  string
+		expect string
+	}{
+		{
+			state:  "reachable",
+			expect: "",
+		},
+		{
+			state:  "Permanent",
+			expect: "",
+		},
+		{
+			state:  "incomplete",
+			expect: "invalid cache state",
+		},
+	}
+
+	for _, test := range testcases {
+		var state attr.Value
+		diags := tfsdk.ValueFrom(ctx, test.state, types.StringType, &state)
+		if diags.HasError() {
+			t.Fatal("unable to marshal go value to terraform value")
+		}
+
+		req := tfsdk.ValidateAttributeRequest{
+			AttributePath:   path.Root("cache_state"),
+			AttributeConfig: state,
+			Config:          tfsdk.Config{},
+		}
+		resp := &tfsdk.ValidateAttributeResponse{
+			Diagnostics: make(diag.Diagnostics, 0),
+		}
+
+		v.Validate(ctx, req, resp)
+		if resp.Diagnostics.HasError() && test.expect == "" {
+			t.Fatalf("validation failed: %s %s",
+				resp.Diagnostics[len(resp.Diagnostics)-1].Summary(),
+				resp.Diagnostics[len(resp.Diagnostics)-1].Detail())
+		}
+		if resp.Diagnostics.HasError() && test.expect != resp.Diagnostics[len(resp.Diagnostics)-1].Summary() {
+			t.Fatalf("unexpected error recieved: want %s, got %s",
+				test.expect,
+				resp.Diagnostics[len(resp.Diagnostics)-1].Summary())
+		}
+		if !resp.Diagnostics.HasError() && test.expect != "" {
+			t.Fatalf("expected error: %s", test.expect)
+		}
+	}
+}
+
+func TestLeaseFormatValidate(t *testing.T) {
+	v := leaseFormatValidator{}
+
+	ctx := context.Background()
+
+	testcases := []struct {
+		format string
+		expect string
+	}{
+		{
+			format: "dnsmasq",
+			expect: "",
+		},
+		{
+			format: "kea",
+			expect: "",
+		},
+		{
+			format: "udhcpd",
+			expect: "invalid lease file format",
+		},
+	}
+
+	for _, test := range testcases {
+		var format attr.Value
+		diags := tfsdk.ValueFrom(ctx, test.format, types.StringType, &format)
+		if diags.HasError() {
+			t.Fatal("unable to marshal go value to terraform value")
+		}
+
+		req := tfsdk.ValidateAttributeRequest{
+			AttributePath:   path.Root("lease_files").AtListIndex(0).AtName("format"),
+			AttributeConfig: format,
+			Config:          tfsdk.Config{},
+		}
+		resp := &tfsdk.ValidateAttributeResponse{
+			Diagnostics: make(diag.Diagnostics, 0),
+		}
+
+		v.Validate(ctx, req, resp)
+		if resp.Diagnostics.HasError() && test.expect == "" {
+			t.Fatalf("validation failed: %s %s",
+				resp.Diagnostics[len(resp.Diagnostics)-1].Summary(),
+				resp.Diagnostics[len(resp.Diagnostics)-1].Detail())
+		}
+		if resp.Diagnostics.HasError() && test.expect != resp.Diagnostics[len(resp.Diagnostics)-1].Summary() {
+			t.Fatalf("unexpected error recieved: want %s, got %s",
+				test.expect,
+				resp.Diagnostics[len(resp.Diagnostics)-1].Summary())
+		}
+		if !resp.Diagnostics.HasError() && test.expect != "" {
+			t.Fatalf("expected error: %s", test.expect)
+		}
+	}
+}
+
+func TestPositiveIntValidate(t *testing.T) {
+	v := positiveIntValidator{}
+
+	ctx := context.Background()
+
+	testcases := []struct {
+		number int64
+		expect string
+	}{
+		{
+			number: 8,
+			expect: "",
+		},
+		{
+			number: 0,
+			expect: "invalid number",
+		},
+		{
+			number: -1,
+			expect: "invalid number",
+		},
+	}
+
+	for _, test := range testcases {
+		var number attr.Value
+		diags := tfsdk.ValueFrom(ctx, test.number, types.Int64Type, &number)
+		if diags.HasError() {
+			t.Fatal("unable to marshal go value to terraform value")
+		}
+
+		req := tfsdk.ValidateAttributeRequest{
+			AttributePath:   path.Root("parallelism"),
+			AttributeConfig: number,
+			Config:          tfsdk.Config{},
+		}
+		resp := &tfsdk.ValidateAttributeResponse{
+			Diagnostics: make(diag.Diagnostics, 0),
+		}
+
+		v.Validate(ctx, req, resp)
+		if resp.Diagnostics.HasError() && test.expect == "" {
+			t.Fatalf("validation failed: %s %s",
+				resp.Diagnostics[len(resp.Diagnostics)-1].Summary(),
+				resp.Diagnostics[len(resp.Diagnostics)-1].Detail())
+		}
+		if resp.Diagnostics.HasError() && test.expect != resp.Diagnostics[len(resp.Diagnostics)-1].Summary() {
+			t.Fatalf("unexpected error recieved: want %s, got %s",
+				test.expect,
+				resp.Diagnostics[len(resp.Diagnostics)-1].Summary())
+		}
+		if !resp.Diagnostics.HasError() && test.expect != "" {
+			t.Fatalf("expected error: %s", test.expect)
+		}
+	}
+}
+
+func TestVLANIDValidate(t *testing.T) {
+	v := vlanIDValidator{}
+
+	ctx := context.Background()
+
+	testcases := []struct {
+		number int64
+		expect string
+	}{
+		{
+			number: 8,
+			expect: "",
+		},
+		{
+			number: 0,
+			expect: "invalid VLAN ID",
+		},
+		{
+			number: 4095,
+			expect: "invalid VLAN ID",
+		},
+	}
+
+	for _, test := range testcases {
+		var number attr.Value
+		diags := tfsdk.ValueFrom(ctx, test.number, types.Int64Type, &number)
+		if diags.HasError() {
+			t.Fatal("unable to marshal go value to terraform value")
+		}
+
+		req := tfsdk.ValidateAttributeRequest{
+			AttributePath:   path.Root("vlan_id"),
+			AttributeConfig: number,
+			Config:          tfsdk.Config{},
+		}
+		resp := &tfsdk.ValidateAttributeResponse{
+			Diagnostics: make(diag.Diagnostics, 0),
+		}
+
+		v.Validate(ctx, req, resp)
+		if resp.Diagnostics.HasError() && test.expect == "" {
+			t.Fatalf("validation failed: %s %s",
+				resp.Diagnostics[len(resp.Diagnostics)-1].Summary(),
+				resp.Diagnostics[len(resp.Diagnostics)-1].Detail())
+		}
+		if resp.Diagnostics.HasError() && test.expect != resp.Diagnostics[len(resp.Diagnostics)-1].Summary() {
+			t.Fatalf("unexpected error recieved: want %s, got %s",
+				test.expect,
+				resp.Diagnostics[len(resp.Diagnostics)-1].Summary())
+		}
+		if !resp.Diagnostics.HasError() && test.expect != "" {
+			t.Fatalf("expected error: %s", test.expect)
+		}
+	}
+}
+
 func TestNetworkValidate(t *testing.T) {
 	v := networkValidator{}
 
@@ -197,6 +784,22 @@ func TestNetworkValidate(t *testing.T) {
 			},
 			expect: "provided IP prefixes create an invalid set of IPs",
 		},
+		{
+			name: "small ipv6 prefix",
+			network: []string{
+				"10.0.0.10/24",
+				"fd18::/120",
+			},
+			expect: "",
+		},
+		{
+			name: "large ipv6 prefix",
+			network: []string{
+				"10.0.0.10/24",
+				"fd18::/64",
+			},
+			expect: "IPv6 prefix too large",
+		},
 	}
 
 	for _, test := range testcases {
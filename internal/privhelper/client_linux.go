@@ -0,0 +1,115 @@
+package privhelper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Client requests packet sockets from a running helper.
+type Client struct {
+	mu  sync.Mutex // the socket carries one request at a time
+	fd  int
+	cmd *exec.Cmd
+}
+
+// Start runs the helper binary at path, passing it one end of a socket pair as file descriptor 3. The helper is
+// killed if the provider exits without closing the client.
+func Start(path string) (*Client, error) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create helper socket: %w", err)
+	}
+
+	child := os.NewFile(uintptr(fds[1]), "privhelper")
+	defer child.Close()
+
+	cmd := exec.Command(path)
+	cmd.ExtraFiles = []*os.File{child}
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+	if err := cmd.Start(); err != nil {
+		unix.Close(fds[0])
+		return nil, fmt.Errorf("unable to start helper %s: %w", path, err)
+	}
+
+	return &Client{fd: fds[0], cmd: cmd}, nil
+}
+
+// NewClient creates a client that talks to a helper over fd, which is one end of a SOCK_SEQPACKET socket pair.
+func NewClient(fd int) *Client {
+	return &Client{fd: fd}
+}
+
+// ListenPacket asks the helper for a packet socket of the given type bound to iface, returning it as a
+// net.PacketConn whose addresses are *packet.Addr.
+func (c *Client) ListenPacket(iface *net.Interface, typ string, protocol uint16) (net.PacketConn, error) {
+	fd, err := c.request(Request{Interface: iface.Name, Type: typ, Protocol: protocol})
+	if err != nil {
+		return nil, err
+	}
+
+	return newConn(fd, iface, protocol)
+}
+
+// request sends req to the helper, returning the file descriptor it responds with.
+func (c *Client) request(req Request) (int, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return -1, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := unix.Sendmsg(c.fd, b, nil, nil, 0); err != nil {
+		return -1, fmt.Errorf("unable to send request to helper: %w", err)
+	}
+
+	buf := make([]byte, maxMessage)
+	oob := make([]byte, unix.CmsgSpace(4))
+	n, oobn, _, _, err := unix.Recvmsg(c.fd, buf, oob, unix.MSG_CMSG_CLOEXEC)
+	if err != nil {
+		return -1, fmt.Errorf("unable to read response from helper: %w", err)
+	}
+	if n == 0 {
+		return -1, errors.New("helper exited")
+	}
+
+	var resp Response
+	if err := json.Unmarshal(buf[:n], &resp); err != nil {
+		return -1, fmt.Errorf("malformed response from helper: %w", err)
+	}
+	if resp.Error != "" {
+		return -1, fmt.Errorf("helper: %s", resp.Error)
+	}
+
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) != 1 {
+		return -1, errors.New("helper did not send a socket")
+	}
+
+	fds, err := unix.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) != 1 {
+		return -1, errors.New("helper did not send a socket")
+	}
+
+	return fds[0], nil
+}
+
+// Close closes the client's end of the socket, which makes the helper exit, and waits for it to do so.
+func (c *Client) Close() error {
+	err := unix.Close(c.fd)
+	if c.cmd != nil {
+		c.cmd.Wait()
+	}
+
+	return err
+}
@@ -0,0 +1,151 @@
+package privhelper
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/mdlayher/packet"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// conn is a net.PacketConn over a packet socket received from the helper.
+type conn struct {
+	file     *os.File
+	raw      syscall.RawConn
+	iface    *net.Interface
+	protocol uint16
+}
+
+var _ net.PacketConn = &conn{}
+
+// newConn wraps fd, which must be a non-blocking packet socket, so that it is read through the runtime's poller and
+// supports deadlines.
+func newConn(fd int, iface *net.Interface, protocol uint16) (*conn, error) {
+	file := os.NewFile(uintptr(fd), "packet")
+	raw, err := file.SyscallConn()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &conn{file: file, raw: raw, iface: iface, protocol: protocol}, nil
+}
+
+// ReadFrom implements net.PacketConn.
+func (c *conn) ReadFrom(b []byte) (int, net.Addr, error) {
+	var (
+		n    int
+		from unix.Sockaddr
+		err  error
+	)
+	rerr := c.raw.Read(func(fd uintptr) bool {
+		n, from, err = unix.Recvfrom(int(fd), b, 0)
+		return err != unix.EAGAIN
+	})
+	if rerr != nil {
+		return 0, nil, c.opError("read", rerr)
+	}
+	if err != nil {
+		return 0, nil, c.opError("read", err)
+	}
+
+	addr := &packet.Addr{}
+	if sa, ok := from.(*unix.SockaddrLinklayer); ok {
+		addr.HardwareAddr = net.HardwareAddr(sa.Addr[:sa.Halen])
+	}
+
+	return n, addr, nil
+}
+
+// WriteTo implements net.PacketConn. addr must be a *packet.Addr.
+func (c *conn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	dst, ok := addr.(*packet.Addr)
+	if !ok {
+		return 0, c.opError("write", fmt.Errorf("invalid address type %T", addr))
+	}
+
+	sa := &unix.SockaddrLinklayer{
+		Protocol: htons(c.protocol),
+		Ifindex:  c.iface.Index,
+		Halen:    uint8(len(dst.HardwareAddr)),
+	}
+	copy(sa.Addr[:], dst.HardwareAddr)
+
+	var err error
+	werr := c.raw.Write(func(fd uintptr) bool {
+		err = unix.Sendto(int(fd), b, 0, sa)
+		return err != unix.EAGAIN
+	})
+	if werr != nil {
+		return 0, c.opError("write", werr)
+	}
+	if err != nil {
+		return 0, c.opError("write", err)
+	}
+
+	return len(b), nil
+}
+
+// Close implements net.PacketConn.
+func (c *conn) Close() error {
+	return c.file.Close()
+}
+
+// LocalAddr implements net.PacketConn.
+func (c *conn) LocalAddr() net.Addr {
+	return &packet.Addr{HardwareAddr: c.iface.HardwareAddr}
+}
+
+// SetDeadline implements net.PacketConn.
+func (c *conn) SetDeadline(t time.Time) error {
+	return c.file.SetDeadline(t)
+}
+
+// SetReadDeadline implements net.PacketConn.
+func (c *conn) SetReadDeadline(t time.Time) error {
+	return c.file.SetReadDeadline(t)
+}
+
+// SetWriteDeadline implements net.PacketConn.
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	return c.file.SetWriteDeadline(t)
+}
+
+// SyscallConn implements syscall.Conn, so that the socket can be set up beyond what net.PacketConn offers.
+func (c *conn) SyscallConn() (syscall.RawConn, error) {
+	return c.raw, nil
+}
+
+// SetBPF attaches a classic BPF program to the socket, as packet.Conn does for sockets opened in-process.
+func (c *conn) SetBPF(filter []bpf.RawInstruction) error {
+	if len(filter) == 0 {
+		return c.opError("setsockopt", unix.EINVAL)
+	}
+
+	instructions := make([]unix.SockFilter, len(filter))
+	for i, ins := range filter {
+		instructions[i] = unix.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+	prog := &unix.SockFprog{Len: uint16(len(instructions)), Filter: &instructions[0]}
+
+	var err error
+	cerr := c.raw.Control(func(fd uintptr) {
+		err = unix.SetsockoptSockFprog(int(fd), unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, prog)
+	})
+	if cerr != nil {
+		return c.opError("setsockopt", cerr)
+	}
+	if err != nil {
+		return c.opError("setsockopt", err)
+	}
+
+	return nil
+}
+
+func (c *conn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "packet", Addr: c.LocalAddr(), Err: err}
+}
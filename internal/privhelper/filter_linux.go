@@ -0,0 +1,83 @@
+package privhelper
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// filterAccept is how many bytes of an accepted packet are passed to the socket, which covers all of any packet.
+const filterAccept = 0xffff
+
+// Offsets into the packets passed to the filters.
+const (
+	frameEtherType = 12 // EtherType of an ethernet frame
+	ipv4Protocol   = 9  // protocol of the payload of an IPv4 packet
+	ipv4Fragment   = 6  // flags and fragment offset of an IPv4 packet
+	udpSrcPort     = 0  // source port, from the start of a UDP header
+	udpDstPort     = 2  // destination port, from the start of a UDP header
+)
+
+// DHCP ports replies are sent from and to.
+const (
+	dhcpServerPort = 67
+	dhcpClientPort = 68
+)
+
+// ARPFilter assembles a classic BPF program for a raw packet socket that only passes ARP frames.
+func ARPFilter() ([]bpf.RawInstruction, error) {
+	return bpf.Assemble([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: frameEtherType, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(ProtocolARP), SkipFalse: 1},
+		bpf.RetConstant{Val: filterAccept},
+		bpf.RetConstant{Val: 0},
+	})
+}
+
+// DHCPFilter assembles a classic BPF program for an IPv4 datagram packet socket that only passes UDP datagrams sent
+// from the DHCP server port to the client port, which carry DHCP replies. Fragments after the first are dropped,
+// as they have no UDP header to match.
+func DHCPFilter() ([]bpf.RawInstruction, error) {
+	return bpf.Assemble([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: ipv4Protocol, Size: 1},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: unix.IPPROTO_UDP, SkipFalse: 8},
+		bpf.LoadAbsolute{Off: ipv4Fragment, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpBitsSet, Val: 0x1fff, SkipTrue: 6},
+		// X is set to the length of the IPv4 header, so that the UDP header is read from after it
+		bpf.LoadMemShift{Off: 0},
+		bpf.LoadIndirect{Off: udpSrcPort, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: dhcpServerPort, SkipFalse: 3},
+		bpf.LoadIndirect{Off: udpDstPort, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: dhcpClientPort, SkipFalse: 1},
+		bpf.RetConstant{Val: filterAccept},
+		bpf.RetConstant{Val: 0},
+	})
+}
+
+// filterFor returns the filter the helper locks onto sockets opened for req, which must be valid.
+func filterFor(req Request) ([]bpf.RawInstruction, error) {
+	if req.Protocol == ProtocolARP {
+		return ARPFilter()
+	}
+
+	return DHCPFilter()
+}
+
+// lockFilter attaches prog to sock and locks it, so that it can't be replaced or removed by whoever holds sock.
+func lockFilter(sock int, prog []bpf.RawInstruction) error {
+	fprog := unix.SockFprog{
+		Len:    uint16(len(prog)),
+		Filter: (*unix.SockFilter)(unsafe.Pointer(&prog[0])),
+	}
+	if err := unix.SetsockoptSockFprog(sock, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &fprog); err != nil {
+		return fmt.Errorf("unable to attach socket filter: %w", err)
+	}
+
+	if err := unix.SetsockoptInt(sock, unix.SOL_SOCKET, unix.SO_LOCK_FILTER, 1); err != nil {
+		return fmt.Errorf("unable to lock socket filter: %w", err)
+	}
+
+	return nil
+}
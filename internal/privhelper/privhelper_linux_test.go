@@ -0,0 +1,171 @@
+package privhelper
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// mkTestClient serves requests from an in-process socket pair, returning a client for it.
+func mkTestClient(t *testing.T) *Client {
+	t.Helper()
+
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- Serve(fds[1])
+		unix.Close(fds[1])
+	}()
+
+	client := NewClient(fds[0])
+	t.Cleanup(func() {
+		client.Close()
+		if err := <-done; err != nil {
+			t.Errorf("expected helper to exit cleanly, got: %v", err)
+		}
+	})
+
+	return client
+}
+
+// TestListenPacketRefused checks whether the helper refuses sockets for protocols and interfaces it shouldn't
+// open, reporting why to the client.
+func TestListenPacketRefused(t *testing.T) {
+	client := mkTestClient(t)
+
+	testcases := []struct {
+		iface    *net.Interface
+		typ      string
+		protocol uint16
+		expected string
+	}{
+		{
+			iface:    &net.Interface{Name: "lo"},
+			typ:      TypeRaw,
+			protocol: 0x86dd,
+			expected: "not allowed",
+		},
+		{
+			iface:    &net.Interface{Name: "lo"},
+			typ:      TypeDatagram,
+			protocol: ProtocolARP,
+			expected: "not allowed",
+		},
+		{
+			iface:    &net.Interface{Name: "arplookup-none"},
+			typ:      TypeRaw,
+			protocol: ProtocolARP,
+			expected: "no such network interface",
+		},
+	}
+
+	for _, test := range testcases {
+		conn, err := client.ListenPacket(test.iface, test.typ, test.protocol)
+		if err == nil {
+			conn.Close()
+			t.Fatalf("expected %s socket for %#04x on %s to be refused", test.typ, test.protocol, test.iface.Name)
+		}
+		if !strings.Contains(err.Error(), test.expected) {
+			t.Fatalf("expected error containing \"%s\", got: %v", test.expected, err)
+		}
+	}
+}
+
+// TestFilters checks whether the filters locked onto the helper's sockets only pass the traffic they are opened for.
+func TestFilters(t *testing.T) {
+	// IPv4 header of 20 bytes followed by a UDP header
+	udp := func(protocol byte, fragment uint16, src uint16, dst uint16) []byte {
+		b := make([]byte, 28)
+		b[0] = 0x45
+		b[6], b[7] = byte(fragment>>8), byte(fragment)
+		b[9] = protocol
+		b[20], b[21] = byte(src>>8), byte(src)
+		b[22], b[23] = byte(dst>>8), byte(dst)
+		return b
+	}
+	frame := func(etherType uint16) []byte {
+		b := make([]byte, 42)
+		b[12], b[13] = byte(etherType>>8), byte(etherType)
+		return b
+	}
+
+	testcases := []struct {
+		name     string
+		filter   func() ([]bpf.RawInstruction, error)
+		pkt      []byte
+		expected bool
+	}{
+		{name: "ARP frame", filter: ARPFilter, pkt: frame(ProtocolARP), expected: true},
+		{name: "IPv4 frame", filter: ARPFilter, pkt: frame(ProtocolIPv4), expected: false},
+		{name: "DHCP reply", filter: DHCPFilter, pkt: udp(unix.IPPROTO_UDP, 0, 67, 68), expected: true},
+		{name: "DHCP request", filter: DHCPFilter, pkt: udp(unix.IPPROTO_UDP, 0, 68, 67), expected: false},
+		{name: "DNS reply", filter: DHCPFilter, pkt: udp(unix.IPPROTO_UDP, 0, 53, 68), expected: false},
+		{name: "TCP segment", filter: DHCPFilter, pkt: udp(unix.IPPROTO_TCP, 0, 67, 68), expected: false},
+		{name: "later fragment", filter: DHCPFilter, pkt: udp(unix.IPPROTO_UDP, 0x0010, 67, 68), expected: false},
+	}
+
+	for _, test := range testcases {
+		raw, err := test.filter()
+		if err != nil {
+			t.Fatalf("%s: unable to assemble filter: %s", test.name, err.Error())
+		}
+
+		prog, ok := bpf.Disassemble(raw)
+		if !ok {
+			t.Fatalf("%s: unable to disassemble filter", test.name)
+		}
+
+		vm, err := bpf.NewVM(prog)
+		if err != nil {
+			t.Fatalf("%s: invalid filter: %s", test.name, err.Error())
+		}
+
+		n, err := vm.Run(test.pkt)
+		if err != nil {
+			t.Fatalf("%s: error encountered while running filter: %s", test.name, err.Error())
+		}
+		if (n > 0) != test.expected {
+			t.Fatalf("%s: expected filter to pass packet: %t, got %d bytes passed", test.name, test.expected, n)
+		}
+	}
+}
+
+// TestListenPacketFilterLocked checks whether the filter of a socket from the helper can't be replaced by the client.
+func TestListenPacketFilterLocked(t *testing.T) {
+	client := mkTestClient(t)
+
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sock, err := client.ListenPacket(lo, TypeRaw, ProtocolARP)
+	// The helper reports errors as text, so a refusal by the kernel is recognised by its message
+	if err != nil && strings.Contains(err.Error(), "operation not permitted") {
+		t.Skip("NET_RAW is needed to open packet sockets")
+	}
+	if err != nil {
+		t.Fatalf("unable to open socket: %s", err.Error())
+	}
+	defer sock.Close()
+
+	c, ok := sock.(*conn)
+	if !ok {
+		t.Fatalf("expected socket from helper, got %T", sock)
+	}
+
+	accept, err := bpf.Assemble([]bpf.Instruction{bpf.RetConstant{Val: 0xffff}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetBPF(accept); err == nil {
+		t.Fatalf("expected filter of helper socket to be locked")
+	}
+}
@@ -0,0 +1,59 @@
+// Package privhelper implements a small privileged helper that opens packet sockets on behalf of the provider, so
+// that only the helper binary needs the NET_RAW capability. The provider starts the helper with one end of a Unix
+// socket pair, sends it a Request for each socket it needs, and receives the socket's file descriptor back with
+// the Response.
+package privhelper
+
+import (
+	"fmt"
+)
+
+// Protocols the helper will open packet sockets for. Anything else is refused, and every socket is handed over with
+// a locked filter passing only ARP frames or DHCP replies, so that the helper cannot be used to sniff arbitrary
+// traffic even by rebinding the socket to another protocol.
+const (
+	ProtocolARP  uint16 = 0x0806 // ARP, read and written as whole ethernet frames
+	ProtocolIPv4 uint16 = 0x0800 // IPv4, read without the ethernet header to snoop DHCP replies
+)
+
+// Socket types a packet socket can be opened with.
+const (
+	TypeRaw      = "raw"      // frames include the link-level header
+	TypeDatagram = "datagram" // frames have the link-level header removed
+)
+
+// maxMessage is the largest request or response sent over the socket.
+const maxMessage = 4096
+
+// Request asks the helper to open a packet socket bound to an interface.
+type Request struct {
+	Interface string `json:"interface"`
+	Type      string `json:"type"`
+	Protocol  uint16 `json:"protocol"`
+}
+
+// Response answers a Request. If Error is empty the socket's file descriptor accompanies the response.
+type Response struct {
+	Error string `json:"error,omitempty"`
+}
+
+// validate checks whether req is for a protocol and socket type the helper allows.
+func (req Request) validate() error {
+	switch {
+	case req.Protocol == ProtocolARP && req.Type == TypeRaw:
+	case req.Protocol == ProtocolIPv4 && req.Type == TypeDatagram:
+	default:
+		return fmt.Errorf("%s sockets for protocol %#04x are not allowed", req.Type, req.Protocol)
+	}
+
+	if req.Interface == "" {
+		return fmt.Errorf("no interface given")
+	}
+
+	return nil
+}
+
+// htons converts a protocol number to network byte order, as expected by packet sockets.
+func htons(i uint16) uint16 {
+	return (i<<8)&0xff00 | i>>8
+}
@@ -0,0 +1,108 @@
+package privhelper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// Serve answers requests read from fd, the helper's end of the socket pair, until the provider closes its end.
+func Serve(fd int) error {
+	buf := make([]byte, maxMessage)
+	for {
+		n, _, _, _, err := unix.Recvmsg(fd, buf, nil, 0)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read request: %w", err)
+		}
+		if n == 0 {
+			return nil
+		}
+
+		var req Request
+		if err := json.Unmarshal(buf[:n], &req); err != nil {
+			if err := respond(fd, Response{Error: fmt.Sprintf("malformed request: %s", err.Error())}, -1); err != nil {
+				return err
+			}
+			continue
+		}
+
+		sock, err := open(req)
+		if err != nil {
+			if err := respond(fd, Response{Error: err.Error()}, -1); err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = respond(fd, Response{}, sock)
+		unix.Close(sock)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// open opens the packet socket described by req, with a filter locked onto it that only passes the traffic its
+// protocol is allowed for. The socket is opened for no protocol, so it receives nothing until the filter is in place,
+// and is then bound to the one requested.
+func open(req Request) (int, error) {
+	if err := req.validate(); err != nil {
+		return -1, err
+	}
+
+	iface, err := net.InterfaceByName(req.Interface)
+	if err != nil {
+		return -1, err
+	}
+
+	typ := unix.SOCK_RAW
+	if req.Type == TypeDatagram {
+		typ = unix.SOCK_DGRAM
+	}
+
+	prog, err := filterFor(req)
+	if err != nil {
+		return -1, err
+	}
+
+	sock, err := unix.Socket(unix.AF_PACKET, typ|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return -1, fmt.Errorf("unable to open packet socket: %w", err)
+	}
+
+	if err := lockFilter(sock, prog); err != nil {
+		unix.Close(sock)
+		return -1, err
+	}
+
+	if err := unix.Bind(sock, &unix.SockaddrLinklayer{Protocol: htons(req.Protocol), Ifindex: iface.Index}); err != nil {
+		unix.Close(sock)
+		return -1, fmt.Errorf("unable to bind packet socket to %s: %w", iface.Name, err)
+	}
+
+	return sock, nil
+}
+
+// respond sends resp, along with sock if it is not negative.
+func respond(fd int, resp Response, sock int) error {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+
+	var oob []byte
+	if sock >= 0 {
+		oob = unix.UnixRights(sock)
+	}
+
+	if err := unix.Sendmsg(fd, b, oob, nil, 0); err != nil {
+		return fmt.Errorf("unable to send response: %w", err)
+	}
+
+	return nil
+}
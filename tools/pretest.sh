@@ -22,5 +22,5 @@ fi
 DISTRO=$(printf '%s\n' "$DISTRO" | LC_ALL=C tr '[:upper:]' '[:lower:]') \
       TF_ACC=1 \
       unshare --user --map-root-user --net --mount \
-      sh -c 'go test -v -cover ./... -v -timeout 120m'
+      sh -c 'go test -v -cover ./... -v -timeout 120m "$@"' sh "$@"
 